@@ -18,9 +18,23 @@ type MenuRagToolRequest struct {
 	Query string `json:"query"`
 }
 
-func DefineAgenticRagFlow(g *genkit.Genkit, retriever ai.RetrieverArg) *core.Flow[*AgenticRagRequest, string, struct{}] {
+// menuSpecialist is the RAG menu agent, shared by agenticRagFlow and the
+// supervisor. It uses the default model.
+func menuSpecialist(menuRagTool ai.Tool) Specialist {
+	return Specialist{
+		Name:        "menuAgent",
+		Description: "Delegate a question about the food on the menu at Genkit Grub Pub.",
+		System: `You are a helpful AI assistant that can answer questions about the food available on the menu at Genkit Grub Pub.
+Use the provided tool to answer questions.
+If you don't know, do not make up an answer.
+Do not add or change items on the menu.`,
+		Tools: []ai.ToolRef{menuRagTool},
+	}
+}
 
-	menuRagTool := genkit.DefineTool(g,
+// DefineMenuRagTool defines the menuRagTool used by the menu agents to query the indexed menu.
+func DefineMenuRagTool(g *genkit.Genkit, retriever ai.RetrieverArg) ai.Tool {
+	return genkit.DefineTool(g,
 		"menuRagTool",
 		"Use to retrieve information from the Genkit Grub Pub menu.",
 		func(ctx *ai.ToolContext, req *MenuRagToolRequest) (string, error) {
@@ -42,16 +56,16 @@ func DefineAgenticRagFlow(g *genkit.Genkit, retriever ai.RetrieverArg) *core.Flo
 			return b.String(), nil
 		},
 	)
+}
 
+func DefineAgenticRagFlow(g *genkit.Genkit, menuRagTool ai.Tool) *core.Flow[*AgenticRagRequest, string, struct{}] {
+	agent := menuSpecialist(menuRagTool)
 	return genkit.DefineFlow(g, "agenticRagFlow",
 		func(ctx context.Context, req *AgenticRagRequest) (string, error) {
 			llmResponse, err := genkit.Generate(ctx, g,
 				ai.WithPrompt(req.Question, nil),
-				ai.WithTools(menuRagTool),
-				ai.WithSystem(`You are a helpful AI assistant that can answer questions about the food available on the menu at Genkit Grub Pub.
-Use the provided tool to answer questions.
-If you don't know, do not make up an answer.
-Do not add or change items on the menu.`),
+				ai.WithTools(agent.Tools...),
+				ai.WithSystem("%s", agent.System),
 			)
 			if err != nil {
 				return "", err
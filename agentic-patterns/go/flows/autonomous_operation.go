@@ -13,28 +13,29 @@ type ResearchAgentRequest struct {
 	Task string `json:"task"`
 }
 
-type SearchWebRequest struct {
-	Query string `json:"query"`
-}
-
 type AskUserRequest struct {
 	Question string `json:"question"`
 }
 
-func DefineResearchAgentFlow(g *genkit.Genkit) *core.Flow[*ResearchAgentRequest, string, struct{}] {
-	searchWeb := genkit.DefineTool(g,
-		"searchWeb",
-		"Search the web for information on a given topic.",
-		func(ctx *ai.ToolContext, req *SearchWebRequest) (string, error) {
-			// In a real app, you would implement a web search API call here.
-			return fmt.Sprintf("You found search results for: %s", req.Query), nil
-		},
-	)
+// researchSpecialist is the research agent, shared by researchAgent and the
+// supervisor.
+func researchSpecialist(searchWeb ai.Tool) Specialist {
+	return Specialist{
+		Name:        "researchAgent",
+		Description: "Delegate a research task that needs information from the web.",
+		System:      "You are a helpful research assistant. Your goal is to provide a comprehensive answer to the user's task.",
+		Model:       "googleai/gemini-2.5-pro",
+		Tools:       []ai.ToolRef{searchWeb},
+	}
+}
 
+func DefineResearchAgentFlow(g *genkit.Genkit, searchWeb ai.Tool) *core.Flow[*ResearchAgentRequest, string, *ProgressEvent] {
+	agent := researchSpecialist(searchWeb)
 	askUser := genkit.DefineTool(g,
 		"askUser",
 		"Ask the user a clarifying question.",
 		func(ctx *ai.ToolContext, req *AskUserRequest) (string, error) {
+			progressFromContext(ctx).toolCall(ctx, "askUser", req)
 			// This tool interrupts the flow to ask the user a question.
 			return "", ctx.Interrupt(&ai.InterruptOptions{
 				Metadata: map[string]any{
@@ -44,16 +45,24 @@ func DefineResearchAgentFlow(g *genkit.Genkit) *core.Flow[*ResearchAgentRequest,
 		},
 	)
 
-	return genkit.DefineFlow(g, "researchAgent",
-		func(ctx context.Context, req *ResearchAgentRequest) (string, error) {
+	tools := append(agent.Tools, askUser)
+
+	return genkit.DefineStreamingFlow(g, "researchAgent",
+		func(ctx context.Context, req *ResearchAgentRequest, sendChunk core.StreamCallback[*ProgressEvent]) (string, error) {
+			ctx, p, stop := newProgress(ctx, sendChunk)
+			defer stop()
+
+			p.stepStarted(ctx, "research", "Researching the task...")
 			response, err := genkit.Generate(ctx, g,
-				ai.WithSystem("You are a helpful research assistant. Your goal is to provide a comprehensive answer to the user's task."),
+				ai.WithSystem("%s", agent.System),
 				ai.WithPrompt("Your task is: %v. Use the available tools to accomplish this.", req.Task),
-				ai.WithModelName("googleai/gemini-2.5-pro"),
-				ai.WithTools(searchWeb, askUser),
+				ai.WithModelName(agent.Model),
+				ai.WithTools(tools...),
 				ai.WithMaxTurns(5), // Limit the number of back-and-forth turns
+				ai.WithStreaming(p.tokens("research")),
 			)
 			if err != nil {
+				p.stepFinished(ctx, "research", err)
 				return "", err
 			}
 
@@ -64,20 +73,24 @@ func DefineResearchAgentFlow(g *genkit.Genkit) *core.Flow[*ResearchAgentRequest,
 						// In a real app, you would present the question to the user and get their answer.
 						question := part.ToolRequest.Input.(map[string]any)["question"]
 						userAnswer := fmt.Sprintf("The user answered: \"Sample answer for '%s'\"", question)
+						p.emit(ctx, &ProgressEvent{Type: EventToolResult, Tool: "askUser", Output: userAnswer})
 						answers = append(answers, askUser.Respond(part, userAnswer, nil))
 					}
 				}
 
 				response, err = genkit.Generate(ctx, g,
 					ai.WithMessages(response.History()...),
-					ai.WithTools(searchWeb, askUser),
+					ai.WithTools(tools...),
 					ai.WithToolResponses(answers...),
+					ai.WithStreaming(p.tokens("research")),
 				)
 				if err != nil {
+					p.stepFinished(ctx, "research", err)
 					return "", err
 				}
 			}
 
+			p.stepFinished(ctx, "research", nil)
 			return response.Text(), nil
 		},
 	)
@@ -2,6 +2,10 @@ package flows
 
 import (
 	"context"
+	"fmt"
+	"strings"
+
+	"agentic-patterns/go/refine"
 
 	"github.com/firebase/genkit/go/ai"
 	"github.com/firebase/genkit/go/core"
@@ -10,48 +14,140 @@ import (
 
 type IterativeRefinementRequest struct {
 	Topic string `json:"topic"`
+	// GeneratorPrompt writes the first draft. "{{topic}}" is replaced with Topic.
+	GeneratorPrompt string `json:"generatorPrompt,omitempty"`
+	// EvaluatorPrompt describes how to critique a draft. "{{topic}}" is replaced with Topic.
+	EvaluatorPrompt string   `json:"evaluatorPrompt,omitempty"`
+	Criteria        []string `json:"criteria,omitempty"`
+	MaxIterations   int      `json:"maxIterations,omitempty"`
+	// TargetScore is nil for the default of 8. Zero disables it, so that the
+	// loop runs until Patience or MaxIterations stops it.
+	TargetScore    *float64 `json:"targetScore,omitempty" jsonschema_description:"Stop once a draft averages at least this score (1-10). Defaults to 8; 0 disables it"`
+	Patience       int      `json:"patience,omitempty" jsonschema_description:"Stop after this many drafts without improvement"`
+	MinImprovement float64  `json:"minImprovement,omitempty"`
 }
 
-type Evaluation struct {
-	Critique  string `json:"critique"`
-	Satisfied bool   `json:"satisfied"`
+const (
+	defaultGeneratorPrompt = "Write a short, single-paragraph blog post about: {{topic}}."
+	defaultEvaluatorPrompt = "Critique the following blog post about {{topic}}. Provide specific feedback for improvement."
+)
+
+var defaultCriteria = []string{"clarity", "concision", "engagement"}
+
+func DefineIterativeRefinementFlow(g *genkit.Genkit) *core.Flow[*IterativeRefinementRequest, *refine.Result, *ProgressEvent] {
+	return genkit.DefineStreamingFlow(g, "iterativeRefinementFlow",
+		func(ctx context.Context, req *IterativeRefinementRequest, sendChunk core.StreamCallback[*ProgressEvent]) (*refine.Result, error) {
+			ctx, p, stop := newProgress(ctx, sendChunk)
+			defer stop()
+			return newRefinementEngine(g, req, p).Run(ctx)
+		},
+	)
 }
 
-func DefineIterativeRefinementFlow(g *genkit.Genkit) *core.Flow[*IterativeRefinementRequest, string, struct{}] {
-	return genkit.DefineFlow(g, "iterativeRefinementFlow",
-		func(ctx context.Context, req *IterativeRefinementRequest) (string, error) {
-			// Step 1: Generate the initial draft.
+// newRefinementEngine wires the request's prompts and stop conditions into a
+// refine.Engine that reports each step to p.
+func newRefinementEngine(g *genkit.Genkit, req *IterativeRefinementRequest, p *progress) *refine.Engine {
+	topic := strings.NewReplacer("{{topic}}", req.Topic)
+	generatorPrompt := topic.Replace(valueOr(req.GeneratorPrompt, defaultGeneratorPrompt))
+	evaluatorPrompt := topic.Replace(valueOr(req.EvaluatorPrompt, defaultEvaluatorPrompt))
+	criteria := req.Criteria
+	if len(criteria) == 0 {
+		criteria = defaultCriteria
+	}
+	targetScore := 8.0
+	if req.TargetScore != nil {
+		targetScore = *req.TargetScore
+	}
+
+	return &refine.Engine{
+		// The "Generator" writes the first draft.
+		Generate: func(ctx context.Context) (string, error) {
+			p.stepStarted(ctx, "generate", "Writing the first draft...")
 			resp, err := genkit.Generate(ctx, g,
-				ai.WithPrompt("Write a short, single-paragraph blog post about: %v.", req.Topic),
+				ai.WithPrompt("%s", generatorPrompt),
+				ai.WithStreaming(p.tokens("generate")),
 			)
+			p.stepFinished(ctx, "generate", err)
 			if err != nil {
 				return "", err
 			}
-			content := resp.Text()
-
-			// Step 2: Iteratively refine the content.
-			for i := 0; i < 3; i++ {
-				// The "Evaluator" provides feedback.
-				eval, _, err := genkit.GenerateData[Evaluation](ctx, g,
-					ai.WithPrompt("Critique the following blog post. Is it clear, concise, and engaging? Provide specific feedback for improvement. Post: \"%v\"", content),
-				)
-				if err != nil {
-					return "", err
-				}
-				if eval.Satisfied {
-					break
-				}
-
-				// The "Optimizer" refines the content based on feedback.
-				resp, err := genkit.Generate(ctx, g,
-					ai.WithPrompt("Revise the following blog post based on the feedback provided.\nPost: \"%v\"\nFeedback: \"%v\"", content, eval.Critique),
-				)
-				if err != nil {
-					return "", err
-				}
-				content = resp.Text()
+			return resp.Text(), nil
+		},
+		// The "Evaluator" scores the draft against the rubric.
+		Evaluate: func(ctx context.Context, draft string) (*refine.Evaluation, error) {
+			p.stepStarted(ctx, "evaluate", "Critiquing the draft...")
+			eval, _, err := genkit.GenerateData[refine.Evaluation](ctx, g,
+				ai.WithPrompt("%s\n\nScore the draft from 1 to 10 on each of these criteria: %s.\n\nDraft: \"%s\"",
+					evaluatorPrompt, strings.Join(criteria, ", "), draft),
+			)
+			p.stepFinished(ctx, "evaluate", err)
+			if err != nil {
+				return nil, err
 			}
-			return content, nil
+			return normalizeEvaluation(eval, criteria), nil
 		},
-	)
+		// The "Optimizer" revises the best draft so far based on the feedback.
+		Revise: func(ctx context.Context, draft string, eval *refine.Evaluation) (string, error) {
+			var feedback strings.Builder
+			for _, s := range eval.Scores {
+				fmt.Fprintf(&feedback, "- %s (%.1f/10): %s\n", s.Criterion, s.Score, s.Feedback)
+			}
+			p.stepStarted(ctx, "revise", "Revising the best draft so far...")
+			resp, err := genkit.Generate(ctx, g,
+				ai.WithPrompt("The original task was: %s\n\nRevise the following draft based on the feedback provided. Return only the revised text.\nDraft: \"%s\"\nFeedback: \"%s\"\n%s",
+					generatorPrompt, draft, eval.Critique, feedback.String()),
+				ai.WithStreaming(p.tokens("revise")),
+			)
+			p.stepFinished(ctx, "revise", err)
+			if err != nil {
+				return "", err
+			}
+			return resp.Text(), nil
+		},
+		OnIteration: func(ctx context.Context, it refine.Iteration) error {
+			if err := p.emit(ctx, &ProgressEvent{Type: EventDraft, Iteration: &it.Iteration, Text: it.Draft}); err != nil {
+				return err
+			}
+			return p.emit(ctx, &ProgressEvent{
+				Type:      EventCritique,
+				Iteration: &it.Iteration,
+				Text:      it.Evaluation.Critique,
+				Score:     it.Score,
+				Output:    it.Evaluation.Scores,
+			})
+		},
+		Options: refine.Options{
+			MaxIterations:  req.MaxIterations,
+			TargetScore:    targetScore,
+			Patience:       req.Patience,
+			MinImprovement: req.MinImprovement,
+		},
+	}
+}
+
+// normalizeEvaluation keeps exactly one score per requested criterion, clamped
+// to the 1-10 scale, so that drafts are always compared on the same rubric.
+func normalizeEvaluation(eval *refine.Evaluation, criteria []string) *refine.Evaluation {
+	byName := make(map[string]refine.CriterionScore, len(eval.Scores))
+	for _, s := range eval.Scores {
+		byName[strings.ToLower(strings.TrimSpace(s.Criterion))] = s
+	}
+	out := &refine.Evaluation{Critique: eval.Critique}
+	for _, c := range criteria {
+		s, ok := byName[strings.ToLower(c)]
+		if !ok {
+			s = refine.CriterionScore{Score: 1, Feedback: "The evaluator did not score this criterion."}
+		}
+		s.Criterion = c
+		s.Score = min(max(s.Score, 1), 10)
+		out.Scores = append(out.Scores, s)
+	}
+	return out
+}
+
+func valueOr(s, fallback string) string {
+	if strings.TrimSpace(s) == "" {
+		return fallback
+	}
+	return s
 }
@@ -3,6 +3,9 @@ package flows
 import (
 	"context"
 	"fmt"
+	"time"
+
+	"agentic-patterns/go/parallel"
 
 	"github.com/firebase/genkit/go/ai"
 	"github.com/firebase/genkit/go/core"
@@ -11,67 +14,101 @@ import (
 
 type MarketingCopyRequest struct {
 	Product string `json:"product"`
+	// BestEffort returns whatever copy was generated even if some tasks fail.
+	// The product name is always required.
+	BestEffort bool `json:"bestEffort,omitempty"`
 }
 
 type MarketingCopyResponse struct {
-	Name    string `json:"name"`
-	Tagline string `json:"tagline"`
+	Name        string            `json:"name"`
+	Tagline     string            `json:"tagline"`
+	Description string            `json:"description,omitempty"`
+	Hashtags    []string          `json:"hashtags,omitempty"`
+	Errors      map[string]string `json:"errors,omitempty"`
 }
 
-func DefineMarketingCopyFlow(g *genkit.Genkit) *core.Flow[*MarketingCopyRequest, *MarketingCopyResponse, struct{}] {
-	return genkit.DefineFlow(g, "marketingCopyFlow",
-		func(ctx context.Context, req *MarketingCopyRequest) (*MarketingCopyResponse, error) {
-			type result struct {
-				key   string
-				value string
-				err   error
-			}
-			ch := make(chan result, 2)
-
-			// Task 1: Generate a creative name
-			go func() {
-				resp, err := genkit.Generate(ctx, g,
-					ai.WithPrompt("Generate a creative name for a new product: %v.", req.Product),
-				)
-				if err != nil {
-					ch <- result{key: "name", err: err}
-					return
-				}
-				ch <- result{key: "name", value: resp.Text()}
-			}()
+type Hashtags struct {
+	Hashtags []string `json:"hashtags" jsonschema_description:"3-5 social media hashtags, each starting with #"`
+}
 
-			// Task 2: Generate a catchy tagline
-			go func() {
-				resp, err := genkit.Generate(ctx, g,
-					ai.WithPrompt("Generate a catchy tagline for a new product: %v.", req.Product),
-				)
-				if err != nil {
-					ch <- result{key: "tagline", err: err}
-					return
-				}
-				ch <- result{key: "tagline", value: resp.Text()}
-			}()
+func DefineMarketingCopyFlow(g *genkit.Genkit) *core.Flow[*MarketingCopyRequest, *MarketingCopyResponse, *ProgressEvent] {
+	return genkit.DefineStreamingFlow(g, "marketingCopyFlow",
+		func(ctx context.Context, req *MarketingCopyRequest, sendChunk core.StreamCallback[*ProgressEvent]) (*MarketingCopyResponse, error) {
+			ctx, p, stop := newProgress(ctx, sendChunk)
+			defer stop()
+			opts := parallel.Options{
+				Policy:         parallel.FailFast,
+				MaxConcurrency: 3,
+				TaskTimeout:    30 * time.Second,
+			}
+			if req.BestEffort {
+				opts.Policy = parallel.BestEffort
+			}
+			group := parallel.NewGroup(ctx, opts)
 
-			response := &MarketingCopyResponse{}
-			var errs []error
-			for i := 0; i < 2; i++ {
-				r := <-ch
-				if r.err != nil {
-					errs = append(errs, r.err)
-				} else {
-					if r.key == "name" {
-						response.Name = r.value
-					} else if r.key == "tagline" {
-						response.Tagline = r.value
+			generateText := func(step, prompt string) func(context.Context) (string, error) {
+				return reportStep(p, step, func(ctx context.Context) (string, error) {
+					resp, err := genkit.Generate(ctx, g,
+						ai.WithPrompt(prompt, req.Product),
+						ai.WithStreaming(p.tokens(step)),
+					)
+					if err != nil {
+						return "", err
 					}
-				}
+					return resp.Text(), nil
+				})
 			}
 
-			if len(errs) > 0 {
-				return nil, fmt.Errorf("failed to generate marketing copy: %v", errs)
+			// Each task is an independent generation that runs concurrently.
+			name := parallel.Go(group, "name",
+				generateText("name", "Generate a creative name for a new product: %v."),
+				parallel.Required(),
+			)
+			tagline := parallel.Go(group, "tagline",
+				generateText("tagline", "Generate a catchy tagline for a new product: %v."),
+			)
+			description := parallel.Go(group, "description",
+				generateText("description", "Write a two-sentence product description for a new product: %v."),
+			)
+			hashtags := parallel.Go(group, "hashtags",
+				reportStep(p, "hashtags", func(ctx context.Context) ([]string, error) {
+					tags, _, err := genkit.GenerateData[Hashtags](ctx, g,
+						ai.WithPrompt("Suggest social media hashtags to launch a new product: %v.", req.Product),
+					)
+					if err != nil {
+						return nil, err
+					}
+					return tags.Hashtags, nil
+				}),
+			)
+
+			if err := group.Wait(); err != nil {
+				return nil, fmt.Errorf("failed to generate marketing copy: %w", err)
 			}
 
+			response := &MarketingCopyResponse{
+				Name:        name.Value(),
+				Tagline:     tagline.Value(),
+				Description: description.Value(),
+				Hashtags:    hashtags.Value(),
+			}
+			if errs := group.Errors(); len(errs) > 0 {
+				response.Errors = make(map[string]string, len(errs))
+				for task, err := range errs {
+					response.Errors[task] = err.Error()
+				}
+			}
 			return response, nil
 		},
 	)
 }
+
+// reportStep wraps a parallel task so that its start and end are streamed as progress events.
+func reportStep[T any](p *progress, step string, fn func(context.Context) (T, error)) func(context.Context) (T, error) {
+	return func(ctx context.Context) (T, error) {
+		p.stepStarted(ctx, step, "")
+		v, err := fn(ctx)
+		p.stepFinished(ctx, step, err)
+		return v, err
+	}
+}
@@ -0,0 +1,376 @@
+package flows
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/core"
+	"github.com/firebase/genkit/go/genkit"
+)
+
+// EventPlanUpdated carries a snapshot of the whole PlanState in Output.
+const EventPlanUpdated ProgressEventType = "plan_updated"
+
+type PlanAndExecuteRequest struct {
+	Goal string `json:"goal"`
+	// MaxReplans limits how many times the plan is revised after a step fails. Defaults to 2.
+	MaxReplans int `json:"maxReplans,omitempty"`
+	// MaxParallelSteps limits how many independent steps run at once. Defaults to 3.
+	MaxParallelSteps int `json:"maxParallelSteps,omitempty"`
+}
+
+type PlanStep struct {
+	ID          string   `json:"id" jsonschema_description:"A short unique identifier such as 's1'"`
+	Description string   `json:"description" jsonschema_description:"What this step must accomplish, in one or two sentences"`
+	DependsOn   []string `json:"dependsOn,omitempty" jsonschema_description:"IDs of the steps whose results this step needs"`
+}
+
+type Plan struct {
+	Steps []PlanStep `json:"steps"`
+}
+
+type StepStatus string
+
+const (
+	StepPending    StepStatus = "pending"
+	StepRunning    StepStatus = "running"
+	StepDone       StepStatus = "done"
+	StepFailed     StepStatus = "failed"
+	StepSuperseded StepStatus = "superseded"
+)
+
+type PlanStepState struct {
+	PlanStep
+	Status StepStatus `json:"status"`
+	Result string     `json:"result,omitempty"`
+	Error  string     `json:"error,omitempty"`
+}
+
+type PlanState struct {
+	Goal    string           `json:"goal"`
+	Steps   []*PlanStepState `json:"steps"`
+	Replans int              `json:"replans"`
+	Answer  string           `json:"answer,omitempty"`
+}
+
+func DefinePlanAndExecuteFlow(g *genkit.Genkit, tools ...ai.ToolRef) *core.Flow[*PlanAndExecuteRequest, *PlanState, *ProgressEvent] {
+	return genkit.DefineStreamingFlow(g, "planAndExecuteFlow",
+		func(ctx context.Context, req *PlanAndExecuteRequest, sendChunk core.StreamCallback[*ProgressEvent]) (*PlanState, error) {
+			ctx, p, stop := newProgress(ctx, sendChunk)
+			defer stop()
+
+			maxReplans := req.MaxReplans
+			if maxReplans <= 0 {
+				maxReplans = 2
+			}
+			maxParallel := req.MaxParallelSteps
+			if maxParallel <= 0 {
+				maxParallel = 3
+			}
+
+			state := &PlanState{Goal: req.Goal}
+			publish := func() {
+				p.emit(ctx, &ProgressEvent{Type: EventPlanUpdated, Output: state.snapshot()})
+			}
+
+			// Step 1: The "Planner" breaks the goal into a DAG of steps.
+			p.stepStarted(ctx, "plan", "Planning...")
+			plan, _, err := genkit.GenerateData[Plan](ctx, g,
+				ai.WithPrompt(`Break the following goal into a short plan of at most 8 steps. Each step should be small enough to complete with a single web search or a short piece of reasoning.
+List in dependsOn the IDs of the earlier steps whose results a step needs; steps without dependencies will run in parallel.
+
+Goal: %s`, req.Goal),
+			)
+			if err == nil {
+				err = state.addSteps(plan.Steps)
+			}
+			p.stepFinished(ctx, "plan", err)
+			if err != nil {
+				return nil, fmt.Errorf("failed to create plan: %w", err)
+			}
+			publish()
+
+			// Step 2: The "Executor" runs every step whose dependencies are done,
+			// starting newly unblocked steps as soon as their inputs are ready.
+			type stepResult struct {
+				step   *PlanStepState
+				result string
+				err    error
+			}
+			results := make(chan stepResult, maxParallel)
+			running := 0
+			failed := false
+			for {
+				if !failed {
+					launched := false
+					for _, step := range state.readySteps() {
+						if running >= maxParallel {
+							break
+						}
+						step.Status = StepRunning
+						running++
+						launched = true
+						prompt := state.stepPrompt(step)
+						p.stepStarted(ctx, step.ID, step.Description)
+						go func() {
+							result, err := executeStep(ctx, g, prompt, tools)
+							results <- stepResult{step: step, result: result, err: err}
+						}()
+					}
+					if launched {
+						publish()
+					}
+				}
+
+				if running == 0 {
+					if failed {
+						// Step 3: The "Replanner" revises the remaining work around the failure.
+						if state.Replans >= maxReplans {
+							return state, fmt.Errorf("plan failed after %d replans", state.Replans)
+						}
+						if err := replan(ctx, g, p, state); err != nil {
+							return state, err
+						}
+						failed = false
+						publish()
+						continue
+					}
+					if state.hasPending() {
+						return state, errors.New("plan cannot make progress: remaining steps have unmet dependencies")
+					}
+					break
+				}
+
+				r := <-results
+				running--
+				p.stepFinished(ctx, r.step.ID, r.err)
+				if r.err != nil {
+					if ctx.Err() != nil {
+						return state, ctx.Err()
+					}
+					r.step.Status = StepFailed
+					r.step.Error = r.err.Error()
+					failed = true
+				} else {
+					r.step.Status = StepDone
+					r.step.Result = r.result
+				}
+				publish()
+			}
+
+			// Step 4: Combine the step results into the final answer.
+			p.stepStarted(ctx, "answer", "Writing the final answer...")
+			resp, err := genkit.Generate(ctx, g,
+				ai.WithPrompt("Using the results of the completed plan below, write the final answer to the goal.\n\nGoal: %s\n\n%s", req.Goal, state.completedSummary()),
+				ai.WithStreaming(p.tokens("answer")),
+			)
+			p.stepFinished(ctx, "answer", err)
+			if err != nil {
+				return state, err
+			}
+			state.Answer = resp.Text()
+			publish()
+			return state, nil
+		},
+	)
+}
+
+// executeStep runs a single plan step with the executor's tools.
+func executeStep(ctx context.Context, g *genkit.Genkit, prompt string, tools []ai.ToolRef) (string, error) {
+	resp, err := genkit.Generate(ctx, g,
+		ai.WithSystem("You are executing one step of a larger plan. Use the available tools when they help. Reply with the result of this step only."),
+		ai.WithPrompt("%s", prompt),
+		ai.WithTools(tools...),
+		ai.WithMaxTurns(5),
+	)
+	if err != nil {
+		return "", err
+	}
+	return resp.Text(), nil
+}
+
+// replan asks the model for a new set of remaining steps, given what has been
+// done and what failed, and replaces the unfinished steps with it.
+func replan(ctx context.Context, g *genkit.Genkit, p *progress, state *PlanState) error {
+	var failedSteps, pendingSteps strings.Builder
+	for _, s := range state.Steps {
+		switch s.Status {
+		case StepFailed:
+			fmt.Fprintf(&failedSteps, "- %s: %s (error: %s)\n", s.ID, s.Description, s.Error)
+		case StepPending:
+			fmt.Fprintf(&pendingSteps, "- %s: %s\n", s.ID, s.Description)
+		}
+	}
+
+	p.stepStarted(ctx, "replan", "Revising the plan...")
+	plan, _, err := genkit.GenerateData[Plan](ctx, g,
+		ai.WithPrompt(`A plan to achieve the goal below has partially failed. Write a revised plan for the remaining work only.
+Do not repeat completed steps; new steps may depend on them by ID. Use step IDs that have not been used before.
+
+Goal: %s
+
+Completed steps:
+%s
+Failed steps:
+%s
+Steps that had not run yet:
+%s`, state.Goal, state.completedSummary(), failedSteps.String(), pendingSteps.String()),
+	)
+	if err == nil {
+		for _, s := range state.Steps {
+			if s.Status == StepPending {
+				s.Status = StepSuperseded
+			}
+		}
+		state.Replans++
+		err = state.addSteps(plan.Steps)
+	}
+	p.stepFinished(ctx, "replan", err)
+	if err != nil {
+		return fmt.Errorf("failed to revise plan: %w", err)
+	}
+	return nil
+}
+
+// addSteps validates new steps against the current state and appends them as pending.
+// Steps may only depend on completed steps or on other new steps, and must not form a cycle.
+func (s *PlanState) addSteps(steps []PlanStep) error {
+	if len(steps) == 0 {
+		return errors.New("plan has no steps")
+	}
+	used := make(map[string]bool)
+	done := make(map[string]bool)
+	for _, st := range s.Steps {
+		used[st.ID] = true
+		done[st.ID] = st.Status == StepDone
+	}
+	deps := make(map[string][]string, len(steps))
+	for _, st := range steps {
+		if st.ID == "" {
+			return errors.New("plan step is missing an ID")
+		}
+		if used[st.ID] {
+			return fmt.Errorf("duplicate plan step ID %q", st.ID)
+		}
+		used[st.ID] = true
+		deps[st.ID] = st.DependsOn
+	}
+	for id, ds := range deps {
+		for _, d := range ds {
+			if _, ok := deps[d]; !ok && !done[d] {
+				return fmt.Errorf("step %q depends on unknown or unfinished step %q", id, d)
+			}
+		}
+	}
+
+	// Detect cycles among the new steps with a depth-first search.
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	marks := make(map[string]int, len(deps))
+	var visit func(id string) error
+	visit = func(id string) error {
+		switch marks[id] {
+		case visiting:
+			return fmt.Errorf("plan has a dependency cycle through step %q", id)
+		case visited:
+			return nil
+		}
+		marks[id] = visiting
+		for _, d := range deps[id] {
+			if _, ok := deps[d]; ok {
+				if err := visit(d); err != nil {
+					return err
+				}
+			}
+		}
+		marks[id] = visited
+		return nil
+	}
+	for _, st := range steps {
+		if err := visit(st.ID); err != nil {
+			return err
+		}
+	}
+
+	for _, st := range steps {
+		s.Steps = append(s.Steps, &PlanStepState{PlanStep: st, Status: StepPending})
+	}
+	return nil
+}
+
+// readySteps returns the pending steps whose dependencies have all completed.
+func (s *PlanState) readySteps() []*PlanStepState {
+	status := make(map[string]StepStatus, len(s.Steps))
+	for _, st := range s.Steps {
+		status[st.ID] = st.Status
+	}
+	var ready []*PlanStepState
+	for _, st := range s.Steps {
+		if st.Status != StepPending {
+			continue
+		}
+		ok := true
+		for _, d := range st.DependsOn {
+			if status[d] != StepDone {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			ready = append(ready, st)
+		}
+	}
+	return ready
+}
+
+func (s *PlanState) hasPending() bool {
+	for _, st := range s.Steps {
+		if st.Status == StepPending {
+			return true
+		}
+	}
+	return false
+}
+
+// stepPrompt builds the executor prompt for step, including its dependencies' results.
+func (s *PlanState) stepPrompt(step *PlanStepState) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Overall goal: %s\n\nYour step: %s\n", s.Goal, step.Description)
+	if len(step.DependsOn) > 0 {
+		b.WriteString("\nResults of the steps this one depends on:\n")
+		for _, st := range s.Steps {
+			for _, d := range step.DependsOn {
+				if st.ID == d {
+					fmt.Fprintf(&b, "- %s: %s\n", st.Description, st.Result)
+				}
+			}
+		}
+	}
+	return b.String()
+}
+
+func (s *PlanState) completedSummary() string {
+	var b strings.Builder
+	for _, st := range s.Steps {
+		if st.Status == StepDone {
+			fmt.Fprintf(&b, "- %s: %s\n  Result: %s\n", st.ID, st.Description, st.Result)
+		}
+	}
+	return b.String()
+}
+
+// snapshot returns a copy of the state that is safe to stream while execution continues.
+func (s *PlanState) snapshot() *PlanState {
+	c := *s
+	c.Steps = make([]*PlanStepState, len(s.Steps))
+	for i, st := range s.Steps {
+		cp := *st
+		c.Steps[i] = &cp
+	}
+	return &c
+}
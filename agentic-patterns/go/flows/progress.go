@@ -0,0 +1,129 @@
+package flows
+
+import (
+	"context"
+	"sync"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/core"
+)
+
+// ProgressEventType identifies what a ProgressEvent reports.
+type ProgressEventType string
+
+const (
+	EventStepStarted  ProgressEventType = "step_started"
+	EventStepFinished ProgressEventType = "step_finished"
+	EventDraft        ProgressEventType = "draft"
+	EventCritique     ProgressEventType = "critique"
+	EventToolCall     ProgressEventType = "tool_call"
+	EventToolResult   ProgressEventType = "tool_result"
+	EventToken        ProgressEventType = "token"
+)
+
+// ProgressEvent is the stream chunk shared by every agentic-patterns streaming
+// flow, so a frontend can render any of them with the same component.
+type ProgressEvent struct {
+	Type ProgressEventType `json:"type"`
+	// Step names the unit of work the event belongs to, e.g. "idea" or "tagline".
+	Step    string `json:"step,omitempty"`
+	Message string `json:"message,omitempty"`
+	// Text carries a partial token, a draft or a critique, depending on Type.
+	Text      string  `json:"text,omitempty"`
+	Iteration *int    `json:"iteration,omitempty"`
+	Score     float64 `json:"score,omitempty"`
+	Tool      string  `json:"tool,omitempty"`
+	Input     any     `json:"input,omitempty"`
+	Output    any     `json:"output,omitempty"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// progress sends ProgressEvents to a flow's stream. It is safe for concurrent
+// use and does nothing when the flow was invoked without streaming.
+//
+// A failed send usually means the client has gone, so the first one cancels
+// the flow's context with the send error as its cause: model calls still in
+// flight are aborted and the flow returns instead of paying for output nobody
+// will read. Callers therefore need not check the result of every report.
+type progress struct {
+	mu     sync.Mutex
+	send   core.StreamCallback[*ProgressEvent]
+	cancel context.CancelCauseFunc
+	err    error
+}
+
+// newProgress returns a progress reporter for send and the context the flow
+// should run under: it carries the reporter for tools and is cancelled by
+// the first failed send. The flow must call stop when it returns.
+func newProgress(ctx context.Context, send core.StreamCallback[*ProgressEvent]) (context.Context, *progress, context.CancelFunc) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	p := &progress{send: send, cancel: cancel}
+	return withProgress(ctx, p), p, func() { cancel(nil) }
+}
+
+// emit sends ev, returning the first send error once one has occurred.
+func (p *progress) emit(ctx context.Context, ev *ProgressEvent) error {
+	if p == nil || p.send == nil {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err != nil {
+		return p.err
+	}
+	if err := p.send(ctx, ev); err != nil {
+		p.err = err
+		p.cancel(err)
+		return err
+	}
+	return nil
+}
+
+func (p *progress) stepStarted(ctx context.Context, step, message string) {
+	p.emit(ctx, &ProgressEvent{Type: EventStepStarted, Step: step, Message: message})
+}
+
+// stepFinished reports the end of a step, successful or not.
+func (p *progress) stepFinished(ctx context.Context, step string, err error) {
+	ev := &ProgressEvent{Type: EventStepFinished, Step: step}
+	if err != nil {
+		ev.Error = err.Error()
+	}
+	p.emit(ctx, ev)
+}
+
+// tokens returns a model streaming callback that forwards partial text as
+// EventToken events for step.
+func (p *progress) tokens(step string) ai.ModelStreamCallback {
+	return func(ctx context.Context, chunk *ai.ModelResponseChunk) error {
+		if text := chunk.Text(); text != "" {
+			return p.emit(ctx, &ProgressEvent{Type: EventToken, Step: step, Text: text})
+		}
+		return nil
+	}
+}
+
+// toolCall reports a tool invocation and returns a function that reports its result.
+func (p *progress) toolCall(ctx context.Context, tool string, input any) func(output any, err error) {
+	p.emit(ctx, &ProgressEvent{Type: EventToolCall, Tool: tool, Input: input})
+	return func(output any, err error) {
+		ev := &ProgressEvent{Type: EventToolResult, Tool: tool, Output: output}
+		if err != nil {
+			ev.Error = err.Error()
+		}
+		p.emit(ctx, ev)
+	}
+}
+
+type progressKey struct{}
+
+// withProgress makes p available to tools invoked under ctx.
+func withProgress(ctx context.Context, p *progress) context.Context {
+	return context.WithValue(ctx, progressKey{}, p)
+}
+
+// progressFromContext returns the progress reporter of the calling flow, or nil.
+func progressFromContext(ctx context.Context) *progress {
+	p, _ := ctx.Value(progressKey{}).(*progress)
+	return p
+}
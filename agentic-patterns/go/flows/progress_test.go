@@ -0,0 +1,43 @@
+package flows
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestProgressStopsOnFirstSendError(t *testing.T) {
+	errGone := errors.New("client gone")
+	sent := 0
+	ctx, p, stop := newProgress(context.Background(), func(ctx context.Context, ev *ProgressEvent) error {
+		sent++
+		return errGone
+	})
+	defer stop()
+
+	p.stepStarted(ctx, "idea", "Coming up with a story idea...")
+	if !errors.Is(context.Cause(ctx), errGone) {
+		t.Fatalf("context cause = %v, want the send error", context.Cause(ctx))
+	}
+	if err := p.emit(ctx, &ProgressEvent{Type: EventDraft}); !errors.Is(err, errGone) {
+		t.Errorf("emit after a failed send = %v, want the send error", err)
+	}
+	if sent != 1 {
+		t.Errorf("send called %d times, want 1", sent)
+	}
+}
+
+func TestProgressWithoutStreaming(t *testing.T) {
+	ctx, p, stop := newProgress(context.Background(), nil)
+	p.stepFinished(ctx, "idea", errors.New("failed"))
+	if ctx.Err() != nil {
+		t.Errorf("context cancelled without a stream: %v", context.Cause(ctx))
+	}
+	stop()
+	if ctx.Err() == nil {
+		t.Error("context not cancelled by stop")
+	}
+	if progressFromContext(ctx) != p {
+		t.Error("progressFromContext did not return the flow's reporter")
+	}
+}
@@ -21,21 +21,30 @@ type ImageGeneratorRequest struct {
 	Concept string `json:"concept"`
 }
 
-func DefineStoryWriterFlow(g *genkit.Genkit) *core.Flow[*StoryWriterRequest, string, struct{}] {
-	return genkit.DefineFlow(g, "storyWriterFlow",
-		func(ctx context.Context, req *StoryWriterRequest) (string, error) {
+func DefineStoryWriterFlow(g *genkit.Genkit) *core.Flow[*StoryWriterRequest, string, *ProgressEvent] {
+	return genkit.DefineStreamingFlow(g, "storyWriterFlow",
+		func(ctx context.Context, req *StoryWriterRequest, sendChunk core.StreamCallback[*ProgressEvent]) (string, error) {
+			ctx, p, stop := newProgress(ctx, sendChunk)
+			defer stop()
+
 			// Step 1: Generate a creative story idea
+			p.stepStarted(ctx, "idea", "Coming up with a story idea...")
 			idea, _, err := genkit.GenerateData[StoryIdea](ctx, g,
 				ai.WithPrompt("Generate a unique story idea about a %v.", req.Topic),
 			)
+			p.stepFinished(ctx, "idea", err)
 			if err != nil {
 				return "", err
 			}
+			p.emit(ctx, &ProgressEvent{Type: EventDraft, Step: "idea", Text: idea.Idea})
 
 			// Step 2: Use the idea to write the beginning of the story
+			p.stepStarted(ctx, "story", "Writing the opening paragraph...")
 			storyResponse, err := genkit.Generate(ctx, g,
 				ai.WithPrompt("Write the opening paragraph for a story based on this idea: %v", idea.Idea),
+				ai.WithStreaming(p.tokens("story")),
 			)
+			p.stepFinished(ctx, "story", err)
 			if err != nil {
 				return "", err
 			}
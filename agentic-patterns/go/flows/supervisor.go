@@ -0,0 +1,235 @@
+package flows
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/core"
+	"github.com/firebase/genkit/go/genkit"
+)
+
+type SupervisorRequest struct {
+	Task string `json:"task"`
+	// MaxDepth limits how deeply agents may delegate to one another. Defaults to 2,
+	// which lets a specialist consult one other specialist.
+	MaxDepth int `json:"maxDepth,omitempty"`
+}
+
+// Delegation records one sub-task handed to a specialist agent.
+type Delegation struct {
+	ID int `json:"id"`
+	// ParentID is the delegation that requested this one, or 0 for the orchestrator.
+	ParentID int    `json:"parentId,omitempty"`
+	Depth    int    `json:"depth"`
+	Agent    string `json:"agent"`
+	Model    string `json:"model"`
+	Task     string `json:"task"`
+	Result   string `json:"result,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+type SupervisorResponse struct {
+	Answer string        `json:"answer"`
+	Trace  []*Delegation `json:"trace"`
+}
+
+type DelegateRequest struct {
+	Task string `json:"task" jsonschema_description:"A self-contained description of the sub-task, including any context the specialist needs"`
+}
+
+// Specialist describes an agent that the orchestrator can delegate to.
+type Specialist struct {
+	// Name is used as the delegation tool name.
+	Name        string
+	Description string
+	System      string
+	// Model defaults to the default model.
+	Model string
+	Tools []ai.ToolRef
+	// Run, if set, performs the task with an existing flow instead of an
+	// agent built from System, Model and Tools. Such a specialist cannot
+	// delegate further.
+	Run func(ctx context.Context, task string) (string, error)
+}
+
+const supervisorSystemPrompt = `You are an orchestrator that coordinates a team of specialist agents.
+Break the user's task into sub-tasks and delegate each one to the most suitable specialist using the tools provided.
+Give each specialist a self-contained task. When you have what you need, combine the specialists' results into a single answer.`
+
+// DefineSupervisorFlow exposes the research, menu and story writing agents as
+// tools of an orchestrator agent. The research and menu agents are the ones
+// researchAgent and agenticRagFlow run, and may in turn delegate to each
+// other, up to the requested depth; the story writer runs storyWriter.
+func DefineSupervisorFlow(g *genkit.Genkit, searchWeb, menuRagTool ai.Tool, storyWriter *core.Flow[*StoryWriterRequest, string, *ProgressEvent]) *core.Flow[*SupervisorRequest, *SupervisorResponse, *ProgressEvent] {
+	specialists := []Specialist{
+		researchSpecialist(searchWeb),
+		menuSpecialist(menuRagTool),
+		{
+			Name:        "storyWriterAgent",
+			Description: "Delegate writing the opening of a story. Give the topic of the story as the task.",
+			Run: func(ctx context.Context, task string) (string, error) {
+				return storyWriter.Run(ctx, &StoryWriterRequest{Topic: task})
+			},
+		},
+	}
+
+	var delegateTools []ai.ToolRef
+	for _, spec := range specialists {
+		delegateTools = append(delegateTools, defineDelegateTool(g, spec, specialists))
+	}
+
+	return genkit.DefineStreamingFlow(g, "supervisorFlow",
+		func(ctx context.Context, req *SupervisorRequest, sendChunk core.StreamCallback[*ProgressEvent]) (*SupervisorResponse, error) {
+			ctx, p, stop := newProgress(ctx, sendChunk)
+			defer stop()
+
+			maxDepth := req.MaxDepth
+			if maxDepth <= 0 {
+				maxDepth = 2
+			}
+			trace := &delegationTrace{}
+			ctx = withDelegation(ctx, &delegationScope{trace: trace, maxDepth: maxDepth})
+
+			p.stepStarted(ctx, "orchestrator", "Delegating the task to specialists...")
+			resp, err := genkit.Generate(ctx, g,
+				ai.WithSystem(supervisorSystemPrompt),
+				ai.WithPrompt("%s", req.Task),
+				ai.WithTools(delegateTools...),
+				ai.WithMaxTurns(8),
+				ai.WithStreaming(p.tokens("orchestrator")),
+			)
+			p.stepFinished(ctx, "orchestrator", err)
+			if err != nil {
+				return nil, err
+			}
+			return &SupervisorResponse{Answer: resp.Text(), Trace: trace.list()}, nil
+		},
+	)
+}
+
+// defineDelegateTool registers a tool that runs spec as a sub-agent. Unless
+// the delegation depth limit has been reached, the sub-agent can also
+// delegate to the other specialists.
+func defineDelegateTool(g *genkit.Genkit, spec Specialist, specialists []Specialist) ai.Tool {
+	var peers []ai.ToolRef
+	for _, other := range specialists {
+		if other.Name != spec.Name && other.Run == nil {
+			peers = append(peers, ai.ToolName(other.Name))
+		}
+	}
+
+	return genkit.DefineTool(g, spec.Name, spec.Description,
+		func(ctx *ai.ToolContext, req *DelegateRequest) (string, error) {
+			scope := delegationFromContext(ctx)
+			if scope.depth >= scope.maxDepth {
+				return "Delegation depth limit reached. Complete this sub-task yourself.", nil
+			}
+
+			d := scope.trace.start(&Delegation{
+				ParentID: scope.parentID,
+				Depth:    scope.depth + 1,
+				Agent:    spec.Name,
+				Model:    spec.Model,
+				Task:     req.Task,
+			})
+			done := progressFromContext(ctx).toolCall(ctx, spec.Name, req)
+			finish := func(result string, err error) (string, error) {
+				scope.trace.finish(d.ID, result, err)
+				if err != nil {
+					done(nil, err)
+					// Report the failure to the calling agent so it can adapt instead of aborting.
+					return fmt.Sprintf("The %s could not complete this task: %v", spec.Name, err), nil
+				}
+				done(result, nil)
+				return result, nil
+			}
+
+			if spec.Run != nil {
+				return finish(spec.Run(ctx, req.Task))
+			}
+
+			tools := spec.Tools
+			if d.Depth < scope.maxDepth {
+				tools = append(append([]ai.ToolRef{}, spec.Tools...), peers...)
+			}
+			opts := []ai.GenerateOption{
+				ai.WithSystem("%s", spec.System),
+				ai.WithPrompt("%s", req.Task),
+				ai.WithMaxTurns(5),
+			}
+			if spec.Model != "" {
+				opts = append(opts, ai.WithModelName(spec.Model))
+			}
+			if len(tools) > 0 {
+				opts = append(opts, ai.WithTools(tools...))
+			}
+
+			childCtx := withDelegation(ctx, &delegationScope{
+				trace:    scope.trace,
+				depth:    d.Depth,
+				parentID: d.ID,
+				maxDepth: scope.maxDepth,
+			})
+			resp, err := genkit.Generate(childCtx, g, opts...)
+			if err != nil {
+				return finish("", err)
+			}
+			return finish(resp.Text(), nil)
+		},
+	)
+}
+
+// delegationTrace collects the delegations made during one supervisor run.
+type delegationTrace struct {
+	mu          sync.Mutex
+	delegations []*Delegation
+}
+
+func (t *delegationTrace) start(d *Delegation) *Delegation {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	d.ID = len(t.delegations) + 1
+	t.delegations = append(t.delegations, d)
+	return d
+}
+
+func (t *delegationTrace) finish(id int, result string, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	d := t.delegations[id-1]
+	d.Result = result
+	if err != nil {
+		d.Error = err.Error()
+	}
+}
+
+func (t *delegationTrace) list() []*Delegation {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]*Delegation{}, t.delegations...)
+}
+
+// delegationScope is the position of the current agent in the delegation tree.
+type delegationScope struct {
+	trace    *delegationTrace
+	depth    int
+	parentID int
+	maxDepth int
+}
+
+type delegationKey struct{}
+
+func withDelegation(ctx context.Context, scope *delegationScope) context.Context {
+	return context.WithValue(ctx, delegationKey{}, scope)
+}
+
+// delegationFromContext returns the current delegation scope. Tools invoked
+// outside a supervisor run, e.g. from the Developer UI, get a fresh scope.
+func delegationFromContext(ctx context.Context) *delegationScope {
+	if scope, ok := ctx.Value(delegationKey{}).(*delegationScope); ok {
+		return scope
+	}
+	return &delegationScope{trace: &delegationTrace{}, maxDepth: 2}
+}
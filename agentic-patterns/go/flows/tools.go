@@ -0,0 +1,27 @@
+package flows
+
+import (
+	"fmt"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/genkit"
+)
+
+type SearchWebRequest struct {
+	Query string `json:"query"`
+}
+
+// DefineSearchWebTool defines the searchWeb tool shared by the research and planning agents.
+func DefineSearchWebTool(g *genkit.Genkit) ai.Tool {
+	return genkit.DefineTool(g,
+		"searchWeb",
+		"Search the web for information on a given topic.",
+		func(ctx *ai.ToolContext, req *SearchWebRequest) (string, error) {
+			done := progressFromContext(ctx).toolCall(ctx, "searchWeb", req)
+			// In a real app, you would implement a web search API call here.
+			result := fmt.Sprintf("You found search results for: %s", req.Query)
+			done(result, nil)
+			return result, nil
+		},
+	)
+}
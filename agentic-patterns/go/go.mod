@@ -2,7 +2,10 @@ module agentic-patterns/go
 
 go 1.24.5
 
-require github.com/firebase/genkit/go v1.0.5
+require (
+	flow-transports/go v0.0.0
+	github.com/firebase/genkit/go v1.0.5
+)
 
 require (
 	cloud.google.com/go v0.120.0 // indirect
@@ -45,3 +48,5 @@ require (
 	google.golang.org/protobuf v1.36.6 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
+
+replace flow-transports/go => ../../flow-transports/go
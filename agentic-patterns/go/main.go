@@ -4,9 +4,12 @@ import (
 	"context"
 	"log"
 	"net/http"
+	"os"
 
 	"agentic-patterns/go/flows"
 
+	"flow-transports/go/transport"
+
 	"github.com/firebase/genkit/go/genkit"
 	"github.com/firebase/genkit/go/plugins/googlegenai"
 	"github.com/firebase/genkit/go/plugins/localvec"
@@ -35,42 +38,85 @@ func main() {
 	routerFlow := flows.DefineRouterFlow(g)
 	marketingCopyFlow := flows.DefineMarketingCopyFlow(g)
 	toolCallingFlow := flows.DefineToolCallingFlow(g)
-	agenticRagFlow := flows.DefineAgenticRagFlow(g, retriever)
+	menuRagTool := flows.DefineMenuRagTool(g, retriever)
+	agenticRagFlow := flows.DefineAgenticRagFlow(g, menuRagTool)
 	indexMenuFlow := flows.DefineIndexMenuFlow(g, docStore)
 	iterativeRefinementFlow := flows.DefineIterativeRefinementFlow(g)
-	researchAgentFlow := flows.DefineResearchAgentFlow(g)
+	searchWeb := flows.DefineSearchWebTool(g)
+	researchAgentFlow := flows.DefineResearchAgentFlow(g, searchWeb)
+	planAndExecuteFlow := flows.DefinePlanAndExecuteFlow(g, searchWeb)
+	supervisorFlow := flows.DefineSupervisorFlow(g, searchWeb, menuRagTool, storyWriterFlow)
 	statefulChatFlow := flows.DefineStatefulChatFlow(g)
 
+	// Every flow can also run as a job, which is kept in JOBS_DIR so that it
+	// survives a restart.
+	jobsDir := os.Getenv("JOBS_DIR")
+	if jobsDir == "" {
+		jobsDir = "data/jobs"
+	}
+	jobStore, err := transport.NewDirStore(jobsDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	jobs, err := transport.NewJobs(transport.JobsOptions{
+		Store:         jobStore,
+		WebhookSecret: os.Getenv("WEBHOOK_SECRET"),
+		// Webhooks to local addresses are only for development.
+		AllowPrivateCallbacks: os.Getenv("ALLOW_PRIVATE_CALLBACKS") == "true",
+	}, storyWriterFlow, imageGeneratorFlow, routerFlow, marketingCopyFlow, toolCallingFlow,
+		agenticRagFlow, indexMenuFlow, iterativeRefinementFlow, researchAgentFlow,
+		planAndExecuteFlow, supervisorFlow, statefulChatFlow)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	mux := http.NewServeMux()
 	mux.HandleFunc("OPTIONS /api/storyWriterFlow", corsMiddleware(nil))
-	mux.HandleFunc("POST /api/storyWriterFlow", corsMiddleware(genkit.Handler(storyWriterFlow)))
+	mux.HandleFunc("POST /api/storyWriterFlow", corsMiddleware(transport.Handler(storyWriterFlow)))
 
 	mux.HandleFunc("OPTIONS /api/imageGeneratorFlow", corsMiddleware(nil))
-	mux.HandleFunc("POST /api/imageGeneratorFlow", corsMiddleware(genkit.Handler(imageGeneratorFlow)))
+	mux.HandleFunc("POST /api/imageGeneratorFlow", corsMiddleware(transport.Handler(imageGeneratorFlow)))
 
 	mux.HandleFunc("OPTIONS /api/routerFlow", corsMiddleware(nil))
-	mux.HandleFunc("POST /api/routerFlow", corsMiddleware(genkit.Handler(routerFlow)))
+	mux.HandleFunc("POST /api/routerFlow", corsMiddleware(transport.Handler(routerFlow)))
 
 	mux.HandleFunc("OPTIONS /api/marketingCopyFlow", corsMiddleware(nil))
-	mux.HandleFunc("POST /api/marketingCopyFlow", corsMiddleware(genkit.Handler(marketingCopyFlow)))
+	mux.HandleFunc("POST /api/marketingCopyFlow", corsMiddleware(transport.Handler(marketingCopyFlow)))
 
 	mux.HandleFunc("OPTIONS /api/toolCallingFlow", corsMiddleware(nil))
-	mux.HandleFunc("POST /api/toolCallingFlow", corsMiddleware(genkit.Handler(toolCallingFlow)))
+	mux.HandleFunc("POST /api/toolCallingFlow", corsMiddleware(transport.Handler(toolCallingFlow)))
 
 	mux.HandleFunc("OPTIONS /api/agenticRagFlow", corsMiddleware(nil))
-	mux.HandleFunc("POST /api/agenticRagFlow", corsMiddleware(genkit.Handler(agenticRagFlow)))
+	mux.HandleFunc("POST /api/agenticRagFlow", corsMiddleware(transport.Handler(agenticRagFlow)))
 
 	mux.HandleFunc("OPTIONS /api/indexMenu", corsMiddleware(nil))
-	mux.HandleFunc("POST /api/indexMenu", corsMiddleware(genkit.Handler(indexMenuFlow)))
+	mux.HandleFunc("POST /api/indexMenu", corsMiddleware(transport.Handler(indexMenuFlow)))
 
 	mux.HandleFunc("OPTIONS /api/iterativeRefinementFlow", corsMiddleware(nil))
-	mux.HandleFunc("POST /api/iterativeRefinementFlow", corsMiddleware(genkit.Handler(iterativeRefinementFlow)))
+	mux.HandleFunc("POST /api/iterativeRefinementFlow", corsMiddleware(transport.Handler(iterativeRefinementFlow)))
 
 	mux.HandleFunc("OPTIONS /api/researchAgent", corsMiddleware(nil))
-	mux.HandleFunc("POST /api/researchAgent", corsMiddleware(genkit.Handler(researchAgentFlow)))
+	mux.HandleFunc("POST /api/researchAgent", corsMiddleware(transport.Handler(researchAgentFlow)))
+
+	mux.HandleFunc("OPTIONS /api/planAndExecuteFlow", corsMiddleware(nil))
+	mux.HandleFunc("POST /api/planAndExecuteFlow", corsMiddleware(transport.Handler(planAndExecuteFlow)))
+
+	mux.HandleFunc("OPTIONS /api/supervisorFlow", corsMiddleware(nil))
+	mux.HandleFunc("POST /api/supervisorFlow", corsMiddleware(transport.Handler(supervisorFlow)))
 
 	mux.HandleFunc("OPTIONS /api/statefulChatFlow", corsMiddleware(nil))
-	mux.HandleFunc("POST /api/statefulChatFlow", corsMiddleware(genkit.Handler(statefulChatFlow)))
+	mux.HandleFunc("POST /api/statefulChatFlow", corsMiddleware(transport.Handler(statefulChatFlow)))
+
+	// Every run's ID is in its X-Run-Id response header, and stops the run
+	// when posted here, e.g. when the user clicks "stop".
+	mux.HandleFunc("OPTIONS /api/runs/{id}/cancel", corsMiddleware(nil))
+	mux.HandleFunc("POST /api/runs/{id}/cancel", corsMiddleware(transport.CancelHandler()))
+
+	// Long runs, such as the research agent's, can be started as jobs and
+	// polled, or report back to a callback URL.
+	mux.HandleFunc("OPTIONS /api/jobs/", corsMiddleware(nil))
+	mux.HandleFunc("POST /api/jobs/{flow}", corsMiddleware(jobs))
+	mux.HandleFunc("GET /api/jobs/{id}", corsMiddleware(jobs))
 
 	log.Println("Starting server on http://localhost:3001")
 	log.Fatal(server.Start(ctx, "127.0.0.1:3001", mux))
@@ -81,6 +127,7 @@ func corsMiddleware(next http.Handler) http.HandlerFunc {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
 		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		w.Header().Set("Access-Control-Expose-Headers", transport.RunIDHeader+", Location")
 		if r.Method == "OPTIONS" {
 			w.WriteHeader(http.StatusOK)
 			return
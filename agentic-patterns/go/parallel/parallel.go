@@ -0,0 +1,245 @@
+// Package parallel runs named sub-generations concurrently and collects their
+// typed results.
+package parallel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Policy controls how a Group reacts to a failed task.
+type Policy int
+
+const (
+	// FailFast cancels every other task as soon as one task fails.
+	FailFast Policy = iota
+	// BestEffort lets the remaining tasks finish and returns partial results.
+	// Only failures of tasks marked Required are fatal.
+	BestEffort
+)
+
+// Options configures a Group.
+type Options struct {
+	Policy Policy
+	// MaxConcurrency limits how many tasks run at once. Zero means no limit.
+	MaxConcurrency int
+	// TaskTimeout bounds each individual task. Zero means no timeout.
+	TaskTimeout time.Duration
+}
+
+// TaskError records which task failed and why.
+type TaskError struct {
+	Task string
+	Err  error
+}
+
+func (e *TaskError) Error() string {
+	return fmt.Sprintf("task %q: %v", e.Task, e.Err)
+}
+
+func (e *TaskError) Unwrap() error {
+	return e.Err
+}
+
+// Group fans tasks out over goroutines and fans their results back in.
+// A Group must not be reused after Wait returns.
+type Group struct {
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+	opts   Options
+	sem    chan struct{}
+	wg     sync.WaitGroup
+
+	mu       sync.Mutex
+	tasks    int
+	failed   map[string]error
+	fatalErr error
+}
+
+// NewGroup returns a Group whose tasks run under a context derived from ctx.
+// The derived context is cancelled on the first fatal error and when Wait returns.
+func NewGroup(ctx context.Context, opts Options) *Group {
+	ctx, cancel := context.WithCancelCause(ctx)
+	g := &Group{
+		ctx:    ctx,
+		cancel: cancel,
+		opts:   opts,
+		failed: make(map[string]error),
+	}
+	if opts.MaxConcurrency > 0 {
+		g.sem = make(chan struct{}, opts.MaxConcurrency)
+	}
+	return g
+}
+
+// Future holds the eventual result of a task started with Go.
+type Future[T any] struct {
+	name  string
+	value T
+	err   error
+	done  chan struct{}
+}
+
+// Name returns the task name.
+func (f *Future[T]) Name() string {
+	return f.name
+}
+
+// Get blocks until the task has finished and returns its result.
+func (f *Future[T]) Get() (T, error) {
+	<-f.done
+	return f.value, f.err
+}
+
+// Value returns the task result, or the zero value if the task failed.
+func (f *Future[T]) Value() T {
+	v, _ := f.Get()
+	return v
+}
+
+// TaskOption configures a single task.
+type TaskOption func(*taskOptions)
+
+type taskOptions struct {
+	required bool
+}
+
+// Required marks a task whose failure is fatal even under BestEffort.
+func Required() TaskOption {
+	return func(o *taskOptions) {
+		o.required = true
+	}
+}
+
+// Go starts fn as a named task in g and returns a Future for its result.
+func Go[T any](g *Group, name string, fn func(ctx context.Context) (T, error), opts ...TaskOption) *Future[T] {
+	var to taskOptions
+	for _, opt := range opts {
+		opt(&to)
+	}
+
+	f := &Future[T]{name: name, done: make(chan struct{})}
+
+	g.mu.Lock()
+	g.tasks++
+	g.mu.Unlock()
+
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		defer close(f.done)
+
+		f.err = g.run(func(ctx context.Context) (err error) {
+			f.value, err = fn(ctx)
+			return err
+		})
+		if f.err != nil {
+			var zero T
+			f.value = zero
+			g.fail(name, f.err, to.required)
+		}
+	}()
+	return f
+}
+
+// run acquires a concurrency slot and runs fn with the per-task timeout applied.
+func (g *Group) run(fn func(ctx context.Context) error) (err error) {
+	if g.sem != nil {
+		select {
+		case g.sem <- struct{}{}:
+			defer func() { <-g.sem }()
+		case <-g.ctx.Done():
+			return context.Cause(g.ctx)
+		}
+	}
+	if err := context.Cause(g.ctx); err != nil {
+		return err
+	}
+
+	ctx := g.ctx
+	if g.opts.TaskTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, g.opts.TaskTimeout,
+			fmt.Errorf("timed out after %s", g.opts.TaskTimeout))
+		defer cancel()
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	if err := fn(ctx); err != nil {
+		if cause := context.Cause(ctx); cause != nil && ctx.Err() != nil && errors.Is(err, ctx.Err()) {
+			return cause
+		}
+		return err
+	}
+	return nil
+}
+
+// fail records a task failure and cancels the group if the failure is fatal.
+func (g *Group) fail(name string, err error, required bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.failed[name] = err
+	// Tasks cancelled because another task already failed keep the original
+	// cause, and tasks cancelled along with the caller's context are not at
+	// fault: Wait returns the context's cause instead.
+	if g.fatalErr != nil || context.Cause(g.ctx) != nil || (g.opts.Policy != FailFast && !required) {
+		return
+	}
+	g.fatalErr = &TaskError{Task: name, Err: err}
+	g.cancel(g.fatalErr)
+}
+
+// Wait blocks until every task has finished.
+//
+// Under FailFast it returns the first task error. Under BestEffort it returns
+// the first Required task error, or an error joining every failure, by task
+// name, if no task succeeded; otherwise it returns nil and the failures are available from Errors.
+// If the context the group was created with is cancelled first, Wait returns
+// the cause of its cancellation.
+func (g *Group) Wait() error {
+	g.wg.Wait()
+	defer g.cancel(nil)
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.fatalErr != nil {
+		return g.fatalErr
+	}
+	if err := context.Cause(g.ctx); err != nil {
+		return err
+	}
+	if g.tasks > 0 && len(g.failed) == g.tasks {
+		// The failures are joined in the order of the task names, so that
+		// the error reads the same on every run.
+		var errs []error
+		for _, name := range slices.Sorted(maps.Keys(g.failed)) {
+			errs = append(errs, &TaskError{Task: name, Err: g.failed[name]})
+		}
+		return fmt.Errorf("all %d tasks failed: %w", g.tasks, errors.Join(errs...))
+	}
+	return nil
+}
+
+// Errors returns the error of every failed task, keyed by task name.
+// It should be called after Wait.
+func (g *Group) Errors() map[string]error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	errs := make(map[string]error, len(g.failed))
+	for name, err := range g.failed {
+		errs[name] = err
+	}
+	return errs
+}
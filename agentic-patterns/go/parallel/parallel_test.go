@@ -0,0 +1,149 @@
+package parallel
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var errBoom = errors.New("boom")
+
+// waitForCancel is a task that runs until its context is cancelled.
+func waitForCancel(ctx context.Context) (string, error) {
+	select {
+	case <-ctx.Done():
+		return "", ctx.Err()
+	case <-time.After(5 * time.Second):
+		return "not cancelled", nil
+	}
+}
+
+func TestFailFastCancelsOnFirstError(t *testing.T) {
+	g := NewGroup(context.Background(), Options{Policy: FailFast})
+	Go(g, "fails", func(ctx context.Context) (string, error) { return "", errBoom })
+	slow := Go(g, "slow", waitForCancel)
+
+	err := g.Wait()
+	var taskErr *TaskError
+	if !errors.As(err, &taskErr) || taskErr.Task != "fails" || !errors.Is(err, errBoom) {
+		t.Fatalf("Wait() = %v, want the error of task \"fails\"", err)
+	}
+	if _, err := slow.Get(); !errors.Is(err, errBoom) {
+		t.Errorf("slow task error = %v, want it cancelled with the first error as cause", err)
+	}
+}
+
+func TestBestEffortRequiredFailure(t *testing.T) {
+	g := NewGroup(context.Background(), Options{Policy: BestEffort})
+	optional := Go(g, "optional", func(ctx context.Context) (string, error) { return "", errors.New("optional failed") })
+	// Wait for the optional failure, which must not cancel the group.
+	optional.Get()
+	Go(g, "required", func(ctx context.Context) (string, error) { return "", errBoom }, Required())
+	slow := Go(g, "slow", waitForCancel)
+
+	err := g.Wait()
+	var taskErr *TaskError
+	if !errors.As(err, &taskErr) || taskErr.Task != "required" {
+		t.Fatalf("Wait() = %v, want the error of task \"required\"", err)
+	}
+	if _, err := slow.Get(); !errors.Is(err, errBoom) {
+		t.Errorf("slow task error = %v, want it cancelled by the required failure", err)
+	}
+}
+
+func TestBestEffortPartialResults(t *testing.T) {
+	g := NewGroup(context.Background(), Options{Policy: BestEffort})
+	ok := Go(g, "ok", func(ctx context.Context) (string, error) { return "done", nil })
+	Go(g, "optional", func(ctx context.Context) (string, error) { return "", errBoom })
+
+	if err := g.Wait(); err != nil {
+		t.Fatalf("Wait() = %v, want nil", err)
+	}
+	if got := ok.Value(); got != "done" {
+		t.Errorf("ok.Value() = %q, want %q", got, "done")
+	}
+	if errs := g.Errors(); len(errs) != 1 || !errors.Is(errs["optional"], errBoom) {
+		t.Errorf("Errors() = %v, want only the error of task \"optional\"", errs)
+	}
+}
+
+func TestAllTasksFail(t *testing.T) {
+	// The error must read the same however the tasks are scheduled.
+	want := "all 3 tasks failed: task \"a\": a failed\ntask \"b\": b failed\ntask \"c\": c failed"
+	for range 20 {
+		g := NewGroup(context.Background(), Options{Policy: BestEffort})
+		for _, name := range []string{"c", "a", "b"} {
+			Go(g, name, func(ctx context.Context) (int, error) { return 0, errors.New(name + " failed") })
+		}
+		if err := g.Wait(); err == nil || err.Error() != want {
+			t.Fatalf("Wait() = %v, want %q", err, want)
+		}
+	}
+}
+
+func TestPanicIsRecovered(t *testing.T) {
+	g := NewGroup(context.Background(), Options{Policy: BestEffort})
+	f := Go(g, "panics", func(ctx context.Context) (int, error) { panic("kaboom") })
+	Go(g, "ok", func(ctx context.Context) (int, error) { return 1, nil })
+
+	if err := g.Wait(); err != nil {
+		t.Fatalf("Wait() = %v, want nil", err)
+	}
+	if _, err := f.Get(); err == nil || !strings.Contains(err.Error(), "panic: kaboom") {
+		t.Errorf("panicking task error = %v, want the recovered panic", err)
+	}
+}
+
+func TestTaskTimeout(t *testing.T) {
+	g := NewGroup(context.Background(), Options{Policy: BestEffort, TaskTimeout: 10 * time.Millisecond})
+	slow := Go(g, "slow", waitForCancel)
+	Go(g, "fast", func(ctx context.Context) (string, error) { return "done", nil })
+
+	if err := g.Wait(); err != nil {
+		t.Fatalf("Wait() = %v, want nil", err)
+	}
+	if _, err := slow.Get(); err == nil || err.Error() != "timed out after 10ms" {
+		t.Errorf("slow task error = %v, want %q", err, "timed out after 10ms")
+	}
+}
+
+func TestParentCancellation(t *testing.T) {
+	cause := errors.New("caller gave up")
+	ctx, cancel := context.WithCancelCause(context.Background())
+	g := NewGroup(ctx, Options{Policy: FailFast})
+	Go(g, "a", waitForCancel)
+	Go(g, "b", waitForCancel)
+	cancel(cause)
+
+	if err := g.Wait(); err != cause {
+		t.Fatalf("Wait() = %v, want the cause of the parent's cancellation", err)
+	}
+}
+
+func TestMaxConcurrency(t *testing.T) {
+	g := NewGroup(context.Background(), Options{MaxConcurrency: 2})
+	var running, peak atomic.Int32
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		Go(g, name, func(ctx context.Context) (int, error) {
+			n := running.Add(1)
+			defer running.Add(-1)
+			for {
+				p := peak.Load()
+				if n <= p || peak.CompareAndSwap(p, n) {
+					break
+				}
+			}
+			time.Sleep(5 * time.Millisecond)
+			return 0, nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		t.Fatalf("Wait() = %v, want nil", err)
+	}
+	if p := peak.Load(); p > 2 {
+		t.Errorf("%d tasks ran at once, want at most 2", p)
+	}
+}
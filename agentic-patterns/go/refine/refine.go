@@ -0,0 +1,148 @@
+// Package refine implements a critique-and-revise loop that keeps the
+// best-scoring draft and records every step it took.
+package refine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// StopReason explains why the loop ended.
+type StopReason string
+
+const (
+	StopTargetScore   StopReason = "target_score"
+	StopMaxIterations StopReason = "max_iterations"
+	StopNoImprovement StopReason = "no_improvement"
+)
+
+// CriterionScore is the rubric score for a single criterion.
+type CriterionScore struct {
+	Criterion string  `json:"criterion"`
+	Score     float64 `json:"score" jsonschema_description:"Score from 1 (poor) to 10 (excellent)"`
+	Feedback  string  `json:"feedback" jsonschema_description:"Specific, actionable feedback for this criterion"`
+}
+
+// Evaluation is an evaluator's rubric assessment of a draft.
+type Evaluation struct {
+	Scores   []CriterionScore `json:"scores"`
+	Critique string           `json:"critique" jsonschema_description:"Overall feedback on how to improve the draft"`
+}
+
+// Overall returns the mean of the criterion scores.
+func (e *Evaluation) Overall() float64 {
+	if e == nil || len(e.Scores) == 0 {
+		return 0
+	}
+	var sum float64
+	for _, s := range e.Scores {
+		sum += s.Score
+	}
+	return sum / float64(len(e.Scores))
+}
+
+// Iteration is one draft and the evaluation it received.
+type Iteration struct {
+	Iteration  int         `json:"iteration"`
+	Draft      string      `json:"draft"`
+	Evaluation *Evaluation `json:"evaluation"`
+	Score      float64     `json:"score"`
+}
+
+// Result is the outcome of a refinement run.
+type Result struct {
+	Best          string      `json:"best"`
+	BestScore     float64     `json:"bestScore"`
+	BestIteration int         `json:"bestIteration"`
+	StopReason    StopReason  `json:"stopReason"`
+	Iterations    []Iteration `json:"iterations"`
+}
+
+// Options controls when the loop stops.
+type Options struct {
+	// MaxIterations is the maximum number of drafts to evaluate. Defaults to 3.
+	MaxIterations int
+	// TargetScore stops the loop once a draft scores at least this much. Zero disables it.
+	TargetScore float64
+	// Patience is the number of consecutive drafts allowed without an
+	// improvement before the loop stops. Zero disables it.
+	Patience int
+	// MinImprovement is the margin by which a draft must beat the best score
+	// to count as an improvement.
+	MinImprovement float64
+}
+
+// Engine runs the loop using caller-supplied generation and evaluation steps.
+type Engine struct {
+	// Generate produces the first draft.
+	Generate func(ctx context.Context) (string, error)
+	// Revise produces a new draft from a previous draft and its evaluation.
+	Revise func(ctx context.Context, draft string, eval *Evaluation) (string, error)
+	// Evaluate scores a draft.
+	Evaluate func(ctx context.Context, draft string) (*Evaluation, error)
+	// OnIteration, if set, is called after each draft has been evaluated.
+	OnIteration func(ctx context.Context, it Iteration) error
+
+	Options Options
+}
+
+// Run generates, evaluates and revises drafts until a stop condition is met.
+// Revisions are always based on the best draft so far, so a regression is
+// discarded rather than built upon.
+func (e *Engine) Run(ctx context.Context) (*Result, error) {
+	if e.Generate == nil || e.Revise == nil || e.Evaluate == nil {
+		return nil, errors.New("refine: Generate, Revise and Evaluate are required")
+	}
+	maxIterations := e.Options.MaxIterations
+	if maxIterations <= 0 {
+		maxIterations = 3
+	}
+
+	res := &Result{BestIteration: -1, StopReason: StopMaxIterations}
+	var bestEval *Evaluation
+	stale := 0
+
+	for i := 0; i < maxIterations; i++ {
+		var draft string
+		var err error
+		if i == 0 {
+			draft, err = e.Generate(ctx)
+		} else {
+			draft, err = e.Revise(ctx, res.Best, bestEval)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("refine: iteration %d: %w", i, err)
+		}
+
+		eval, err := e.Evaluate(ctx, draft)
+		if err != nil {
+			return nil, fmt.Errorf("refine: evaluating iteration %d: %w", i, err)
+		}
+		it := Iteration{Iteration: i, Draft: draft, Evaluation: eval, Score: eval.Overall()}
+		res.Iterations = append(res.Iterations, it)
+		if e.OnIteration != nil {
+			if err := e.OnIteration(ctx, it); err != nil {
+				return nil, err
+			}
+		}
+
+		if res.BestIteration < 0 || it.Score > res.BestScore+e.Options.MinImprovement {
+			res.Best, res.BestScore, res.BestIteration = draft, it.Score, i
+			bestEval = eval
+			stale = 0
+		} else {
+			stale++
+		}
+
+		if e.Options.TargetScore > 0 && res.BestScore >= e.Options.TargetScore {
+			res.StopReason = StopTargetScore
+			break
+		}
+		if e.Options.Patience > 0 && stale >= e.Options.Patience {
+			res.StopReason = StopNoImprovement
+			break
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,177 @@
+package refine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// fakeEngine returns an Engine whose drafts are "draft 0", "draft 1", ...
+// and score scores[i], and the drafts that each revision started from.
+func fakeEngine(scores []float64, opts Options) (*Engine, *[]string) {
+	var revised []string
+	n := 0
+	next := func() string {
+		d := fmt.Sprintf("draft %d", n)
+		n++
+		return d
+	}
+	e := &Engine{
+		Generate: func(ctx context.Context) (string, error) {
+			return next(), nil
+		},
+		Revise: func(ctx context.Context, draft string, eval *Evaluation) (string, error) {
+			revised = append(revised, draft)
+			return next(), nil
+		},
+		Evaluate: func(ctx context.Context, draft string) (*Evaluation, error) {
+			var i int
+			fmt.Sscanf(draft, "draft %d", &i)
+			return &Evaluation{Scores: []CriterionScore{{Criterion: "clarity", Score: scores[i]}}}, nil
+		},
+		Options: opts,
+	}
+	return e, &revised
+}
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		name    string
+		scores  []float64
+		opts    Options
+		best    string
+		reason  StopReason
+		drafts  int
+		revised []string
+	}{
+		{
+			name:    "default max iterations",
+			scores:  []float64{5, 6, 7, 8},
+			best:    "draft 2",
+			reason:  StopMaxIterations,
+			drafts:  3,
+			revised: []string{"draft 0", "draft 1"},
+		},
+		{
+			name:    "max iterations",
+			scores:  []float64{5, 6, 7, 8, 9},
+			opts:    Options{MaxIterations: 5},
+			best:    "draft 4",
+			reason:  StopMaxIterations,
+			drafts:  5,
+			revised: []string{"draft 0", "draft 1", "draft 2", "draft 3"},
+		},
+		{
+			name:    "target score",
+			scores:  []float64{5, 8.5, 9},
+			opts:    Options{MaxIterations: 3, TargetScore: 8},
+			best:    "draft 1",
+			reason:  StopTargetScore,
+			drafts:  2,
+			revised: []string{"draft 0"},
+		},
+		{
+			name:    "first draft meets the target",
+			scores:  []float64{9},
+			opts:    Options{TargetScore: 8},
+			best:    "draft 0",
+			reason:  StopTargetScore,
+			drafts:  1,
+			revised: nil,
+		},
+		{
+			name:    "patience",
+			scores:  []float64{7, 6, 5, 9},
+			opts:    Options{MaxIterations: 4, Patience: 2},
+			best:    "draft 0",
+			reason:  StopNoImprovement,
+			drafts:  3,
+			revised: []string{"draft 0", "draft 0"},
+		},
+		{
+			name:    "regressions are discarded",
+			scores:  []float64{6, 4, 7, 5},
+			opts:    Options{MaxIterations: 4},
+			best:    "draft 2",
+			reason:  StopMaxIterations,
+			drafts:  4,
+			revised: []string{"draft 0", "draft 0", "draft 2"},
+		},
+		{
+			name:    "min improvement",
+			scores:  []float64{6, 6.2, 6.8, 7},
+			opts:    Options{MaxIterations: 4, MinImprovement: 0.5},
+			best:    "draft 2",
+			reason:  StopMaxIterations,
+			drafts:  4,
+			revised: []string{"draft 0", "draft 0", "draft 2"},
+		},
+		{
+			name:    "small gains do not reset patience",
+			scores:  []float64{6, 6.2, 6.3, 9},
+			opts:    Options{MaxIterations: 4, Patience: 2, MinImprovement: 0.5},
+			best:    "draft 0",
+			reason:  StopNoImprovement,
+			drafts:  3,
+			revised: []string{"draft 0", "draft 0"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, revised := fakeEngine(tt.scores, tt.opts)
+			res, err := e.Run(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Best != tt.best || res.StopReason != tt.reason || len(res.Iterations) != tt.drafts {
+				t.Errorf("Run() = best %q, stop reason %s, %d drafts; want %q, %s, %d",
+					res.Best, res.StopReason, len(res.Iterations), tt.best, tt.reason, tt.drafts)
+			}
+			if want := res.Iterations[res.BestIteration]; want.Draft != res.Best || want.Score != res.BestScore {
+				t.Errorf("best iteration %d is %+v, want draft %q with score %v", res.BestIteration, want, res.Best, res.BestScore)
+			}
+			if !reflect.DeepEqual(*revised, tt.revised) {
+				t.Errorf("revised %q, want %q", *revised, tt.revised)
+			}
+		})
+	}
+}
+
+func TestRunErrors(t *testing.T) {
+	if _, err := (&Engine{}).Run(context.Background()); err == nil {
+		t.Error("Run() without steps succeeded")
+	}
+
+	errModel := errors.New("model unavailable")
+	e, _ := fakeEngine([]float64{5, 6, 7}, Options{})
+	e.Revise = func(ctx context.Context, draft string, eval *Evaluation) (string, error) {
+		return "", errModel
+	}
+	if _, err := e.Run(context.Background()); !errors.Is(err, errModel) {
+		t.Errorf("Run() = %v, want the revision error", err)
+	}
+
+	errStop := errors.New("client gone")
+	e, _ = fakeEngine([]float64{5, 6, 7}, Options{})
+	calls := 0
+	e.OnIteration = func(ctx context.Context, it Iteration) error {
+		calls++
+		return errStop
+	}
+	if _, err := e.Run(context.Background()); !errors.Is(err, errStop) || calls != 1 {
+		t.Errorf("Run() = %v after %d iterations, want the OnIteration error after 1", err, calls)
+	}
+}
+
+func TestOverall(t *testing.T) {
+	var nilEval *Evaluation
+	if got := nilEval.Overall(); got != 0 {
+		t.Errorf("nil Overall() = %v, want 0", got)
+	}
+	e := &Evaluation{Scores: []CriterionScore{{Score: 6}, {Score: 9}}}
+	if got := e.Overall(); got != 7.5 {
+		t.Errorf("Overall() = %v, want 7.5", got)
+	}
+}
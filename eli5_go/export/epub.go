@@ -0,0 +1,226 @@
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"html/template"
+	"io"
+
+	"eli5/library"
+)
+
+// writeEPUB renders the document as an EPUB 3 package with a title page and
+// one XHTML document per storybook page.
+func writeEPUB(w io.Writer, doc *document) error {
+	zw := zip.NewWriter(w)
+
+	// The mimetype file must come first and be stored uncompressed.
+	mw, err := zw.CreateHeader(&zip.FileHeader{Name: "mimetype", Method: zip.Store})
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(mw, "application/epub+zip"); err != nil {
+		return err
+	}
+
+	files := []epubFile{
+		{"META-INF/container.xml", epubContainer, nil},
+		{"OEBPS/content.opf", epubPackage, doc},
+		{"OEBPS/nav.xhtml", epubNav, doc},
+		{"OEBPS/title.xhtml", epubTitlePage, doc},
+	}
+	for i := range doc.Pages {
+		files = append(files, epubFile{"OEBPS/" + epubPageFile(i), epubPage, epubPageData{Doc: doc, Index: i, Page: &doc.Pages[i]}})
+	}
+	if len(doc.Sources) > 0 {
+		files = append(files, epubFile{"OEBPS/sources.xhtml", epubSources, doc})
+	}
+	for _, f := range files {
+		// The XML declaration is written here because html/template would escape it.
+		buf := bytes.NewBufferString(xml.Header)
+		if err := f.tmpl.Execute(buf, f.data); err != nil {
+			return err
+		}
+		if err := writeZipFile(zw, f.name, buf.Bytes()); err != nil {
+			return err
+		}
+	}
+	if err := writeZipFile(zw, "OEBPS/style.css", []byte(bookCSS)); err != nil {
+		return err
+	}
+	for _, p := range doc.Pictures() {
+		if err := writeZipFile(zw, "OEBPS/images/"+p.Name, p.Data); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+func writeZipFile(zw *zip.Writer, name string, data []byte) error {
+	fw, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = fw.Write(data)
+	return err
+}
+
+// Pictures returns every image of the document.
+func (d *document) Pictures() []*picture {
+	var out []*picture
+	if d.Avatar != nil {
+		out = append(out, d.Avatar)
+	}
+	for _, p := range d.Pages {
+		if p.Image != nil {
+			out = append(out, p.Image)
+		}
+	}
+	return out
+}
+
+type epubFile struct {
+	name string
+	tmpl *template.Template
+	data any
+}
+
+func epubPageFile(i int) string {
+	return library.PageImageName(i) + ".xhtml"
+}
+
+type epubPageData struct {
+	Doc   *document
+	Index int
+	Page  *page
+}
+
+var epubFuncs = template.FuncMap{
+	"pageFile": epubPageFile,
+	"pageID":   library.PageImageName,
+	"inc":      func(i int) int { return i + 1 },
+}
+
+var epubContainer = template.Must(template.New("container").Parse(`<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+  <rootfiles>
+    <rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/>
+  </rootfiles>
+</container>
+`))
+
+var epubPackage = template.Must(template.New("package").Funcs(epubFuncs).Parse(`<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="book-id" xml:lang="{{.Language}}">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+    <dc:identifier id="book-id">urn:eli5:{{.ID}}</dc:identifier>
+    <dc:title>{{.Title}}</dc:title>
+    <dc:language>{{.Language}}</dc:language>
+    <meta property="dcterms:modified">{{.Modified}}</meta>
+  </metadata>
+  <manifest>
+    <item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>
+    <item id="style" href="style.css" media-type="text/css"/>
+    <item id="title" href="title.xhtml" media-type="application/xhtml+xml"/>
+    {{- range $i, $p := .Pages}}
+    <item id="{{pageID $i}}" href="{{pageFile $i}}" media-type="application/xhtml+xml"/>
+    {{- end}}
+    {{- if .Sources}}
+    <item id="sources" href="sources.xhtml" media-type="application/xhtml+xml"/>
+    {{- end}}
+    {{- range .Pictures}}
+    <item id="img-{{.Name}}" href="images/{{.Name}}" media-type="{{.ContentType}}"/>
+    {{- end}}
+  </manifest>
+  <spine>
+    <itemref idref="title"/>
+    {{- range $i, $p := .Pages}}
+    <itemref idref="{{pageID $i}}"/>
+    {{- end}}
+    {{- if .Sources}}
+    <itemref idref="sources"/>
+    {{- end}}
+  </spine>
+</package>
+`))
+
+var epubNav = template.Must(template.New("nav").Funcs(epubFuncs).Parse(`<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops" xml:lang="{{.Language}}" lang="{{.Language}}">
+<head>
+  <title>{{.Title}}</title>
+</head>
+<body>
+  <nav epub:type="toc" id="toc">
+    <h1>{{.Title}}</h1>
+    <ol>
+      <li><a href="title.xhtml">{{.Title}}</a></li>
+      {{- range $i, $p := .Pages}}
+      <li><a href="{{pageFile $i}}">Page {{inc $i}}</a></li>
+      {{- end}}
+      {{- if .Sources}}
+      <li><a href="sources.xhtml">Sources</a></li>
+      {{- end}}
+    </ol>
+  </nav>
+</body>
+</html>
+`))
+
+var epubTitlePage = template.Must(template.New("title").Parse(`<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xml:lang="{{.Language}}" lang="{{.Language}}">
+<head>
+  <title>{{.Title}}</title>
+  <link rel="stylesheet" type="text/css" href="style.css"/>
+</head>
+<body>
+  <section class="title-page">
+    <h1>{{.Title}}</h1>
+    {{- if ne .Question .Title}}
+    <p class="question">{{.Question}}</p>
+    {{- end}}
+    {{- with .Avatar}}
+    <img class="avatar" src="images/{{.Name}}" alt="The reader's avatar"/>
+    {{- end}}
+  </section>
+</body>
+</html>
+`))
+
+var epubPage = template.Must(template.New("page").Funcs(epubFuncs).Parse(`<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xml:lang="{{.Doc.Language}}" lang="{{.Doc.Language}}">
+<head>
+  <title>{{.Doc.Title}} - Page {{inc .Index}}</title>
+  <link rel="stylesheet" type="text/css" href="style.css"/>
+</head>
+<body>
+  <section class="page" id="{{pageID .Index}}">
+    {{- with .Page.Image}}
+    <img src="images/{{.Name}}" alt=""/>
+    {{- end}}
+    {{- range .Page.Paragraphs}}
+    <p>{{.}}</p>
+    {{- end}}
+    {{- with .Page.Sources}}
+    <p class="page-sources">Sources:{{range .}} <a href="sources.xhtml#source-{{.}}">[{{.}}]</a>{{end}}</p>
+    {{- end}}
+  </section>
+</body>
+</html>
+`))
+
+var epubSources = template.Must(template.New("sources").Funcs(epubFuncs).Parse(`<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xml:lang="{{.Language}}" lang="{{.Language}}">
+<head>
+  <title>{{.Title}} - Sources</title>
+  <link rel="stylesheet" type="text/css" href="style.css"/>
+</head>
+<body>
+  <section class="sources">
+    <h2>Sources</h2>
+    <ol>
+      {{- range $i, $s := .Sources}}
+      <li id="source-{{inc $i}}"><a href="{{$s.URL}}">{{$s.Title}}</a>{{if and $s.Domain (ne $s.Domain $s.Title)}} ({{$s.Domain}}){{end}}</li>
+      {{- end}}
+    </ol>
+  </section>
+</body>
+</html>
+`))
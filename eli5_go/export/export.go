@@ -0,0 +1,175 @@
+// Package export renders library books for printing and e-readers: as a
+// paginated PDF, an EPUB 3 package or a self-contained HTML page.
+package export
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+
+	"eli5/grounding"
+	"eli5/library"
+)
+
+// Format is an export file format.
+type Format string
+
+const (
+	PDF  Format = "pdf"
+	EPUB Format = "epub"
+	HTML Format = "html"
+)
+
+// ParseFormat returns the format with the given name.
+func ParseFormat(name string) (Format, error) {
+	switch f := Format(strings.ToLower(name)); f {
+	case PDF, EPUB, HTML:
+		return f, nil
+	}
+	return "", fmt.Errorf("unsupported export format %q; use pdf, epub or html", name)
+}
+
+// ContentType returns the MIME type of the format.
+func (f Format) ContentType() string {
+	switch f {
+	case PDF:
+		return "application/pdf"
+	case EPUB:
+		return "application/epub+zip"
+	default:
+		return "text/html; charset=utf-8"
+	}
+}
+
+// ImageLoader returns the content type and data of a stored image, given its
+// URL. library.Store.ReadImage is an ImageLoader.
+type ImageLoader func(url string) (string, []byte, error)
+
+// Write renders book in format f to w. Pages whose illustration cannot be
+// loaded are exported with their text only.
+func Write(w io.Writer, f Format, book *library.Book, load ImageLoader) error {
+	doc := newDocument(book, load)
+	switch f {
+	case PDF:
+		return writePDF(w, doc)
+	case EPUB:
+		return writeEPUB(w, doc)
+	case HTML:
+		return writeHTML(w, doc)
+	}
+	return fmt.Errorf("unsupported export format %q", f)
+}
+
+// FileName returns a download file name for book in format f: its title in
+// lower case, in any script, with dashes between words.
+func FileName(book *library.Book, f Format) string {
+	var b strings.Builder
+	dash, n := false, 0
+	for _, r := range strings.ToLower(book.Title) {
+		// Marks belong to the letter before them, e.g. the vowel signs of
+		// Devanagari.
+		if unicode.IsLetter(r) || unicode.IsNumber(r) || unicode.IsMark(r) && b.Len() > 0 && !dash {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			dash = false
+			n++
+		} else {
+			dash = true
+		}
+		if n >= 60 {
+			break
+		}
+	}
+	if b.Len() == 0 {
+		b.WriteString("storybook")
+	}
+	return b.String() + "." + string(f)
+}
+
+// document is a book with its images loaded.
+type document struct {
+	ID       string
+	Title    string
+	Question string
+	Language string
+	Modified string
+	Avatar   *picture
+	Pages    []page
+	Sources  []grounding.Source
+}
+
+type page struct {
+	Paragraphs []string
+	Image      *picture
+	// Sources are the numbers of the document's sources, starting at 1.
+	Sources []int
+}
+
+type picture struct {
+	// Name is the file name of the image, e.g. "page-01.png".
+	Name        string
+	ContentType string
+	Data        []byte
+}
+
+var imageExtensions = map[string]string{
+	"image/png":  ".png",
+	"image/jpeg": ".jpg",
+	"image/webp": ".webp",
+	"image/gif":  ".gif",
+}
+
+func newDocument(book *library.Book, load ImageLoader) *document {
+	doc := &document{
+		ID:       book.ID,
+		Title:    book.Title,
+		Question: book.Question,
+		Language: book.Language,
+		Modified: book.CreatedAt.UTC().Format("2006-01-02T15:04:05Z"),
+		Avatar:   loadPicture(load, book.Avatar, "avatar"),
+		Sources:  book.Sources,
+	}
+	if doc.Title == "" {
+		doc.Title = book.Question
+	}
+	if doc.Language == "" {
+		doc.Language = "en"
+	}
+	for i, p := range book.Pages {
+		doc.Pages = append(doc.Pages, page{
+			Paragraphs: paragraphs(p.Text),
+			Image:      loadPicture(load, p.Image, library.PageImageName(i)),
+			Sources:    p.Sources,
+		})
+	}
+	return doc
+}
+
+func loadPicture(load ImageLoader, url, name string) *picture {
+	if url == "" || load == nil {
+		return nil
+	}
+	contentType, data, err := load(url)
+	if err != nil {
+		return nil
+	}
+	ext, ok := imageExtensions[contentType]
+	if !ok {
+		return nil
+	}
+	return &picture{Name: name + ext, ContentType: contentType, Data: data}
+}
+
+// paragraphs splits text on blank lines and joins the lines of each paragraph.
+func paragraphs(text string) []string {
+	var out []string
+	for _, p := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n\n") {
+		if p = strings.Join(strings.Fields(p), " "); p != "" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
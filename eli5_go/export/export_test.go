@@ -0,0 +1,53 @@
+package export
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"eli5/library"
+)
+
+func TestFileName(t *testing.T) {
+	tests := []struct {
+		title, want string
+	}{
+		{"Why Is the Sky Blue?", "why-is-the-sky-blue.pdf"},
+		{"¿Por qué el cielo es azul?", "por-qué-el-cielo-es-azul.pdf"},
+		{"なぜ空は青いの？", "なぜ空は青いの.pdf"},
+		{"Почему небо голубое?", "почему-небо-голубое.pdf"},
+		{"आसमान नीला क्यों है", "आसमान-नीला-क्यों-है.pdf"},
+		{"?!", "storybook.pdf"},
+	}
+	for _, tt := range tests {
+		if got := FileName(&library.Book{Title: tt.title}, PDF); got != tt.want {
+			t.Errorf("FileName(%q) = %q, want %q", tt.title, got, tt.want)
+		}
+	}
+}
+
+func TestWritePDFUnsupportedText(t *testing.T) {
+	tests := []struct {
+		title, text string
+		want        rune
+	}{
+		{"Le ciel", "Le ciel est bleu à cause de la lumière – et de l’air.", 0},
+		{"空", "空は青い。", '空'},
+		{"The sky", "Небо голубое.", 'Н'},
+	}
+	for _, tt := range tests {
+		book := &library.Book{Title: tt.title, Pages: []library.Page{{Text: tt.text}}}
+		err := Write(&bytes.Buffer{}, PDF, book, nil)
+		var unsupported *UnsupportedTextError
+		switch {
+		case tt.want == 0 && err != nil:
+			t.Errorf("%q: %v", tt.text, err)
+		case tt.want != 0 && (!errors.As(err, &unsupported) || unsupported.Char != tt.want):
+			t.Errorf("%q: err = %v, want an UnsupportedTextError for %q", tt.text, err, tt.want)
+		}
+		// The other formats show any text.
+		if err := Write(&bytes.Buffer{}, EPUB, book, nil); err != nil {
+			t.Errorf("%q as EPUB: %v", tt.text, err)
+		}
+	}
+}
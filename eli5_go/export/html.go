@@ -0,0 +1,85 @@
+package export
+
+import (
+	"encoding/base64"
+	"html/template"
+	"io"
+)
+
+// writeHTML renders the document as a single HTML file with the stylesheet
+// and images inlined, so it can be opened or printed without the server.
+func writeHTML(w io.Writer, doc *document) error {
+	return htmlBook.Execute(w, doc)
+}
+
+// bookCSS styles the HTML and EPUB renderings.
+const bookCSS = `body {
+  font-family: "Comic Neue", "Comic Sans MS", "Trebuchet MS", sans-serif;
+  font-size: 1.25em;
+  line-height: 1.5;
+  margin: 0 auto;
+  max-width: 40em;
+  padding: 1em;
+}
+h1 { text-align: center; }
+img { display: block; margin: 1em auto; max-width: 100%; }
+.question { font-style: italic; text-align: center; }
+.avatar { max-width: 50%; }
+.title-page, .page { page-break-after: always; break-after: page; }
+.page-sources { font-size: 0.7em; text-align: right; }
+.sources { font-size: 0.8em; }
+.sources li { margin-bottom: 0.5em; overflow-wrap: anywhere; }
+`
+
+var htmlFuncs = template.FuncMap{
+	"dataURI": func(p *picture) template.URL {
+		return template.URL("data:" + p.ContentType + ";base64," + base64.StdEncoding.EncodeToString(p.Data))
+	},
+	"css": func() template.CSS { return template.CSS(bookCSS) },
+	"inc": func(i int) int { return i + 1 },
+}
+
+var htmlBook = template.Must(template.New("book").Funcs(htmlFuncs).Parse(`<!DOCTYPE html>
+<html lang="{{.Language}}">
+<head>
+  <meta charset="utf-8">
+  <meta name="viewport" content="width=device-width, initial-scale=1">
+  <title>{{.Title}}</title>
+  <style>{{css}}</style>
+</head>
+<body>
+  <section class="title-page">
+    <h1>{{.Title}}</h1>
+    {{- if ne .Question .Title}}
+    <p class="question">{{.Question}}</p>
+    {{- end}}
+    {{- with .Avatar}}
+    <img class="avatar" src="{{dataURI .}}" alt="The reader's avatar">
+    {{- end}}
+  </section>
+  {{- range .Pages}}
+  <section class="page">
+    {{- with .Image}}
+    <img src="{{dataURI .}}" alt="">
+    {{- end}}
+    {{- range .Paragraphs}}
+    <p>{{.}}</p>
+    {{- end}}
+    {{- with .Sources}}
+    <p class="page-sources">Sources:{{range .}} <a href="#source-{{.}}">[{{.}}]</a>{{end}}</p>
+    {{- end}}
+  </section>
+  {{- end}}
+  {{- with .Sources}}
+  <section class="sources">
+    <h2>Sources</h2>
+    <ol>
+      {{- range $i, $s := .}}
+      <li id="source-{{inc $i}}"><a href="{{$s.URL}}">{{$s.Title}}</a>{{if and $s.Domain (ne $s.Domain $s.Title)}} ({{$s.Domain}}){{end}}</li>
+      {{- end}}
+    </ol>
+  </section>
+  {{- end}}
+</body>
+</html>
+`))
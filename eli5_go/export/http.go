@@ -0,0 +1,57 @@
+package export
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+
+	"eli5/library"
+)
+
+// Handler serves GET /api/books/{id}/export?format=pdf|epub|html. The format
+// defaults to pdf.
+func Handler(store *library.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := r.URL.Query().Get("format")
+		if name == "" {
+			name = string(PDF)
+		}
+		f, err := ParseFormat(name)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		book, err := store.Get(r.PathValue("id"))
+		if errors.Is(err, library.ErrNotFound) {
+			http.Error(w, "book not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !book.Finished() {
+			http.Error(w, "book is still being generated", http.StatusConflict)
+			return
+		}
+
+		// Render fully before writing so that a failure can still be reported.
+		var buf bytes.Buffer
+		err = Write(&buf, f, book, store.ReadImage)
+		var unsupported *UnsupportedTextError
+		if errors.As(err, &unsupported) {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("failed to export book: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", f.ContentType())
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": FileName(book, f)}))
+		w.Write(buf.Bytes())
+	}
+}
@@ -0,0 +1,467 @@
+package export
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"strings"
+	"unicode/utf16"
+)
+
+// UnsupportedTextError is returned by Write for PDF exports of books whose
+// text has characters that the standard PDF fonts cannot show, such as books
+// in Japanese, Russian or Hindi. EPUB and HTML exports show any text.
+type UnsupportedTextError struct {
+	Char rune
+}
+
+func (e *UnsupportedTextError) Error() string {
+	return fmt.Sprintf("the PDF fonts cannot show %q in this book; export it as epub or html instead", e.Char)
+}
+
+// Page geometry in points (A4 portrait).
+const (
+	pdfPageWidth  = 595.0
+	pdfPageHeight = 842.0
+	pdfMargin     = 56.0
+	pdfFooter     = 36.0
+
+	pdfTitleSize   = 28.0
+	pdfHeadingSize = 20.0
+	pdfTextSize    = 16.0
+	pdfSmallSize   = 11.0
+	pdfLeading     = 1.4
+)
+
+// writePDF renders the document as a PDF with a title page followed by one or
+// more pages per storybook page and, if the book has any, a list of sources.
+// Text is set in the standard Helvetica fonts, which every PDF reader
+// provides, so no fonts are embedded. Their WinAnsi encoding only covers
+// Western European languages, so books with other characters are rejected
+// with an *UnsupportedTextError rather than printed with '?' in their place.
+// Characters of source titles, which are often in other languages than the
+// book, are still replaced.
+func writePDF(w io.Writer, doc *document) error {
+	if r, ok := unsupportedChar(doc); ok {
+		return &UnsupportedTextError{Char: r}
+	}
+	pw := newPDFWriter()
+	catalog, pagesRoot := pw.reserve(), pw.reserve()
+	fonts := map[string]int{"F1": pw.reserve(), "F2": pw.reserve()}
+	pw.object(fonts["F1"], "<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	pw.object(fonts["F2"], "<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+
+	var kids []int
+	addPage := func(content *bytes.Buffer, images map[string]int) {
+		num, contentNum := pw.reserve(), pw.reserve()
+		pw.stream(contentNum, "", content.Bytes(), true)
+		var res strings.Builder
+		res.WriteString("/Font << /F1 " + ref(fonts["F1"]) + " /F2 " + ref(fonts["F2"]) + " >>")
+		if len(images) > 0 {
+			res.WriteString(" /XObject <<")
+			for name, n := range images {
+				res.WriteString(" /" + name + " " + ref(n))
+			}
+			res.WriteString(" >>")
+		}
+		pw.object(num, fmt.Sprintf("<< /Type /Page /Parent %s /MediaBox [0 0 %g %g] /Resources << %s >> /Contents %s >>",
+			ref(pagesRoot), pdfPageWidth, pdfPageHeight, res.String(), ref(contentNum)))
+		kids = append(kids, num)
+	}
+
+	textWidth := pdfPageWidth - 2*pdfMargin
+
+	// Title page.
+	var c bytes.Buffer
+	images := map[string]int{}
+	y := pdfPageHeight - 2.5*pdfMargin
+	for _, line := range wrapText(doc.Title, pdfTitleSize, true, textWidth) {
+		y -= pdfTitleSize * pdfLeading
+		drawText(&c, "F2", pdfTitleSize, (pdfPageWidth-textWidthOf(line, pdfTitleSize, true))/2, y, line)
+	}
+	if doc.Question != "" && doc.Question != doc.Title {
+		y -= pdfTextSize
+		for _, line := range wrapText(doc.Question, pdfTextSize, false, textWidth) {
+			y -= pdfTextSize * pdfLeading
+			drawText(&c, "F1", pdfTextSize, (pdfPageWidth-textWidthOf(line, pdfTextSize, false))/2, y, line)
+		}
+	}
+	if y -= 2 * pdfTextSize; doc.Avatar != nil && y > 2*(pdfMargin+pdfFooter) {
+		if img, err := pw.image(doc.Avatar); err == nil {
+			images["Im0"] = img.num
+			drawImage(&c, "Im0", img, (pdfPageWidth-textWidth/2)/2, pdfMargin+pdfFooter, textWidth/2, y-pdfMargin-pdfFooter)
+		}
+	}
+	addPage(&c, images)
+
+	// Pages are numbered after the title page, which is kids[0].
+	footer := func() {
+		label := fmt.Sprint(len(kids))
+		drawText(&c, "F1", 10, (pdfPageWidth-textWidthOf(label, 10, false))/2, pdfFooter-10, label)
+	}
+	// nextLine moves down one line of text of the given size. If the line
+	// does not fit, the text continues on a new page.
+	nextLine := func(size float64) {
+		y -= size * pdfLeading
+		if y < pdfMargin+pdfFooter {
+			footer()
+			addPage(&c, images)
+			c.Reset()
+			images = map[string]int{}
+			y = pdfPageHeight - pdfMargin - size*pdfLeading
+		}
+	}
+
+	for _, p := range doc.Pages {
+		c.Reset()
+		images = map[string]int{}
+		y = pdfPageHeight - pdfMargin
+		if p.Image != nil {
+			if img, err := pw.image(p.Image); err == nil {
+				images["Im1"] = img.num
+				_, h := drawImage(&c, "Im1", img, pdfMargin, pdfPageHeight/2-pdfMargin, textWidth, y-(pdfPageHeight/2-pdfMargin))
+				y -= h + pdfTextSize
+			}
+		}
+		for _, para := range p.Paragraphs {
+			for _, line := range wrapText(para, pdfTextSize, false, textWidth) {
+				nextLine(pdfTextSize)
+				drawText(&c, "F1", pdfTextSize, pdfMargin, y, line)
+			}
+			y -= pdfTextSize * (pdfLeading - 1) * 2
+		}
+		if len(p.Sources) > 0 {
+			label := "Sources:"
+			for _, n := range p.Sources {
+				label += fmt.Sprintf(" [%d]", n)
+			}
+			nextLine(pdfSmallSize)
+			drawText(&c, "F1", pdfSmallSize, pdfPageWidth-pdfMargin-textWidthOf(label, pdfSmallSize, false), y, label)
+		}
+		footer()
+		addPage(&c, images)
+	}
+
+	if len(doc.Sources) > 0 {
+		c.Reset()
+		images = map[string]int{}
+		y = pdfPageHeight - pdfMargin
+		nextLine(pdfHeadingSize)
+		drawText(&c, "F2", pdfHeadingSize, pdfMargin, y, "Sources")
+		y -= pdfSmallSize
+		indent := 2 * pdfSmallSize
+		for i, s := range doc.Sources {
+			entry := fmt.Sprintf("%d. %s", i+1, s.Title)
+			if s.Domain != "" && s.Domain != s.Title {
+				entry += " (" + s.Domain + ")"
+			}
+			for j, line := range wrapText(entry, pdfSmallSize, false, textWidth) {
+				nextLine(pdfSmallSize)
+				x := pdfMargin
+				if j > 0 {
+					x += indent
+				}
+				drawText(&c, "F1", pdfSmallSize, x, y, line)
+			}
+			for _, line := range wrapText(s.URL, pdfSmallSize-2, false, textWidth-indent) {
+				nextLine(pdfSmallSize - 2)
+				drawText(&c, "F1", pdfSmallSize-2, pdfMargin+indent, y, line)
+			}
+			y -= pdfSmallSize * (pdfLeading - 1) * 2
+		}
+		footer()
+		addPage(&c, images)
+	}
+
+	var kidRefs []string
+	for _, k := range kids {
+		kidRefs = append(kidRefs, ref(k))
+	}
+	pw.object(pagesRoot, fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kidRefs, " "), len(kids)))
+	pw.object(catalog, "<< /Type /Catalog /Pages "+ref(pagesRoot)+" >>")
+	info := pw.reserve()
+	pw.object(info, fmt.Sprintf("<< /Title %s /Producer (ELI5) >>", pdfUnicodeString(doc.Title)))
+	return pw.finish(w, catalog, info)
+}
+
+// pdfWriter assembles PDF objects and writes the cross-reference table.
+type pdfWriter struct {
+	buf     bytes.Buffer
+	offsets []int // offsets[n] is the byte offset of object n
+	images  map[*picture]*pdfImage
+}
+
+type pdfImage struct {
+	num           int
+	width, height int
+}
+
+func newPDFWriter() *pdfWriter {
+	pw := &pdfWriter{offsets: []int{0}, images: map[*picture]*pdfImage{}}
+	// The binary comment marks the file as binary for transfer programs.
+	pw.buf.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	return pw
+}
+
+// reserve allocates an object number to be written later.
+func (pw *pdfWriter) reserve() int {
+	pw.offsets = append(pw.offsets, -1)
+	return len(pw.offsets) - 1
+}
+
+func (pw *pdfWriter) object(num int, body string) {
+	pw.offsets[num] = pw.buf.Len()
+	fmt.Fprintf(&pw.buf, "%d 0 obj\n%s\nendobj\n", num, body)
+}
+
+// stream writes a stream object. dict holds extra dictionary entries; if
+// compress is set the data is Flate-encoded.
+func (pw *pdfWriter) stream(num int, dict string, data []byte, compress bool) {
+	if compress {
+		var z bytes.Buffer
+		zw := zlib.NewWriter(&z)
+		zw.Write(data)
+		zw.Close()
+		data = z.Bytes()
+		dict += " /Filter /FlateDecode"
+	}
+	pw.offsets[num] = pw.buf.Len()
+	fmt.Fprintf(&pw.buf, "%d 0 obj\n<< %s /Length %d >>\nstream\n", num, strings.TrimSpace(dict), len(data))
+	pw.buf.Write(data)
+	pw.buf.WriteString("\nendstream\nendobj\n")
+}
+
+// image writes p as an image XObject, once per picture. JPEG data is embedded
+// as is; other formats are decoded and stored as Flate-compressed RGB.
+func (pw *pdfWriter) image(p *picture) (*pdfImage, error) {
+	if img, ok := pw.images[p]; ok {
+		return img, nil
+	}
+	if p.ContentType == "image/jpeg" {
+		cfg, err := jpeg.DecodeConfig(bytes.NewReader(p.Data))
+		if err != nil {
+			return nil, err
+		}
+		var space string
+		switch cfg.ColorModel {
+		case color.GrayModel:
+			space = "/DeviceGray"
+		case color.YCbCrModel:
+			space = "/DeviceRGB"
+		}
+		if space != "" {
+			num := pw.reserve()
+			pw.stream(num, fmt.Sprintf("/Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace %s /BitsPerComponent 8 /Filter /DCTDecode",
+				cfg.Width, cfg.Height, space), p.Data, false)
+			img := &pdfImage{num: num, width: cfg.Width, height: cfg.Height}
+			pw.images[p] = img
+			return img, nil
+		}
+		// CMYK JPEGs are often stored inverted; re-encode them as RGB instead.
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(p.Data))
+	if err != nil {
+		return nil, err
+	}
+	bounds := src.Bounds()
+	// Flatten any transparency onto a white page.
+	rgba := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.Draw(rgba, rgba.Bounds(), src, bounds.Min, draw.Over)
+	rgb := make([]byte, 0, bounds.Dx()*bounds.Dy()*3)
+	for i := 0; i < len(rgba.Pix); i += 4 {
+		rgb = append(rgb, rgba.Pix[i], rgba.Pix[i+1], rgba.Pix[i+2])
+	}
+	num := pw.reserve()
+	pw.stream(num, fmt.Sprintf("/Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace /DeviceRGB /BitsPerComponent 8",
+		bounds.Dx(), bounds.Dy()), rgb, true)
+	img := &pdfImage{num: num, width: bounds.Dx(), height: bounds.Dy()}
+	pw.images[p] = img
+	return img, nil
+}
+
+func (pw *pdfWriter) finish(w io.Writer, root, info int) error {
+	xref := pw.buf.Len()
+	fmt.Fprintf(&pw.buf, "xref\n0 %d\n0000000000 65535 f \n", len(pw.offsets))
+	for _, off := range pw.offsets[1:] {
+		fmt.Fprintf(&pw.buf, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&pw.buf, "trailer\n<< /Size %d /Root %s /Info %s >>\nstartxref\n%d\n%%%%EOF\n", len(pw.offsets), ref(root), ref(info), xref)
+	_, err := w.Write(pw.buf.Bytes())
+	return err
+}
+
+func ref(num int) string {
+	return fmt.Sprintf("%d 0 R", num)
+}
+
+// drawImage draws img scaled to fit the box with its lower left corner at
+// (x, y), centered horizontally and aligned to the top. It returns the drawn size.
+func drawImage(c *bytes.Buffer, name string, img *pdfImage, x, y, width, height float64) (float64, float64) {
+	scale := min(width/float64(img.width), height/float64(img.height))
+	w, h := float64(img.width)*scale, float64(img.height)*scale
+	fmt.Fprintf(c, "q %.2f 0 0 %.2f %.2f %.2f cm /%s Do Q\n", w, h, x+(width-w)/2, y+height-h, name)
+	return w, h
+}
+
+func drawText(c *bytes.Buffer, font string, size, x, y float64, text string) {
+	fmt.Fprintf(c, "BT /%s %g Tf %.2f %.2f Td %s Tj ET\n", font, size, x, y, pdfString(winAnsi(text)))
+}
+
+// wrapText breaks text into lines no wider than width.
+func wrapText(text string, size float64, bold bool, width float64) []string {
+	var lines []string
+	line := ""
+	for _, word := range strings.Fields(text) {
+		candidate := word
+		if line != "" {
+			candidate = line + " " + word
+		}
+		if textWidthOf(candidate, size, bold) <= width {
+			line = candidate
+			continue
+		}
+		if line != "" {
+			lines = append(lines, line)
+		}
+		// Break words that are longer than a whole line.
+		for textWidthOf(word, size, bold) > width {
+			n := len([]rune(word))
+			for n > 1 && textWidthOf(string([]rune(word)[:n]), size, bold) > width {
+				n--
+			}
+			lines = append(lines, string([]rune(word)[:n]))
+			word = string([]rune(word)[n:])
+		}
+		line = word
+	}
+	if line != "" {
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// textWidthOf returns the width of text in points. Helvetica-Bold is measured
+// with the regular metrics plus a margin, which is close enough for wrapping.
+func textWidthOf(text string, size float64, bold bool) float64 {
+	units := 0
+	for _, b := range winAnsi(text) {
+		units += helveticaWidth(b)
+	}
+	w := float64(units) * size / 1000
+	if bold {
+		w *= 1.1
+	}
+	return w
+}
+
+// helveticaWidths are the Helvetica glyph widths of the characters ' ' to '~',
+// in thousandths of the font size.
+var helveticaWidths = [...]int{
+	278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278,
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556,
+	1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778,
+	667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556,
+	333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556,
+	556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584,
+}
+
+func helveticaWidth(b byte) int {
+	switch {
+	case b >= ' ' && b <= '~':
+		return helveticaWidths[b-' ']
+	case b == 0x91 || b == 0x92:
+		return 222
+	case b == 0x93 || b == 0x94:
+		return 333
+	case b == 0x85 || b == 0x97:
+		return 1000
+	}
+	return 556
+}
+
+// winAnsiSpecials maps the characters of the Windows-1252 range 0x80-0x9F.
+var winAnsiSpecials = map[rune]byte{
+	'€': 0x80, '‚': 0x82, 'ƒ': 0x83, '„': 0x84, '…': 0x85, '†': 0x86, '‡': 0x87,
+	'ˆ': 0x88, '‰': 0x89, 'Š': 0x8A, '‹': 0x8B, 'Œ': 0x8C, 'Ž': 0x8E, '‘': 0x91,
+	'’': 0x92, '“': 0x93, '”': 0x94, '•': 0x95, '–': 0x96, '—': 0x97, '˜': 0x98,
+	'™': 0x99, 'š': 0x9A, '›': 0x9B, 'œ': 0x9C, 'ž': 0x9E, 'Ÿ': 0x9F,
+}
+
+// unsupportedChar returns the first character of the title, question or
+// pages of doc that winAnsi cannot encode, if any.
+func unsupportedChar(doc *document) (rune, bool) {
+	text := []string{doc.Title, doc.Question}
+	for _, p := range doc.Pages {
+		text = append(text, p.Paragraphs...)
+	}
+	for _, s := range text {
+		for _, r := range s {
+			if !inWinAnsi(r) {
+				return r, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// inWinAnsi reports whether winAnsi keeps r rather than replacing it with
+// '?'.
+func inWinAnsi(r rune) bool {
+	_, special := winAnsiSpecials[r]
+	return r <= '~' || r >= 0xA0 && r <= 0xFF || special
+}
+
+// winAnsi encodes text for the standard fonts. Characters outside the
+// encoding are replaced with '?'.
+func winAnsi(text string) []byte {
+	out := make([]byte, 0, len(text))
+	for _, r := range text {
+		switch {
+		case r >= ' ' && r <= '~' || r >= 0xA0 && r <= 0xFF:
+			out = append(out, byte(r))
+		case r == '\t' || r == '\n':
+			out = append(out, ' ')
+		default:
+			if b, ok := winAnsiSpecials[r]; ok {
+				out = append(out, b)
+			} else if r >= ' ' {
+				out = append(out, '?')
+			}
+		}
+	}
+	return out
+}
+
+// pdfString returns s as a PDF literal string.
+func pdfString(s []byte) string {
+	var b strings.Builder
+	b.WriteByte('(')
+	for _, c := range s {
+		if c == '(' || c == ')' || c == '\\' {
+			b.WriteByte('\\')
+		}
+		b.WriteByte(c)
+	}
+	b.WriteByte(')')
+	return b.String()
+}
+
+// pdfUnicodeString returns s as a UTF-16BE hex string, for document metadata.
+func pdfUnicodeString(s string) string {
+	var b strings.Builder
+	b.WriteString("<FEFF")
+	for _, u := range utf16.Encode([]rune(s)) {
+		fmt.Fprintf(&b, "%04X", u)
+	}
+	b.WriteByte('>')
+	return b.String()
+}
@@ -2,7 +2,9 @@ package flows
 
 import (
 	"context"
-	"fmt"
+
+	"eli5/media"
+	"eli5/moderation"
 
 	"github.com/firebase/genkit/go/ai"
 	"github.com/firebase/genkit/go/core"
@@ -13,29 +15,38 @@ type CartoonifyRequest struct {
 	Image string `json:"image" jsonschema:"description=A data URI of an image of a person to cartoonify"`
 }
 
-func DefineCartoonifyFlow(g *genkit.Genkit) *core.Flow[*CartoonifyRequest, string, struct{}] {
+func DefineCartoonifyFlow(g *genkit.Genkit, imageOpts media.Options, mod *moderation.Moderator) *core.Flow[*CartoonifyRequest, string, struct{}] {
 	return genkit.DefineFlow(g, "cartoonify", func(ctx context.Context, req *CartoonifyRequest) (string, error) {
+		img, err := ingestImage("image", req.Image, imageOpts)
+		if err != nil {
+			return "", err
+		}
+		if _, err := moderateInput(ctx, mod, imageField("image", img)); err != nil {
+			return "", err
+		}
+
 		resp, err := genkit.Generate(ctx, g,
 			ai.WithModelName("googleai/gemini-2.5-flash-image"),
 			ai.WithMessages(
 				ai.NewUserMessage(
 					ai.NewTextPart("Transform the person in the following image into a full-body cartoon character in a neutral pose. The background should be white."),
-					ai.NewMediaPart("image/jpeg", req.Image),
+					ai.NewMediaPart(img.ContentType, img.DataURI()),
 				),
 			),
 		)
 		if err != nil {
 			return "", err
 		}
-
-		if len(resp.Message.Content) > 0 {
-			for _, part := range resp.Message.Content {
-				if part.IsMedia() {
-					return part.Text, nil
-				}
-			}
-		}
-
-		return "", fmt.Errorf("no image was generated")
+		return generatedImage(resp)
 	})
 }
+
+// ingestImage validates and normalizes an image supplied by the user. Invalid
+// images are reported as INVALID_ARGUMENT so that the client gets a 400.
+func ingestImage(field, dataURI string, opts media.Options) (*media.Image, error) {
+	img, err := media.Ingest(dataURI, opts)
+	if err != nil {
+		return nil, core.NewError(core.INVALID_ARGUMENT, "invalid %s: %v", field, err)
+	}
+	return img, nil
+}
@@ -0,0 +1,104 @@
+package flows
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"eli5/media"
+	"eli5/moderation"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/core"
+	"github.com/firebase/genkit/go/genkit"
+)
+
+type CharacterSheetRequest struct {
+	Avatar string `json:"avatar" jsonschema:"description=the user's cartoon avatar as a data URI"`
+	// Description overrides the generated description of the character.
+	Description string `json:"description,omitempty" jsonschema:"description=optional description of the character's outfit and colors"`
+}
+
+// CharacterSheet is the canonical reference for how the user's character
+// looks. Pass it to every illustrate call of a book to keep the character
+// consistent from page to page.
+type CharacterSheet struct {
+	Image       string `json:"image" jsonschema:"description=the character reference sheet as a data URI"`
+	Description string `json:"description" jsonschema:"description=the character's appearance, outfit and colors"`
+}
+
+func DefineCharacterSheetFlow(g *genkit.Genkit, imageOpts media.Options, mod *moderation.Moderator) *core.Flow[*CharacterSheetRequest, *CharacterSheet, struct{}] {
+	return genkit.DefineFlow(g, "characterSheet", func(ctx context.Context, req *CharacterSheetRequest) (*CharacterSheet, error) {
+		avatar, err := ingestImage("avatar", req.Avatar, imageOpts)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := moderateInput(ctx, mod,
+			imageField("avatar", avatar),
+			textField("description", moderation.Input, &req.Description),
+		); err != nil {
+			return nil, err
+		}
+		sheet, description, err := generateCharacterSheet(ctx, g, avatar, req.Description)
+		if err != nil {
+			return nil, err
+		}
+		return &CharacterSheet{Image: sheet.DataURI(), Description: description}, nil
+	})
+}
+
+// generateCharacterSheet turns the user's avatar into a reference sheet for
+// the illustrator. Unless description is given, it also describes the
+// character so that the illustration prompt can reinforce the details.
+func generateCharacterSheet(ctx context.Context, g *genkit.Genkit, avatar *media.Image, description string) (*media.Image, string, error) {
+	resp, err := genkit.Generate(ctx, g,
+		ai.WithModelName("googleai/gemini-2.5-flash-image"),
+		ai.WithMessages(
+			ai.NewUserMessage(
+				ai.NewTextPart("Create a character reference sheet for the cartoon character in the following image. Show the same character full-body from the front and in a three-quarter view, in a neutral pose on a plain white background. Keep the face, hairstyle, outfit and colors exactly the same as in the image. Do not add any text."),
+				ai.NewMediaPart(avatar.ContentType, avatar.DataURI()),
+			),
+		),
+	)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to generate character sheet: %w", err)
+	}
+	uri, err := generatedImage(resp)
+	if err != nil {
+		return nil, "", err
+	}
+	contentType, data, err := media.ParseDataURI(uri)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid character sheet: %w", err)
+	}
+	sheet := &media.Image{ContentType: contentType, Data: data}
+
+	if strings.TrimSpace(description) != "" {
+		return sheet, description, nil
+	}
+	resp, err = genkit.Generate(ctx, g,
+		ai.WithModelName("googleai/gemini-2.5-flash"),
+		ai.WithMessages(
+			ai.NewUserMessage(
+				ai.NewTextPart("Describe the appearance of this cartoon character for an illustrator who must draw them consistently: hairstyle and hair color, skin tone, eye color, outfit and its colors, and any distinctive features. Answer in 2-3 sentences without any preamble."),
+				ai.NewMediaPart(sheet.ContentType, sheet.DataURI()),
+			),
+		),
+	)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to describe character: %w", err)
+	}
+	return sheet, strings.TrimSpace(resp.Text()), nil
+}
+
+// generatedImage returns the first image in a model response as a data URI.
+func generatedImage(resp *ai.ModelResponse) (string, error) {
+	if resp.Message != nil {
+		for _, part := range resp.Message.Content {
+			if part.IsMedia() {
+				return part.Text, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("no image was generated")
+}
@@ -0,0 +1,442 @@
+package flows
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"eli5/jobs"
+	"eli5/library"
+	"eli5/media"
+	"eli5/moderation"
+
+	"github.com/firebase/genkit/go/core"
+	"github.com/firebase/genkit/go/genkit"
+)
+
+type CreateStorybookRequest struct {
+	Question string `json:"question"`
+	Avatar   string `json:"avatar,omitempty" jsonschema:"description=the user's cartoon avatar as a data URI"`
+	LessonOptions
+}
+
+type WatchStorybookRequest struct {
+	ID string `json:"id" jsonschema:"description=the ID of the book returned by createStorybook"`
+}
+
+const (
+	// illustrationConcurrency is the number of pages illustrated at once.
+	illustrationConcurrency = 3
+	// illustrationAttempts is the number of times a page is tried before it is marked as failed.
+	illustrationAttempts = 3
+)
+
+// StorybookJobs generates whole storybooks in the background, persisting
+// progress to the library after every step.
+type StorybookJobs struct {
+	g         *genkit.Genkit
+	store     *library.Store
+	imageOpts media.Options
+	mod       *moderation.Moderator
+	jobs      *jobs.Manager[*library.Book]
+}
+
+func NewStorybookJobs(g *genkit.Genkit, store *library.Store, imageOpts media.Options, mod *moderation.Moderator) *StorybookJobs {
+	return &StorybookJobs{g: g, store: store, imageOpts: imageOpts, mod: mod, jobs: jobs.NewManager[*library.Book]()}
+}
+
+// DefineCreateStorybookFlow defines a flow that starts a storybook job and
+// streams its progress. The job keeps running if the client disconnects; use
+// watchStorybook with the book ID to reconnect.
+func DefineCreateStorybookFlow(g *genkit.Genkit, sj *StorybookJobs) *core.Flow[*CreateStorybookRequest, *library.Book, *library.Book] {
+	return genkit.DefineStreamingFlow(g, "createStorybook", func(ctx context.Context, req *CreateStorybookRequest, sendChunk func(context.Context, *library.Book) error) (*library.Book, error) {
+		if strings.TrimSpace(req.Question) == "" {
+			return nil, core.NewError(core.INVALID_ARGUMENT, "question is required")
+		}
+		settings, err := req.LessonOptions.settings()
+		if err != nil {
+			return nil, err
+		}
+		var avatar *media.Image
+		if req.Avatar != "" {
+			if avatar, err = ingestImage("avatar", req.Avatar, sj.imageOpts); err != nil {
+				return nil, err
+			}
+		}
+		notes, err := moderateInput(ctx, sj.mod,
+			textField("question", moderation.Input, &req.Question),
+			imageField("avatar", avatar),
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		book, err := sj.store.Create(&library.Book{
+			Question:     req.Question,
+			Language:     settings.Language,
+			ReadingLevel: string(settings.ReadingLevel),
+			Tone:         string(settings.Tone),
+			PageCount:    settings.PageCount,
+			Status:       library.BookWriting,
+			Message:      "Studying to prepare lesson...",
+			Moderation:   notes,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create book: %w", err)
+		}
+		if avatar != nil {
+			if book.Avatar, err = sj.store.PutImage(book.ID, "avatar", avatar.DataURI()); err != nil {
+				sj.store.Delete(book.ID)
+				return nil, fmt.Errorf("failed to store avatar: %w", err)
+			}
+			if err := sj.store.Update(book); err != nil {
+				return nil, err
+			}
+		}
+
+		j := sj.start(ctx, book)
+		return sj.follow(ctx, j, sendChunk)
+	})
+}
+
+// DefineWatchStorybookFlow defines a flow that streams the progress of a
+// storybook job by book ID, or returns the stored book if the job has finished.
+func DefineWatchStorybookFlow(g *genkit.Genkit, sj *StorybookJobs) *core.Flow[*WatchStorybookRequest, *library.Book, *library.Book] {
+	return genkit.DefineStreamingFlow(g, "watchStorybook", func(ctx context.Context, req *WatchStorybookRequest, sendChunk func(context.Context, *library.Book) error) (*library.Book, error) {
+		if j, ok := sj.jobs.Get(req.ID); ok {
+			return sj.follow(ctx, j, sendChunk)
+		}
+		book, err := sj.store.Get(req.ID)
+		if err != nil {
+			return nil, core.NewError(core.NOT_FOUND, "book %q not found", req.ID)
+		}
+		return book, nil
+	})
+}
+
+// Resume restarts the jobs of books that were still being generated when the
+// server last stopped.
+func (sj *StorybookJobs) Resume(ctx context.Context) error {
+	summaries, err := sj.store.List()
+	if err != nil {
+		return err
+	}
+	for _, s := range summaries {
+		if s.Status != library.BookWriting && s.Status != library.BookIllustrating {
+			continue
+		}
+		book, err := sj.store.Get(s.ID)
+		if err != nil {
+			return err
+		}
+		sj.start(ctx, book)
+	}
+	return nil
+}
+
+func (sj *StorybookJobs) start(ctx context.Context, book *library.Book) *jobs.Job[*library.Book] {
+	j, _ := sj.jobs.Start(ctx, book.ID, book.Clone(), func(ctx context.Context, j *jobs.Job[*library.Book]) error {
+		return sj.run(ctx, newBookUpdater(sj.store, j, book))
+	})
+	return j
+}
+
+// follow streams a job's snapshots until it finishes or the client goes away.
+func (sj *StorybookJobs) follow(ctx context.Context, j *jobs.Job[*library.Book], sendChunk func(context.Context, *library.Book) error) (*library.Book, error) {
+	updates, unsubscribe := j.Subscribe()
+	defer unsubscribe()
+
+	latest := j.Latest()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case b, ok := <-updates:
+			if !ok {
+				return latest, nil
+			}
+			latest = b
+			if sendChunk != nil {
+				if err := sendChunk(ctx, b); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+}
+
+// bookUpdater applies changes to the book of a job, publishing a snapshot
+// after each change.
+type bookUpdater struct {
+	mu    sync.Mutex
+	store *library.Store
+	job   *jobs.Job[*library.Book]
+	book  *library.Book
+}
+
+func newBookUpdater(store *library.Store, j *jobs.Job[*library.Book], book *library.Book) *bookUpdater {
+	return &bookUpdater{store: store, job: j, book: book}
+}
+
+// update applies fn to the book and publishes the result. If persist is set,
+// the book is also saved so that progress survives a restart.
+func (u *bookUpdater) update(persist bool, fn func(b *library.Book)) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	fn(u.book)
+	snapshot := u.book.Clone()
+	u.job.Publish(snapshot)
+	if persist {
+		return u.store.Update(snapshot)
+	}
+	return nil
+}
+
+// snapshot returns a copy of the book.
+func (u *bookUpdater) snapshot() *library.Book {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.book.Clone()
+}
+
+// fail marks the book as failed and returns err.
+func (u *bookUpdater) fail(err error) error {
+	u.update(true, func(b *library.Book) {
+		b.Status = library.BookFailed
+		b.Message = ""
+		b.Error = err.Error()
+	})
+	return err
+}
+
+// run generates the lesson and storybook text if they do not exist yet, then
+// the character sheet, and then illustrates every page that is not done,
+// persisting each change.
+func (sj *StorybookJobs) run(ctx context.Context, u *bookUpdater) error {
+	book := u.snapshot()
+	if book.Status == library.BookWriting || len(book.Pages) == 0 {
+		settings, err := lessonOptionsOf(book).settings()
+		if err != nil {
+			return u.fail(err)
+		}
+		lesson, err := generateLesson(ctx, sj.g, book.Question, settings)
+		if err != nil {
+			return u.fail(err)
+		}
+		u.update(false, func(b *library.Book) { b.Message = "Generating lesson storybook..." })
+
+		storybook, err := generateStorybook(ctx, sj.g, sj.mod, lesson, settings, func(s *Storybook) {
+			u.update(false, func(b *library.Book) {
+				b.Title = s.BookTitle
+				b.Pages = toLibraryPages(s.Pages)
+			})
+		})
+		if err != nil {
+			return u.fail(err)
+		}
+		if err := u.update(true, func(b *library.Book) {
+			b.Title = storybook.BookTitle
+			b.Pages = toLibraryPages(storybook.Pages)
+			b.Sources = storybook.Sources
+			b.Moderation = append(b.Moderation, storybook.Moderation...)
+			b.Status = library.BookIllustrating
+			b.Message = "Illustrating pages..."
+		}); err != nil {
+			return u.fail(err)
+		}
+	}
+
+	if book.Avatar != "" && book.Character == nil {
+		u.update(false, func(b *library.Book) { b.Message = "Designing your character..." })
+		character := sj.createCharacter(ctx, book)
+		u.update(true, func(b *library.Book) {
+			b.Character = character
+			b.Message = "Illustrating pages..."
+		})
+	}
+
+	refs, err := sj.loadRefs(u.snapshot(), -1)
+	if err != nil {
+		return u.fail(err)
+	}
+
+	var pending []int
+	for i, page := range u.snapshot().Pages {
+		if page.Status != library.PageDone {
+			pending = append(pending, i)
+		}
+	}
+	// Illustrate one page first so that the others can match its style.
+	if refs.style == nil && len(pending) > 1 {
+		sj.illustratePage(ctx, u, pending[0], refs, "")
+		pending = pending[1:]
+		if refs, err = sj.loadRefs(u.snapshot(), -1); err != nil {
+			return u.fail(err)
+		}
+	}
+
+	sem := make(chan struct{}, illustrationConcurrency)
+	var wg sync.WaitGroup
+	for _, i := range pending {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			sj.illustratePage(ctx, u, i, refs, "")
+		}()
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		return u.fail(context.Cause(ctx))
+	}
+
+	return u.update(true, func(b *library.Book) {
+		b.Status = library.BookComplete
+		b.Message = ""
+		failed := 0
+		for _, p := range b.Pages {
+			if p.Status == library.PageFailed {
+				failed++
+			}
+		}
+		if failed > 0 {
+			b.Message = fmt.Sprintf("%d of %d pages could not be illustrated", failed, len(b.Pages))
+		}
+	})
+}
+
+// createCharacter generates and stores the character sheet of a book. If that
+// fails, the avatar itself is used as the reference so that the book can
+// still be illustrated.
+func (sj *StorybookJobs) createCharacter(ctx context.Context, book *library.Book) *library.Character {
+	character := &library.Character{Image: book.Avatar}
+	contentType, data, err := sj.store.ReadImage(book.Avatar)
+	if err != nil {
+		return character
+	}
+	sheet, description, err := generateCharacterSheet(ctx, sj.g, &media.Image{ContentType: contentType, Data: data}, "")
+	if err != nil {
+		return character
+	}
+	if url, err := sj.store.PutImage(book.ID, "character", sheet.DataURI()); err == nil {
+		character.Image = url
+		character.Description = description
+	}
+	return character
+}
+
+// loadRefs loads the character reference of a book and, as the style
+// reference, the illustration of the done page nearest to page skip.
+func (sj *StorybookJobs) loadRefs(book *library.Book, skip int) (illustrationRefs, error) {
+	var refs illustrationRefs
+	load := func(url string) (*media.Image, error) {
+		contentType, data, err := sj.store.ReadImage(url)
+		if err != nil {
+			return nil, err
+		}
+		return &media.Image{ContentType: contentType, Data: data}, nil
+	}
+
+	var err error
+	if book.Character != nil {
+		refs.character, err = load(book.Character.Image)
+	} else if book.Avatar != "" {
+		refs.character, err = load(book.Avatar)
+	}
+	if err != nil {
+		return refs, fmt.Errorf("failed to load character: %w", err)
+	}
+
+	best := -1
+	for i, p := range book.Pages {
+		if i == skip || p.Status != library.PageDone || p.Image == "" {
+			continue
+		}
+		if best < 0 || abs(i-skip) < abs(best-skip) {
+			best = i
+		}
+	}
+	if best >= 0 {
+		// A missing style reference is not worth failing the page for.
+		refs.style, _ = load(book.Pages[best].Image)
+	}
+	return refs, nil
+}
+
+// illustratePage generates and stores the illustration for page i, retrying
+// with backoff on failure. If every attempt fails, the page is marked as
+// failed and the last error is returned.
+func (sj *StorybookJobs) illustratePage(ctx context.Context, u *bookUpdater, i int, refs illustrationRefs, instructions string) error {
+	book := u.snapshot()
+	req := &IllustrationRequest{
+		Illustration: book.Pages[i].Illustration,
+		Question:     book.Question,
+		Instructions: instructions,
+	}
+	if book.Character != nil {
+		req.CharacterDescription = book.Character.Description
+	}
+
+	var err error
+retry:
+	for attempt := 1; attempt <= illustrationAttempts; attempt++ {
+		u.update(true, func(b *library.Book) {
+			b.Pages[i].Status = library.PageIllustrating
+			b.Pages[i].Attempts++
+		})
+
+		var image, url string
+		if image, err = generateIllustration(ctx, sj.g, req, refs); err == nil {
+			url, err = sj.store.PutImage(book.ID, library.PageImageName(i), image)
+		}
+		if err == nil {
+			u.update(true, func(b *library.Book) {
+				b.Pages[i].Status = library.PageDone
+				b.Pages[i].Image = url
+				b.Pages[i].Error = ""
+			})
+			return nil
+		}
+
+		if attempt < illustrationAttempts {
+			select {
+			case <-ctx.Done():
+				err = ctx.Err()
+				break retry
+			case <-time.After(time.Duration(attempt) * 2 * time.Second):
+			}
+		}
+	}
+	u.update(true, func(b *library.Book) {
+		b.Pages[i].Status = library.PageFailed
+		b.Pages[i].Error = err.Error()
+	})
+	return err
+}
+
+func lessonOptionsOf(book *library.Book) LessonOptions {
+	return LessonOptions{
+		ReadingLevel: ReadingLevel(book.ReadingLevel),
+		Language:     book.Language,
+		PageCount:    book.PageCount,
+		Tone:         Tone(book.Tone),
+	}
+}
+
+func toLibraryPages(pages []Page) []library.Page {
+	out := make([]library.Page, len(pages))
+	for i, p := range pages {
+		out[i] = library.Page{Text: p.Text, Illustration: p.Illustration, Sources: p.Sources, Status: library.PagePending}
+	}
+	return out
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,96 @@
+package flows
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/genkit"
+	"google.golang.org/genai"
+)
+
+// searchModel is the model that researches lessons. It is a Gemini model with
+// Google Search grounding, defined by DefineSearchModel.
+const searchModel = "eli5/gemini-2.5-pro-search"
+
+// DefineSearchModel defines a Gemini model that always uses Google Search
+// grounding and, unlike the googleai plugin, keeps the grounding metadata: it
+// is returned as the *genai.GroundingMetadata in ModelResponse.Custom. Only
+// text messages are supported.
+func DefineSearchModel(g *genkit.Genkit, client *genai.Client) ai.Model {
+	return genkit.DefineModel(g, searchModel, &ai.ModelOptions{
+		Label:    "Gemini 2.5 Pro with Google Search",
+		Supports: &ai.ModelSupports{Multiturn: true, SystemRole: true},
+	}, func(ctx context.Context, req *ai.ModelRequest, cb ai.ModelStreamCallback) (*ai.ModelResponse, error) {
+		config := &genai.GenerateContentConfig{}
+		if c, ok := req.Config.(*genai.GenerateContentConfig); ok && c != nil {
+			copied := *c
+			config = &copied
+		}
+		config.Tools = []*genai.Tool{{GoogleSearch: &genai.GoogleSearch{}}}
+
+		var contents []*genai.Content
+		for _, m := range req.Messages {
+			content := &genai.Content{Role: string(m.Role)}
+			for _, p := range m.Content {
+				if !p.IsText() {
+					return nil, fmt.Errorf("%s only supports text parts", searchModel)
+				}
+				content.Parts = append(content.Parts, genai.NewPartFromText(p.Text))
+			}
+			switch m.Role {
+			case ai.RoleSystem:
+				config.SystemInstruction = content
+				content.Role = ""
+				continue
+			case ai.RoleModel:
+				content.Role = genai.RoleModel
+			default:
+				content.Role = genai.RoleUser
+			}
+			contents = append(contents, content)
+		}
+
+		resp, err := client.Models.GenerateContent(ctx, "gemini-2.5-pro", contents, config)
+		if err != nil {
+			return nil, err
+		}
+		out := &ai.ModelResponse{
+			Request:      req,
+			Message:      &ai.Message{Role: ai.RoleModel},
+			FinishReason: ai.FinishReasonUnknown,
+			Usage:        &ai.GenerationUsage{},
+		}
+		if u := resp.UsageMetadata; u != nil {
+			out.Usage.InputTokens = int(u.PromptTokenCount)
+			out.Usage.OutputTokens = int(u.CandidatesTokenCount)
+			out.Usage.TotalTokens = int(u.TotalTokenCount)
+		}
+		if len(resp.Candidates) == 0 {
+			out.FinishReason = ai.FinishReasonBlocked
+			return out, nil
+		}
+		cand := resp.Candidates[0]
+		switch cand.FinishReason {
+		case genai.FinishReasonStop:
+			out.FinishReason = ai.FinishReasonStop
+		case genai.FinishReasonMaxTokens:
+			out.FinishReason = ai.FinishReasonLength
+		case genai.FinishReasonSafety, genai.FinishReasonRecitation, genai.FinishReasonProhibitedContent, genai.FinishReasonBlocklist:
+			out.FinishReason = ai.FinishReasonBlocked
+		default:
+			out.FinishReason = ai.FinishReasonOther
+		}
+		if cand.Content != nil {
+			for _, p := range cand.Content.Parts {
+				if p.Text != "" && !p.Thought {
+					out.Message.Content = append(out.Message.Content, ai.NewTextPart(p.Text))
+				}
+			}
+		}
+		if cand.GroundingMetadata != nil {
+			out.Custom = cand.GroundingMetadata
+		}
+		return out, nil
+	})
+}
@@ -3,6 +3,10 @@ package flows
 import (
 	"context"
 	"fmt"
+	"strings"
+
+	"eli5/media"
+	"eli5/moderation"
 
 	"github.com/firebase/genkit/go/ai"
 	"github.com/firebase/genkit/go/core"
@@ -10,35 +14,90 @@ import (
 )
 
 type IllustrationRequest struct {
-	UserImage    string `json:"userImage" jsonschema:"description=the user's image as a data URI"`
+	UserImage    string `json:"userImage" jsonschema:"description=the user's image as a data URI; ideally the image of a character sheet"`
 	Illustration string `json:"illustration" jsonschema:"description=a description of the illustration to generate"`
 	Question     string `json:"question" jsonschema:"description=the question the story is about"`
+	// CharacterDescription and StyleReference keep the illustrations of a
+	// book consistent with each other.
+	CharacterDescription string `json:"characterDescription,omitempty" jsonschema:"description=the description from the character sheet"`
+	StyleReference       string `json:"styleReference,omitempty" jsonschema:"description=an earlier illustration from the same book as a data URI, whose style to match"`
+	Instructions         string `json:"instructions,omitempty" jsonschema:"description=optional changes to make, e.g. when regenerating a page"`
+}
+
+// illustrationRefs are the validated reference images for an illustration.
+type illustrationRefs struct {
+	// character is the user's character sheet or avatar.
+	character *media.Image
+	// style is an earlier illustration from the same book.
+	style *media.Image
 }
 
-func DefineIllustrateFlow(g *genkit.Genkit) *core.Flow[*IllustrationRequest, string, struct{}] {
+func DefineIllustrateFlow(g *genkit.Genkit, imageOpts media.Options, mod *moderation.Moderator) *core.Flow[*IllustrationRequest, string, struct{}] {
 	return genkit.DefineFlow(g, "illustrate", func(ctx context.Context, req *IllustrationRequest) (string, error) {
-		resp, err := genkit.Generate(ctx, g,
-			ai.WithModelName("googleai/gemini-2.5-flash-image"),
-			ai.WithMessages(
-				ai.NewUserMessage(
-					ai.NewTextPart("[USER]:\n"),
-					ai.NewMediaPart("image/jpeg", req.UserImage),
-					ai.NewTextPart(fmt.Sprintf("You are illustrating a page in an educational storybook for a child. The story is about the question \"%s\". Generate the illustration described below in a friendly cartoon style. ONLY illustrate exactly what is described below. The illustration should be colorful with full-image backgrounds.\n\n%s", req.Question, req.Illustration)),
-				),
-			),
-		)
-		if err != nil {
+		var refs illustrationRefs
+		var err error
+		if req.UserImage != "" {
+			if refs.character, err = ingestImage("userImage", req.UserImage, imageOpts); err != nil {
+				return "", err
+			}
+		}
+		if req.StyleReference != "" {
+			if refs.style, err = ingestImage("styleReference", req.StyleReference, imageOpts); err != nil {
+				return "", err
+			}
+		}
+		if _, err := moderateInput(ctx, mod,
+			textField("question", moderation.Input, &req.Question),
+			textField("illustration", moderation.Illustration, &req.Illustration),
+			textField("characterDescription", moderation.Input, &req.CharacterDescription),
+			textField("instructions", moderation.Input, &req.Instructions),
+			imageField("userImage", refs.character),
+			imageField("styleReference", refs.style),
+		); err != nil {
 			return "", err
 		}
+		return generateIllustration(ctx, g, req, refs)
+	})
+}
 
-		if len(resp.Message.Content) > 0 {
-			for _, part := range resp.Message.Content {
-				if part.IsMedia() {
-					return part.Text, nil
-				}
-			}
+// generateIllustration generates a storybook illustration starring the user and returns it as a data URI.
+// The images in req are ignored in favor of refs, which must already be validated.
+func generateIllustration(ctx context.Context, g *genkit.Genkit, req *IllustrationRequest, refs illustrationRefs) (string, error) {
+	var parts []*ai.Part
+	var consistency []string
+	if refs.character != nil {
+		parts = append(parts,
+			ai.NewTextPart("[USER]:\n"),
+			ai.NewMediaPart(refs.character.ContentType, refs.character.DataURI()),
+		)
+		consistency = append(consistency, "Draw USER exactly as shown in the [USER] reference: the same face, hairstyle, outfit and colors.")
+		if req.CharacterDescription != "" {
+			consistency = append(consistency, "USER looks like this: "+req.CharacterDescription)
 		}
+	}
+	if refs.style != nil {
+		parts = append(parts,
+			ai.NewTextPart("[STYLE REFERENCE]:\n"),
+			ai.NewMediaPart(refs.style.ContentType, refs.style.DataURI()),
+		)
+		consistency = append(consistency, "Match the art style, line work and color palette of the [STYLE REFERENCE], another page of the same book, but do not copy its content.")
+	}
+	prompt := fmt.Sprintf("You are illustrating a page in an educational storybook for a child. The story is about the question \"%s\". Generate the illustration described below in a friendly cartoon style. ONLY illustrate exactly what is described below. The illustration should be colorful with full-image backgrounds.", req.Question)
+	if len(consistency) > 0 {
+		prompt += "\n\n" + strings.Join(consistency, " ")
+	}
+	prompt += "\n\n" + req.Illustration
+	if req.Instructions != "" {
+		prompt += "\n\nAlso follow these instructions: " + req.Instructions
+	}
+	parts = append(parts, ai.NewTextPart(prompt))
 
-		return "", fmt.Errorf("no image was generated")
-	})
+	resp, err := genkit.Generate(ctx, g,
+		ai.WithModelName("googleai/gemini-2.5-flash-image"),
+		ai.WithMessages(ai.NewUserMessage(parts...)),
+	)
+	if err != nil {
+		return "", err
+	}
+	return generatedImage(resp)
 }
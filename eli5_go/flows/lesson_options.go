@@ -0,0 +1,220 @@
+package flows
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/firebase/genkit/go/core"
+	"golang.org/x/text/language"
+	"golang.org/x/text/language/display"
+)
+
+type ReadingLevel string
+
+const (
+	Ages5To7  ReadingLevel = "ages-5-7"
+	Ages8To12 ReadingLevel = "ages-8-12"
+	Adult     ReadingLevel = "adult"
+)
+
+type Tone string
+
+const (
+	Playful     Tone = "playful"
+	Gentle      Tone = "gentle"
+	Adventurous Tone = "adventurous"
+	Factual     Tone = "factual"
+)
+
+const (
+	minPages     = 3
+	maxPages     = 12
+	defaultPages = 10
+)
+
+// LessonOptions tailor a lesson to its reader. The zero value writes up to 10
+// playful pages in English for adults.
+type LessonOptions struct {
+	ReadingLevel ReadingLevel `json:"readingLevel,omitempty" jsonschema:"enum=ages-5-7,enum=ages-8-12,enum=adult,description=who the lesson is written for; defaults to adult"`
+	Language     string       `json:"language,omitempty" jsonschema:"description=the language of the lesson as a BCP 47 tag such as en or pt-BR; defaults to en"`
+	PageCount    int          `json:"pageCount,omitempty" jsonschema:"minimum=3,maximum=12,description=the exact number of pages; by default the lesson has up to 10 pages"`
+	Tone         Tone         `json:"tone,omitempty" jsonschema:"enum=playful,enum=gentle,enum=adventurous,enum=factual,description=the tone of the writing; defaults to playful"`
+}
+
+var audiences = map[ReadingLevel]string{
+	Ages5To7:  "a child aged 5 to 7. Use very short sentences, everyday words and concrete examples from a child's life, and avoid jargon entirely",
+	Ages8To12: "a child aged 8 to 12. Use clear sentences and explain every new term the first time it is used",
+	Adult:     "an adult. Write in a simple and clear manner an adult would like to read using concepts that are simple and universal",
+}
+
+var tones = map[Tone]string{
+	Playful:     "approachable, fun, and easy to understand",
+	Gentle:      "calm, gentle and reassuring, like a bedtime story",
+	Adventurous: "exciting, like an adventure that the reader goes on",
+	Factual:     "clear and matter-of-fact, with a light touch",
+}
+
+// lessonSettings are validated LessonOptions with defaults applied, ready to
+// be used in prompts.
+type lessonSettings struct {
+	LessonOptions
+	Tag          language.Tag
+	LanguageName string
+	Audience     string
+	ToneStyle    string
+}
+
+// settings validates the options and applies defaults. Invalid options are
+// reported as INVALID_ARGUMENT.
+func (o LessonOptions) settings() (*lessonSettings, error) {
+	s := &lessonSettings{LessonOptions: o}
+	if s.ReadingLevel == "" {
+		s.ReadingLevel = Adult
+	}
+	if s.Tone == "" {
+		s.Tone = Playful
+	}
+	if s.Language == "" {
+		s.Language = "en"
+	}
+	var ok bool
+	if s.Audience, ok = audiences[s.ReadingLevel]; !ok {
+		return nil, core.NewError(core.INVALID_ARGUMENT, "unknown reading level %q", o.ReadingLevel)
+	}
+	if s.ToneStyle, ok = tones[s.Tone]; !ok {
+		return nil, core.NewError(core.INVALID_ARGUMENT, "unknown tone %q", o.Tone)
+	}
+	if s.PageCount != 0 && (s.PageCount < minPages || s.PageCount > maxPages) {
+		return nil, core.NewError(core.INVALID_ARGUMENT, "page count must be between %d and %d", minPages, maxPages)
+	}
+	tag, err := language.Parse(s.Language)
+	if err != nil {
+		return nil, core.NewError(core.INVALID_ARGUMENT, "invalid language %q: %v", o.Language, err)
+	}
+	s.Tag = tag
+	s.Language = tag.String()
+	if s.LanguageName = display.English.Tags().Name(tag); s.LanguageName == "" {
+		return nil, core.NewError(core.INVALID_ARGUMENT, "unsupported language %q", o.Language)
+	}
+	return s, nil
+}
+
+// PageLimit describes the requested length for the prompts.
+func (s *lessonSettings) PageLimit() string {
+	if s.PageCount > 0 {
+		return fmt.Sprintf("exactly %d pages", s.PageCount)
+	}
+	return fmt.Sprintf("no more than %d pages", defaultPages)
+}
+
+// check returns a description of how a storybook fails to match the settings,
+// or "" if it matches.
+func (s *lessonSettings) check(b *Storybook) string {
+	switch n := len(b.Pages); {
+	case s.PageCount > 0 && n != s.PageCount:
+		return fmt.Sprintf("it has %d pages instead of exactly %d", n, s.PageCount)
+	case n == 0:
+		return "it has no pages"
+	case s.PageCount == 0 && n > defaultPages:
+		return fmt.Sprintf("it has %d pages, more than %d", n, defaultPages)
+	}
+	text := []string{b.BookTitle}
+	for _, p := range b.Pages {
+		text = append(text, p.Text)
+	}
+	if problem := languageMismatch(strings.Join(text, "\n"), s.Tag); problem != "" {
+		return fmt.Sprintf("the text %s, but it must be written in %s", problem, s.LanguageName)
+	}
+	return ""
+}
+
+// scripts maps ISO 15924 script codes to the Unicode ranges that text in the
+// script mostly consists of.
+var scripts = map[string][]*unicode.RangeTable{
+	"Latn": {unicode.Latin},
+	"Cyrl": {unicode.Cyrillic},
+	"Grek": {unicode.Greek},
+	"Arab": {unicode.Arabic},
+	"Hebr": {unicode.Hebrew},
+	"Deva": {unicode.Devanagari},
+	"Beng": {unicode.Bengali},
+	"Taml": {unicode.Tamil},
+	"Thai": {unicode.Thai},
+	"Hans": {unicode.Han},
+	"Hant": {unicode.Han},
+	"Jpan": {unicode.Han, unicode.Hiragana, unicode.Katakana},
+	"Kore": {unicode.Hangul, unicode.Han},
+}
+
+// stopwords are frequent words that are distinctive for some common
+// languages written in the Latin script.
+var stopwords = map[string][]string{
+	"en": {"the", "and", "of", "is", "are", "with", "that", "this", "you", "it", "was", "for"},
+	"es": {"el", "la", "los", "las", "y", "es", "que", "del", "por", "con", "una", "para"},
+	"fr": {"le", "la", "les", "et", "est", "que", "des", "du", "une", "pour", "dans", "avec"},
+	"de": {"der", "die", "das", "und", "ist", "nicht", "mit", "ein", "eine", "sich", "auf", "den"},
+	"it": {"il", "la", "e", "che", "di", "è", "gli", "una", "per", "con", "del", "sono"},
+	"pt": {"o", "a", "os", "e", "que", "do", "da", "não", "uma", "para", "com", "são"},
+	"nl": {"de", "het", "een", "en", "van", "is", "dat", "niet", "met", "zijn", "voor", "ook"},
+}
+
+// languageMismatch guesses whether text is written in a language other than
+// want. It checks the writing system and, for some common languages, which
+// language's most frequent words the text uses. It returns a description of
+// the mismatch, or "" if the text may well be in want.
+func languageMismatch(text string, want language.Tag) string {
+	script, _ := want.Script()
+	if tables, ok := scripts[script.String()]; ok {
+		letters, inScript := 0, 0
+		for _, r := range text {
+			if !unicode.IsLetter(r) {
+				continue
+			}
+			letters++
+			if unicode.In(r, tables...) {
+				inScript++
+			}
+		}
+		if letters > 0 && inScript*2 < letters {
+			return fmt.Sprintf("is mostly not in the %s script", display.English.Scripts().Name(script))
+		}
+	}
+
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool { return !unicode.IsLetter(r) })
+	if len(words) < 20 {
+		return ""
+	}
+	hits := map[string]int{}
+	for lang, list := range stopwords {
+		set := map[string]bool{}
+		for _, w := range list {
+			set[w] = true
+		}
+		for _, w := range words {
+			if set[w] {
+				hits[lang]++
+			}
+		}
+	}
+	base, _ := want.Base()
+	_, known := stopwords[base.String()]
+	wantHits := hits[base.String()]
+	best := ""
+	for lang, n := range hits {
+		if lang != base.String() && (best == "" || n > hits[best]) {
+			best = lang
+		}
+	}
+	bestName := display.English.Tags().Name(language.Make(best))
+	switch {
+	case known:
+		// Require a clear margin: short words such as "a" and "de" are shared.
+		if hits[best]*10 >= len(words) && hits[best] >= 2*wantHits {
+			return "appears to be in " + bestName
+		}
+	case base.String() != "en" && hits["en"]*100 >= 15*len(words):
+		return "appears to be in English"
+	}
+	return ""
+}
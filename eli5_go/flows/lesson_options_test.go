@@ -0,0 +1,43 @@
+package flows
+
+import (
+	"testing"
+
+	"golang.org/x/text/language"
+)
+
+func TestLanguageMismatch(t *testing.T) {
+	const (
+		en = "The sky is blue because the light of the sun hits the air. The blue light is scattered more than the other colors, and that is why you see a blue sky during the day."
+		es = "El cielo es azul porque la luz del sol choca con el aire. La luz azul se dispersa más que los otros colores, y por eso vemos el cielo de color azul durante el día."
+		pt = "O céu é azul porque a luz do sol bate no ar. A luz azul se espalha mais que as outras cores, e por isso vemos o céu da cor azul durante o dia."
+	)
+	tests := []struct {
+		name, text, lang string
+		// mismatch is what the description must be, or "" for a match.
+		mismatch string
+	}{
+		{"same language", en, "en", ""},
+		{"other script", en, "ja", "is mostly not in the Japanese script"},
+		{"cyrillic for latin", "Небо голубое, потому что воздух рассеивает синий свет.", "en", "is mostly not in the Latin script"},
+		{"japanese", "空が青いのは、空気が青い光を散らすからです。", "ja", ""},
+		{"russian", "Небо голубое, потому что воздух рассеивает синий свет.", "ru", ""},
+		{"other latin language", es, "en", "appears to be in Spanish"},
+		{"english", en, "fr", "appears to be in English"},
+		// Spanish and Portuguese share many short words, but each one's
+		// text matches it and not the other.
+		{"spanish", es, "es", ""},
+		{"portuguese", pt, "pt", ""},
+		{"spanish for portuguese", es, "pt", "appears to be in Spanish"},
+		{"portuguese for spanish", pt, "es", "appears to be in Portuguese"},
+		// Short text has too few words to tell languages of a script apart.
+		{"short", "The sky is blue.", "fr", ""},
+		{"short other script", "The sky is blue.", "ru", "is mostly not in the Cyrillic script"},
+		{"no letters", "1, 2, 3!", "ja", ""},
+	}
+	for _, tt := range tests {
+		if got := languageMismatch(tt.text, language.Make(tt.lang)); got != tt.mismatch {
+			t.Errorf("%s: languageMismatch(%.20q, %s) = %q, want %q", tt.name, tt.text, tt.lang, got, tt.mismatch)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package flows
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	"eli5/library"
+	"eli5/media"
+	"eli5/moderation"
+
+	"github.com/firebase/genkit/go/core"
+	"github.com/firebase/genkit/go/genkit"
+)
+
+type SaveBookRequest struct {
+	Question      string          `json:"question"`
+	Storybook     *Storybook      `json:"storybook"`
+	Avatar        string          `json:"avatar,omitempty" jsonschema:"description=the user's cartoon avatar as a data URI"`
+	Character     *CharacterSheet `json:"character,omitempty" jsonschema:"description=the character sheet used to illustrate the book"`
+	Illustrations []string        `json:"illustrations,omitempty" jsonschema:"description=the page illustrations as data URIs in page order; use an empty string for a page without one"`
+	// LessonOptions are the options the storybook was generated with.
+	LessonOptions
+}
+
+// DefineSaveBookFlow defines a flow that saves a generated storybook and its
+// images to the library. Images are stored as files and referenced by URL.
+// The question, text and images are moderated again before saving, and the
+// sources checked, since the client may have changed them.
+func DefineSaveBookFlow(g *genkit.Genkit, store *library.Store, mod *moderation.Moderator) *core.Flow[*SaveBookRequest, *library.Book, struct{}] {
+	return genkit.DefineFlow(g, "saveBook", func(ctx context.Context, req *SaveBookRequest) (*library.Book, error) {
+		if req.Storybook == nil || len(req.Storybook.Pages) == 0 {
+			return nil, core.NewError(core.INVALID_ARGUMENT, "storybook has no pages")
+		}
+		if len(req.Illustrations) > len(req.Storybook.Pages) {
+			return nil, core.NewError(core.INVALID_ARGUMENT, "more illustrations than pages")
+		}
+
+		settings, err := req.LessonOptions.settings()
+		if err != nil {
+			return nil, err
+		}
+		for i, src := range req.Storybook.Sources {
+			if u, err := url.Parse(src.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return nil, core.NewError(core.INVALID_ARGUMENT, "source %d is not an http(s) URL", i+1)
+			}
+		}
+		fields := append([]moderated{textField("question", moderation.Input, &req.Question)}, storybookFields(req.Storybook)...)
+		if req.Avatar != "" {
+			f, err := dataURIField("avatar", req.Avatar)
+			if err != nil {
+				return nil, core.NewError(core.INVALID_ARGUMENT, "invalid avatar: %v", err)
+			}
+			fields = append(fields, f)
+		}
+		if req.Character != nil {
+			fields = append(fields, textField("character.description", moderation.Input, &req.Character.Description))
+			if req.Character.Image != "" {
+				f, err := dataURIField("character.image", req.Character.Image)
+				if err != nil {
+					return nil, core.NewError(core.INVALID_ARGUMENT, "invalid character sheet: %v", err)
+				}
+				fields = append(fields, f)
+			}
+		}
+		for i, img := range req.Illustrations {
+			if img == "" {
+				continue
+			}
+			f, err := dataURIField(fmt.Sprintf("illustrations[%d]", i), img)
+			if err != nil {
+				return nil, core.NewError(core.INVALID_ARGUMENT, "invalid illustration for page %d: %v", i+1, err)
+			}
+			fields = append(fields, f)
+		}
+		notes, err := moderateInput(ctx, mod, fields...)
+		if err != nil {
+			return nil, err
+		}
+		req.Storybook.linkSources()
+
+		book := &library.Book{
+			Question:     req.Question,
+			Title:        req.Storybook.BookTitle,
+			Language:     settings.Language,
+			ReadingLevel: string(settings.ReadingLevel),
+			Tone:         string(settings.Tone),
+			PageCount:    settings.PageCount,
+			Sources:      req.Storybook.Sources,
+			Moderation:   notes,
+		}
+		for _, p := range req.Storybook.Pages {
+			book.Pages = append(book.Pages, library.Page{Text: p.Text, Illustration: p.Illustration, Sources: p.Sources})
+		}
+		book, err = store.Create(book)
+		if err != nil {
+			return nil, fmt.Errorf("failed to save book: %w", err)
+		}
+
+		if req.Avatar != "" {
+			if book.Avatar, err = store.PutImage(book.ID, "avatar", req.Avatar); err != nil {
+				store.Delete(book.ID)
+				return nil, core.NewError(core.INVALID_ARGUMENT, "invalid avatar: %v", err)
+			}
+		}
+		if req.Character != nil && req.Character.Image != "" {
+			book.Character = &library.Character{Description: req.Character.Description}
+			if book.Character.Image, err = store.PutImage(book.ID, "character", req.Character.Image); err != nil {
+				store.Delete(book.ID)
+				return nil, core.NewError(core.INVALID_ARGUMENT, "invalid character sheet: %v", err)
+			}
+		}
+		for i, img := range req.Illustrations {
+			if img == "" {
+				continue
+			}
+			if book.Pages[i].Image, err = store.PutImage(book.ID, library.PageImageName(i), img); err != nil {
+				store.Delete(book.ID)
+				return nil, core.NewError(core.INVALID_ARGUMENT, "invalid illustration for page %d: %v", i+1, err)
+			}
+		}
+
+		if err := store.Update(book); err != nil {
+			return nil, fmt.Errorf("failed to save book: %w", err)
+		}
+		return book, nil
+	})
+}
+
+// dataURIField decodes an image data URI for moderation.
+func dataURIField(field, uri string) (moderated, error) {
+	_, data, err := media.ParseDataURI(uri)
+	if err != nil {
+		return moderated{}, err
+	}
+	return imageField(field, &media.Image{ContentType: media.Sniff(data), Data: data}), nil
+}
@@ -0,0 +1,197 @@
+package flows
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"eli5/media"
+	"eli5/moderation"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/core"
+	"github.com/firebase/genkit/go/genkit"
+)
+
+// modelClassifier is a moderation.Classifier that asks a model.
+type modelClassifier struct {
+	g     *genkit.Genkit
+	model string
+}
+
+// NewModelClassifier returns a moderation classifier that uses the named
+// model, e.g. "googleai/gemini-2.5-flash".
+func NewModelClassifier(g *genkit.Genkit, model string) moderation.Classifier {
+	return &modelClassifier{g: g, model: model}
+}
+
+type classification struct {
+	Decisions []classifiedItem `json:"decisions"`
+}
+
+type classifiedItem struct {
+	Item    int    `json:"item" jsonschema:"description=the number of the item"`
+	Action  string `json:"action" jsonschema:"enum=allow,enum=flag,enum=rewrite,enum=block"`
+	Reason  string `json:"reason,omitempty" jsonschema:"description=a short explanation a child or parent would understand; leave empty when allowing"`
+	Rewrite string `json:"rewrite,omitempty" jsonschema:"description=the rewritten text when the action is rewrite"`
+}
+
+var targetNames = map[moderation.Target]string{
+	moderation.Input:        "text written by the child",
+	moderation.Image:        "image uploaded by the child",
+	moderation.Text:         "generated storybook text",
+	moderation.Illustration: "generated description of a storybook illustration",
+}
+
+func (c *modelClassifier) Classify(ctx context.Context, guidelines string, items []moderation.Content) ([]moderation.Decision, error) {
+	parts := []*ai.Part{ai.NewTextPart(fmt.Sprintf(`You are the content moderator of a children's app. %s
+
+For each numbered item below, decide on one action:
+- allow: the item is suitable.
+- flag: the item is suitable but an adult should review it.
+- rewrite: the item is only unsuitable because of its wording. Provide a suitable version that keeps its meaning in "rewrite". Never rewrite images.
+- block: the item is unsuitable.
+
+Return exactly one decision for each of the %d items.`, guidelines, len(items)))}
+	for i, item := range items {
+		header := fmt.Sprintf("\n\n[ITEM %d: %s]\n", i+1, targetNames[item.Target])
+		if item.Image != nil {
+			parts = append(parts, ai.NewTextPart(header), ai.NewMediaPart(item.Image.ContentType, item.Image.DataURI()))
+		} else {
+			parts = append(parts, ai.NewTextPart(header+item.Text))
+		}
+	}
+
+	resp, err := genkit.Generate(ctx, c.g,
+		ai.WithModelName(c.model),
+		ai.WithMessages(ai.NewUserMessage(parts...)),
+		ai.WithOutputType(classification{}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	decisions := make([]moderation.Decision, len(items))
+	if resp.FinishReason == ai.FinishReasonBlocked {
+		// The model's own safety filters refused to look at the content.
+		for i := range decisions {
+			decisions[i] = moderation.Decision{Action: moderation.Block, Reason: "the content is not suitable for children"}
+		}
+		return decisions, nil
+	}
+	var out classification
+	if err := resp.Output(&out); err != nil {
+		return nil, err
+	}
+	seen := make([]bool, len(items))
+	for _, d := range out.Decisions {
+		i := d.Item - 1
+		if i < 0 || i >= len(items) || seen[i] {
+			continue
+		}
+		seen[i] = true
+		action := moderation.Action(strings.ToLower(strings.TrimSpace(d.Action)))
+		switch action {
+		case moderation.Allow, moderation.Flag, moderation.Rewrite, moderation.Block:
+		default:
+			// The schema lists the actions, but the model is not bound by it.
+			action = moderation.Block
+		}
+		decisions[i] = moderation.Decision{Action: action, Reason: d.Reason, Text: d.Rewrite}
+	}
+	for i := range seen {
+		if !seen[i] {
+			return nil, fmt.Errorf("no decision for item %d", i+1)
+		}
+	}
+	return decisions, nil
+}
+
+// moderated is a field of a request or result to moderate. If set is not
+// nil, it is called with the rewritten text when the field is rewritten.
+type moderated struct {
+	field   string
+	content moderation.Content
+	set     func(string)
+}
+
+func textField(field string, target moderation.Target, text *string) moderated {
+	return moderated{field: field, content: moderation.Content{Target: target, Text: *text}, set: func(s string) { *text = s }}
+}
+
+func imageField(field string, img *media.Image) moderated {
+	return moderated{field: field, content: moderation.Content{Target: moderation.Image, Image: img}}
+}
+
+// moderate checks fields, applies rewrites and returns notes on the fields
+// that were rewritten or flagged. Empty text fields and nil images are
+// skipped. If any field is blocked, it returns a note on the first one.
+func moderate(ctx context.Context, mod *moderation.Moderator, fields ...moderated) (notes []moderation.Note, blocked *moderation.Note, err error) {
+	var checked []moderated
+	var items []moderation.Content
+	for _, f := range fields {
+		if f.content.Image == nil && strings.TrimSpace(f.content.Text) == "" {
+			continue
+		}
+		checked = append(checked, f)
+		items = append(items, f.content)
+	}
+	if len(items) == 0 {
+		return nil, nil, nil
+	}
+	decisions, err := mod.Check(ctx, items...)
+	if err != nil {
+		return nil, nil, err
+	}
+	for i, d := range decisions {
+		f := checked[i]
+		if d.Action == moderation.Allow {
+			continue
+		}
+		note := moderation.Note{Field: f.field, Action: d.Action, Reason: d.Reason}
+		log.Printf("moderation: %s %s (%s): %s", d.Action, f.field, d.Rule, d.Reason)
+		switch d.Action {
+		case moderation.Block:
+			if blocked == nil {
+				blocked = &note
+			}
+			continue
+		case moderation.Rewrite:
+			if f.set != nil {
+				f.set(d.Text)
+			}
+		}
+		notes = append(notes, note)
+	}
+	return notes, blocked, nil
+}
+
+// moderateInput checks user input and reports blocked input as
+// INVALID_ARGUMENT, with the reason.
+func moderateInput(ctx context.Context, mod *moderation.Moderator, fields ...moderated) ([]moderation.Note, error) {
+	notes, blocked, err := moderate(ctx, mod, fields...)
+	if err != nil {
+		return nil, err
+	}
+	if blocked != nil {
+		return nil, core.NewError(core.INVALID_ARGUMENT, "%s was blocked: %s", blocked.Field, blocked.Reason)
+	}
+	return notes, nil
+}
+
+// storybookFields returns the fields of a generated storybook to moderate.
+func storybookFields(b *Storybook) []moderated {
+	fields := []moderated{textField("bookTitle", moderation.Text, &b.BookTitle)}
+	for i := range b.Pages {
+		fields = append(fields, pageFields(i, &b.Pages[i])...)
+	}
+	return fields
+}
+
+// pageFields returns the fields of page i of a storybook to moderate.
+func pageFields(i int, p *Page) []moderated {
+	return []moderated{
+		textField(fmt.Sprintf("pages[%d].text", i), moderation.Text, &p.Text),
+		textField(fmt.Sprintf("pages[%d].illustration", i), moderation.Illustration, &p.Illustration),
+	}
+}
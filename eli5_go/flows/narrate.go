@@ -0,0 +1,199 @@
+package flows
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"eli5/jobs"
+	"eli5/library"
+	"eli5/media"
+	"eli5/tts"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/core"
+	"github.com/firebase/genkit/go/genkit"
+	"google.golang.org/genai"
+)
+
+type NarrateRequest struct {
+	ID    string `json:"id" jsonschema:"description=the ID of the book"`
+	Voice string `json:"voice,omitempty" jsonschema:"description=the name of the voice to read with; defaults to the narrator's default voice"`
+	Force bool   `json:"force,omitempty" jsonschema:"description=narrate pages again even if they already have a narration in this voice"`
+}
+
+const (
+	// narrationConcurrency is the number of pages narrated at once.
+	narrationConcurrency = 3
+	// narrationAttempts is the number of times a page is tried before it is left without narration.
+	narrationAttempts = 3
+)
+
+// DefineNarrateFlow defines a flow that reads the text of every page of a
+// finished book aloud with synth and stores the recordings with the book.
+// Each page's narration has the URL of its audio and the timing of every
+// word, so that the client can highlight the text while it plays. Pages that
+// already have a narration in the requested voice are skipped unless
+// req.Force is set. Like regeneratePage, it runs as a job of the book and
+// streams the book as pages are narrated.
+func DefineNarrateFlow(g *genkit.Genkit, sj *StorybookJobs, synth tts.Synthesizer) *core.Flow[*NarrateRequest, *library.Book, *library.Book] {
+	return genkit.DefineStreamingFlow(g, "narrate", func(ctx context.Context, req *NarrateRequest, sendChunk func(context.Context, *library.Book) error) (*library.Book, error) {
+		book, err := sj.store.Get(req.ID)
+		if err != nil {
+			return nil, core.NewError(core.NOT_FOUND, "book %q not found", req.ID)
+		}
+		if !book.Finished() || len(book.Pages) == 0 {
+			return nil, core.NewError(core.FAILED_PRECONDITION, "book %q is still being generated", req.ID)
+		}
+
+		j, started := sj.jobs.Start(ctx, book.ID, book.Clone(), func(ctx context.Context, j *jobs.Job[*library.Book]) error {
+			// Reload the book in case a job finished after it was read above.
+			book, err := sj.store.Get(req.ID)
+			if err != nil {
+				return err
+			}
+			return sj.narrate(ctx, newBookUpdater(sj.store, j, book), synth, req.Voice, req.Force)
+		})
+		if !started {
+			return nil, core.NewError(core.FAILED_PRECONDITION, "book %q is still being generated", req.ID)
+		}
+		return sj.follow(ctx, j, sendChunk)
+	})
+}
+
+// narrate records the pages of the book that have no narration in voice, or
+// every page if force is set. The book's status is left as it was; progress
+// is reported in its message.
+func (sj *StorybookJobs) narrate(ctx context.Context, u *bookUpdater, synth tts.Synthesizer, voice string, force bool) error {
+	book := u.snapshot()
+	var pending []int
+	for i, p := range book.Pages {
+		if force || p.Narration == nil || p.Narration.Voice != voice {
+			pending = append(pending, i)
+		}
+	}
+	u.update(false, func(b *library.Book) {
+		b.Message = "Reading the book aloud..."
+	})
+
+	var mu sync.Mutex
+	failed := 0
+	sem := make(chan struct{}, narrationConcurrency)
+	var wg sync.WaitGroup
+	for _, i := range pending {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			if err := sj.narratePage(ctx, u, synth, book, i, voice); err != nil {
+				mu.Lock()
+				failed++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	return u.update(true, func(b *library.Book) {
+		b.Message = ""
+		switch {
+		case ctx.Err() != nil:
+			b.Message = "Narration was cancelled"
+		case failed > 0:
+			b.Message = fmt.Sprintf("%d of %d pages could not be narrated", failed, len(b.Pages))
+		}
+	})
+}
+
+// narratePage records page i, retrying with backoff on failure. The previous
+// narration of the page, if any, is kept if every attempt fails.
+func (sj *StorybookJobs) narratePage(ctx context.Context, u *bookUpdater, synth tts.Synthesizer, book *library.Book, i int, voice string) error {
+	req := &tts.Request{Text: book.Pages[i].Text, Language: book.Language, Voice: voice}
+	var err error
+	for attempt := 1; attempt <= narrationAttempts; attempt++ {
+		var speech *tts.Speech
+		var url string
+		if speech, err = synth.Synthesize(ctx, req); err == nil {
+			url, err = sj.store.PutAudio(book.ID, library.PageAudioName(i), speech.ContentType, speech.Data)
+		}
+		if err == nil {
+			return u.update(true, func(b *library.Book) {
+				b.Pages[i].Narration = &library.Narration{
+					Audio:      url,
+					Voice:      voice,
+					DurationMs: int(speech.Duration.Milliseconds()),
+					Words:      speech.Words,
+				}
+			})
+		}
+
+		if attempt < narrationAttempts {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(time.Duration(attempt) * 2 * time.Second):
+			}
+		}
+	}
+	return err
+}
+
+// modelSynthesizer is a tts.Synthesizer that uses a Gemini speech model.
+type modelSynthesizer struct {
+	g     *genkit.Genkit
+	model string
+}
+
+// defaultVoice is the Gemini prebuilt voice used when none is requested.
+const defaultVoice = "Kore"
+
+// NewModelSynthesizer returns a synthesizer that uses the named Gemini speech
+// model, e.g. "googleai/gemini-2.5-flash-preview-tts". Gemini does not report
+// word timings, so they are estimated from the length of the recording.
+func NewModelSynthesizer(g *genkit.Genkit, model string) tts.Synthesizer {
+	return &modelSynthesizer{g: g, model: model}
+}
+
+func (s *modelSynthesizer) Synthesize(ctx context.Context, req *tts.Request) (*tts.Speech, error) {
+	voice := req.Voice
+	if voice == "" {
+		voice = defaultVoice
+	}
+	prompt := "Read this page of a children's storybook aloud, warmly and slowly, exactly as written:\n\n" + req.Text
+	resp, err := genkit.Generate(ctx, s.g,
+		ai.WithModelName(s.model),
+		ai.WithPrompt("%s", prompt),
+		ai.WithConfig(&genai.GenerateContentConfig{
+			ResponseModalities: []string{"AUDIO"},
+			SpeechConfig: &genai.SpeechConfig{
+				VoiceConfig: &genai.VoiceConfig{
+					PrebuiltVoiceConfig: &genai.PrebuiltVoiceConfig{VoiceName: voice},
+				},
+			},
+		}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if resp.FinishReason == ai.FinishReasonBlocked {
+		return nil, fmt.Errorf("the page could not be read aloud: %s", resp.FinishMessage)
+	}
+	for _, p := range resp.Message.Content {
+		if !p.IsMedia() || !strings.HasPrefix(p.ContentType, "audio/") {
+			continue
+		}
+		_, data, err := media.ParseDataURI(p.Text)
+		if err != nil {
+			return nil, err
+		}
+		wav, d, err := tts.FromPCM(p.ContentType, data)
+		if err != nil {
+			return nil, err
+		}
+		return &tts.Speech{ContentType: "audio/wav", Data: wav, Duration: d, Words: tts.Timings(req.Text, d)}, nil
+	}
+	return nil, fmt.Errorf("%s returned no audio", s.model)
+}
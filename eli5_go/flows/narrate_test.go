@@ -0,0 +1,110 @@
+package flows
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"eli5/library"
+	"eli5/media"
+	"eli5/tts"
+
+	"github.com/firebase/genkit/go/core"
+	"github.com/firebase/genkit/go/genkit"
+)
+
+// countingSynth is tts.Silent that counts the pages it reads.
+type countingSynth struct {
+	tts.Silent
+	calls atomic.Int32
+}
+
+func (s *countingSynth) Synthesize(ctx context.Context, req *tts.Request) (*tts.Speech, error) {
+	s.calls.Add(1)
+	return s.Silent.Synthesize(ctx, req)
+}
+
+func TestNarrateWithSilent(t *testing.T) {
+	ctx := context.Background()
+	store, err := library.NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	book, err := store.Create(&library.Book{
+		Question: "Why is the sun hot?",
+		Status:   library.BookComplete,
+		Pages: []library.Page{
+			{Text: "The sun is hot."},
+			{Text: "It is a big ball of gas."},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	unfinished, err := store.Create(&library.Book{Status: library.BookWriting, Pages: []library.Page{{Text: "Once"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	g := genkit.Init(ctx)
+	synth := &countingSynth{Silent: tts.Silent{WordsPerMinute: 600}}
+	narrate := DefineNarrateFlow(g, NewStorybookJobs(g, store, media.Options{}, nil), synth)
+
+	got, err := narrate.Run(ctx, &NarrateRequest{ID: book.ID, Voice: "Kore"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, p := range got.Pages {
+		n := p.Narration
+		if n == nil {
+			t.Fatalf("page %d has no narration", i+1)
+		}
+		words := len(strings.Fields(p.Text))
+		if n.Voice != "Kore" || n.DurationMs != words*100 || len(n.Words) != words {
+			t.Errorf("page %d narration = %+v, want voice Kore, %d words and %dms", i+1, n, words, words*100)
+		}
+		file, err := store.AudioPath(book.ID, path.Base(n.Audio))
+		if err != nil {
+			t.Fatalf("page %d audio %s: %v", i+1, n.Audio, err)
+		}
+		if data, err := os.ReadFile(file); err != nil || !bytes.HasPrefix(data, []byte("RIFF")) {
+			t.Errorf("page %d audio is not a WAV file (err %v)", i+1, err)
+		}
+	}
+	if stored, err := store.Get(book.ID); err != nil || stored.Pages[1].Narration == nil {
+		t.Errorf("narration was not saved with the book (err %v)", err)
+	}
+
+	// Pages already narrated in the voice are skipped unless forced.
+	if _, err := narrate.Run(ctx, &NarrateRequest{ID: book.ID, Voice: "Kore"}); err != nil {
+		t.Fatal(err)
+	}
+	if n := synth.calls.Load(); n != 2 {
+		t.Errorf("narrating again read %d pages, want none", n-2)
+	}
+	if _, err := narrate.Run(ctx, &NarrateRequest{ID: book.ID, Voice: "Kore", Force: true}); err != nil {
+		t.Fatal(err)
+	}
+	if n := synth.calls.Load(); n != 4 {
+		t.Errorf("forced narration read %d pages, want 2", n-2)
+	}
+
+	for _, tt := range []struct {
+		id     string
+		status core.StatusName
+	}{
+		{library.NewID(), core.NOT_FOUND},
+		{unfinished.ID, core.FAILED_PRECONDITION},
+	} {
+		_, err := narrate.Run(ctx, &NarrateRequest{ID: tt.id})
+		var gerr *core.GenkitError
+		if !errors.As(err, &gerr) || gerr.Status != tt.status {
+			t.Errorf("narrating book %s: error = %v, want %s", tt.id, err, tt.status)
+		}
+	}
+}
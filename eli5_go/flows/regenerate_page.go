@@ -0,0 +1,88 @@
+package flows
+
+import (
+	"context"
+	"fmt"
+
+	"eli5/jobs"
+	"eli5/library"
+	"eli5/moderation"
+
+	"github.com/firebase/genkit/go/core"
+	"github.com/firebase/genkit/go/genkit"
+)
+
+type RegeneratePageRequest struct {
+	ID           string `json:"id" jsonschema:"description=the ID of the book"`
+	Page         int    `json:"page" jsonschema:"description=the number of the page to regenerate, starting at 1"`
+	Instructions string `json:"instructions,omitempty" jsonschema:"description=optional changes to make to the illustration"`
+}
+
+// DefineRegeneratePageFlow defines a flow that redraws the illustration of a
+// single page of a finished book, using the book's character sheet and its
+// nearest illustrated page as references so that the new illustration fits
+// in. The previous illustration is kept if regeneration fails.
+func DefineRegeneratePageFlow(g *genkit.Genkit, sj *StorybookJobs) *core.Flow[*RegeneratePageRequest, *library.Book, *library.Book] {
+	return genkit.DefineStreamingFlow(g, "regeneratePage", func(ctx context.Context, req *RegeneratePageRequest, sendChunk func(context.Context, *library.Book) error) (*library.Book, error) {
+		book, err := sj.store.Get(req.ID)
+		if err != nil {
+			return nil, core.NewError(core.NOT_FOUND, "book %q not found", req.ID)
+		}
+		if req.Page < 1 || req.Page > len(book.Pages) {
+			return nil, core.NewError(core.INVALID_ARGUMENT, "page must be between 1 and %d", len(book.Pages))
+		}
+		if !book.Finished() {
+			return nil, core.NewError(core.FAILED_PRECONDITION, "book %q is still being generated", req.ID)
+		}
+		if _, err := moderateInput(ctx, sj.mod, textField("instructions", moderation.Input, &req.Instructions)); err != nil {
+			return nil, err
+		}
+
+		j, started := sj.jobs.Start(ctx, book.ID, book.Clone(), func(ctx context.Context, j *jobs.Job[*library.Book]) error {
+			// Reload the book in case a job finished after it was read above.
+			book, err := sj.store.Get(req.ID)
+			if err != nil {
+				return err
+			}
+			return sj.regenerate(ctx, newBookUpdater(sj.store, j, book), req.Page-1, req.Instructions)
+		})
+		if !started {
+			return nil, core.NewError(core.FAILED_PRECONDITION, "book %q is still being generated", req.ID)
+		}
+		return sj.follow(ctx, j, sendChunk)
+	})
+}
+
+// regenerate redraws the illustration of page i.
+func (sj *StorybookJobs) regenerate(ctx context.Context, u *bookUpdater, i int, instructions string) error {
+	book := u.snapshot()
+	previous := book.Pages[i]
+	u.update(true, func(b *library.Book) {
+		b.Status = library.BookIllustrating
+		b.Message = fmt.Sprintf("Regenerating page %d...", i+1)
+		b.Error = ""
+	})
+
+	// Books created before character sheets existed get one now.
+	if book.Avatar != "" && book.Character == nil {
+		character := sj.createCharacter(ctx, book)
+		u.update(true, func(b *library.Book) { b.Character = character })
+	}
+
+	refs, err := sj.loadRefs(u.snapshot(), i)
+	if err == nil {
+		err = sj.illustratePage(ctx, u, i, refs, instructions)
+	}
+	return u.update(true, func(b *library.Book) {
+		b.Status = library.BookComplete
+		b.Message = ""
+		if err != nil {
+			b.Message = fmt.Sprintf("Page %d could not be regenerated", i+1)
+			if previous.Image != "" {
+				b.Pages[i].Status = library.PageDone
+				b.Pages[i].Image = previous.Image
+				b.Pages[i].Error = err.Error()
+			}
+		}
+	})
+}
@@ -3,9 +3,16 @@ package flows
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"slices"
+	"strings"
+	"text/template"
+
+	"eli5/grounding"
+	"eli5/jsonstream"
+	"eli5/moderation"
 
-	jsonrepair "github.com/RealAlexandreAI/json-repair"
 	"github.com/firebase/genkit/go/ai"
 	"github.com/firebase/genkit/go/core"
 	"github.com/firebase/genkit/go/genkit"
@@ -14,89 +21,362 @@ import (
 
 type StorifyRequest struct {
 	Question string `json:"question"`
+	LessonOptions
 }
 
 type Page struct {
 	Text         string `json:"text" jsonschema:"description=1-2 paragraphs of text explaining a key concept or idea about the subject"`
 	Illustration string `json:"illustration" jsonschema:"description=a detailed description of the image that should accompany the text for this page of the lesson"`
+	Sources      []int  `json:"sources,omitempty" jsonschema:"description=the numbers of the lesson's sources that support this page"`
 }
 
 type Storybook struct {
-	Status    string `json:"status,omitempty" jsonschema:"description=do not fill this in"`
-	BookTitle string `json:"bookTitle,omitempty" jsonschema:"description=a fun title for the lesson"`
-	Pages     []Page `json:"pages,omitempty"`
+	Status    string           `json:"status,omitempty" jsonschema:"description=do not fill this in"`
+	Update    *StorybookUpdate `json:"update,omitempty" jsonschema:"description=do not fill this in"`
+	BookTitle string           `json:"bookTitle,omitempty" jsonschema:"description=a fun title for the lesson"`
+	Pages     []Page           `json:"pages,omitempty"`
+	// Sources are the web pages the lesson is based on. Page.Sources refers
+	// to them by number, starting at 1.
+	Sources []grounding.Source `json:"sources,omitempty" jsonschema:"description=do not fill this in"`
+	// Moderation lists the parts of the request and storybook that were
+	// rewritten or flagged by content moderation.
+	Moderation []moderation.Note `json:"moderation,omitempty" jsonschema:"description=do not fill this in"`
+}
+
+type StorybookUpdateType string
+
+const (
+	TitleExtended StorybookUpdateType = "title_extended"
+	PageComplete  StorybookUpdateType = "page_complete"
+	// Restarted means that the storybook generated so far was rejected and
+	// is being generated again; the pages streamed so far should be discarded.
+	Restarted StorybookUpdateType = "restarted"
+)
+
+// StorybookUpdate describes what changed in a streamed partial Storybook.
+type StorybookUpdate struct {
+	Type StorybookUpdateType `json:"type"`
+	// Page is the index of the page that changed. It is unused for title updates.
+	Page int `json:"page"`
+	// Text is the text added to the title or page.
+	Text string `json:"text,omitempty"`
 }
 
-func DefineStorifyFlow(g *genkit.Genkit) *core.Flow[*StorifyRequest, *Storybook, *Storybook] {
+func DefineStorifyFlow(g *genkit.Genkit, mod *moderation.Moderator) *core.Flow[*StorifyRequest, *Storybook, *Storybook] {
 	return genkit.DefineStreamingFlow(g, "storify", func(ctx context.Context, req *StorifyRequest, sendChunk func(context.Context, *Storybook) error) (*Storybook, error) {
+		settings, err := req.LessonOptions.settings()
+		if err != nil {
+			return nil, err
+		}
+		notes, err := moderateInput(ctx, mod, textField("question", moderation.Input, &req.Question))
+		if err != nil {
+			return nil, err
+		}
+
 		if sendChunk != nil {
 			sendChunk(ctx, &Storybook{Status: "Studying to prepare lesson..."})
 		}
 
-		lessonPrompt := `You are an app that helps people understand complex concepts in a simple and fun way. The user has a question that they want explained in an engaging way. Your task is:
-
-1. Search Google to get an accurate and grounded picture of the topic at hand.
-2. Generate a "lesson plan" that accurately and approachably explains the core concepts of the lesson.
-3. Break the lesson down into no more than 10 key ideas. Make sure to include details that could be turned into nice illustrations.
-
-User question: {{question}}`
-
-		lessonResponse, err := genkit.Generate(ctx, g,
-			ai.WithModelName("googleai/gemini-2.5-pro"),
-			ai.WithPrompt(lessonPrompt, map[string]any{"question": req.Question}),
-			ai.WithConfig(&genai.GenerateContentConfig{
-				Temperature: genai.Ptr[float32](0.3),
-				Tools: []*genai.Tool{
-					{
-						GoogleSearch: &genai.GoogleSearch{},
-					},
-				},
-			}),
-		)
+		lesson, err := generateLesson(ctx, g, req.Question, settings)
 		if err != nil {
-			return nil, fmt.Errorf("failed to generate lesson: %w", err)
+			return nil, err
 		}
-		lesson := lessonResponse.Text()
 
 		if sendChunk != nil {
 			sendChunk(ctx, &Storybook{Status: "Generating lesson storybook..."})
 		}
 
-		storybookPrompt := `You are an app that helps people understand complex concepts in a simple and fun way. The user has a question that they want explained in an engaging way. A lesson plan has already been generated and included below. Your task is to generate up to 10 pages of a simple "storybook lesson" that explains the subject. Each page should include 1-2 paragraphs and a detailed description of an illustration to accompany it.
+		var onPartial func(*Storybook)
+		if sendChunk != nil {
+			onPartial = func(s *Storybook) {
+				s.Status = "Generating lesson storybook..."
+				sendChunk(ctx, s)
+			}
+		}
+		storybook, err := generateStorybook(ctx, g, mod, lesson, settings, onPartial)
+		if err != nil {
+			return nil, err
+		}
+		storybook.Moderation = append(notes, storybook.Moderation...)
+		return storybook, nil
+	})
+}
+
+var lessonPrompt = template.Must(template.New("lesson").Parse(`You are an app that helps people understand complex concepts in a simple and fun way. The user has a question that they want explained in an engaging way. Your task is:
+
+1. Search Google to get an accurate and grounded picture of the topic at hand.
+2. Generate a "lesson plan" that accurately and approachably explains the core concepts of the lesson to {{.Audience}}.
+3. Break the lesson down into no more than {{if .PageCount}}{{.PageCount}}{{else}}10{{end}} key ideas. Make sure to include details that could be turned into nice illustrations.
+
+User question: {{.Question}}`))
+
+var storybookPrompt = template.Must(template.New("storybook").Parse(`You are an app that helps people understand complex concepts in a simple and fun way. The user has a question that they want explained in an engaging way. A lesson plan has already been generated and included below. Your task is to generate {{.PageLimit}} of a simple "storybook lesson" that explains the subject. Each page should include 1-2 paragraphs and a detailed description of an illustration to accompany it.
 
 Illustration descriptions will be generated using an image model starring the user as a cartoon character. Use 'USER' in the image description to incorporate them in. For example: "USER is riding a jeep through the African Serengeti, pointing at a galloping herd of wildebeests." ONLY use USER in image descriptions, not in titles or page text. ONLY include the user when the image might need a stand-in for a person, many pages will not require it. Try to include USER in the first page's illustration.
 
-Your explanations should be approachable, fun, and easy to understand. Write in a simple and clear manner an adult would like to read using concepts that are simple and universal. You should cover all of the most important parts of the topic but you need to keep it short - no more than 10 pages.
+Your explanations should be {{.ToneStyle}}. The reader is {{.Audience}}. You should cover all of the most important parts of the topic but you need to keep it short - {{.PageLimit}}.
+
+Write the book title and the page text in {{.LanguageName}}, even if the lesson is in another language. Write the illustration descriptions in English.
+{{- if .Lesson.Sources}}
+
+The lesson cites its sources with numbers in square brackets, such as [2]. For each page, list the numbers of the sources that support what the page says in "sources". Do not include the numbers in the page text.
+{{- end}}
 
 === LESSON ===
 
-{{lesson}}`
+{{.Lesson.Text}}`))
+
+// lesson is a lesson plan with the sources it is grounded in.
+type lesson struct {
+	// Text is the lesson plan, with the numbers of its sources cited in
+	// square brackets.
+	Text    string
+	Sources []grounding.Source
+}
+
+// renderPrompt executes a prompt template with the lesson settings and the
+// given question or lesson.
+func renderPrompt(t *template.Template, s *lessonSettings, question string, l *lesson) (string, error) {
+	if l == nil {
+		l = &lesson{}
+	}
+	var b strings.Builder
+	err := t.Execute(&b, struct {
+		*lessonSettings
+		Question string
+		Lesson   *lesson
+	}{s, question, l})
+	return b.String(), err
+}
 
-		aggregatedJson := ""
-		storybook, _, err := genkit.GenerateData[Storybook](ctx, g,
+// generateLesson researches the question with Google Search grounding and
+// returns a lesson plan for the storybook along with the search results it
+// is based on.
+func generateLesson(ctx context.Context, g *genkit.Genkit, question string, s *lessonSettings) (*lesson, error) {
+	prompt, err := renderPrompt(lessonPrompt, s, question, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	lessonResponse, err := genkit.Generate(ctx, g,
+		ai.WithModelName(searchModel),
+		ai.WithPrompt("%s", prompt),
+		ai.WithConfig(&genai.GenerateContentConfig{
+			Temperature: genai.Ptr[float32](0.3),
+		}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate lesson: %w", err)
+	}
+	metadata, _ := lessonResponse.Custom.(*genai.GroundingMetadata)
+	sources, _ := grounding.Sources(metadata)
+	return &lesson{Text: grounding.Cite(lessonResponse.Text(), metadata), Sources: sources}, nil
+}
+
+// storybookAttempts is the number of times a storybook is generated before
+// giving up on one that does not match the requested page count or language,
+// or that is blocked by moderation.
+const storybookAttempts = 2
+
+// generateStorybook turns a lesson plan into storybook pages. If onPartial is
+// not nil, it is called with the partially generated storybook as it streams.
+// The storybook is moderated, the title and each page as soon as they are
+// complete when streaming, so that nothing is reported before it is checked;
+// rewrites are applied and the notes are recorded in its Moderation field. A
+// storybook that does not match the settings or that is blocked is generated
+// again, with the reason it was rejected added to the prompt. The lesson's
+// sources are attached to the storybook.
+func generateStorybook(ctx context.Context, g *genkit.Genkit, mod *moderation.Moderator, l *lesson, s *lessonSettings, onPartial func(*Storybook)) (*Storybook, error) {
+	prompt, err := renderPrompt(storybookPrompt, s, "", l)
+	if err != nil {
+		return nil, err
+	}
+
+	for attempt := 1; ; attempt++ {
+		opts := []ai.GenerateOption{
 			ai.WithModelName("googleai/gemini-2.5-flash"),
-			ai.WithPrompt(storybookPrompt, map[string]any{"lesson": lesson}),
-			ai.WithStreaming(func(ctx context.Context, chunk *ai.ModelResponseChunk) error {
-				if sendChunk != nil {
-					aggregatedJson += chunk.Text()
-					j, err := jsonrepair.RepairJSON(aggregatedJson)
-					if err != nil {
-						return err
-					}
-
-					s := &Storybook{}
-					json.Unmarshal([]byte(j), s)
-					s.Status = "Generating lesson storybook..."
-
-					sendChunk(ctx, s)
+			ai.WithPrompt("%s", prompt),
+		}
+		var sb *storybookStream
+		if onPartial != nil {
+			sb = &storybookStream{ctx: ctx, mod: mod, onPartial: onPartial}
+			opts = append(opts, ai.WithStreaming(streamJSON(sb.handle)))
+		}
+		storybook, _, err := genkit.GenerateData[Storybook](ctx, g, opts...)
+		var blocked *blockedError
+		if err != nil && !errors.As(err, &blocked) {
+			return nil, fmt.Errorf("failed to generate storybook: %w", err)
+		}
+
+		var problem string
+		if blocked == nil {
+			problem = s.check(storybook)
+		}
+		if blocked == nil && problem == "" {
+			notes, ok := sb.moderated(storybook)
+			if !ok {
+				var note *moderation.Note
+				if notes, note, err = moderate(ctx, mod, storybookFields(storybook)...); err != nil {
+					return nil, err
 				}
+				if note != nil {
+					blocked = &blockedError{*note}
+				}
+			}
+			if blocked == nil {
+				storybook.Moderation = notes
+				storybook.Sources = l.Sources
+				storybook.linkSources()
+				return storybook, nil
+			}
+		}
+		if blocked != nil {
+			problem = blocked.Error()
+		}
+		if attempt == storybookAttempts {
+			return nil, fmt.Errorf("generated storybook was rejected because %s", problem)
+		}
+		prompt += fmt.Sprintf("\n\n=== NOTE ===\n\nA previous attempt was rejected because %s. Make sure to fix this.", problem)
+		if onPartial != nil {
+			onPartial(&Storybook{Update: &StorybookUpdate{Type: Restarted}})
+		}
+	}
+}
+
+// streamJSON returns a streaming callback for GenerateData that parses the
+// output incrementally and passes each change to onEvent. Malformed output
+// aborts the generation with a *jsonstream.SyntaxError.
+func streamJSON(onEvent func(jsonstream.Event) error) ai.ModelStreamCallback {
+	p := jsonstream.NewParser(onEvent)
+	return func(ctx context.Context, chunk *ai.ModelResponseChunk) error {
+		_, err := p.WriteString(chunk.Text())
+		return err
+	}
+}
+
+// blockedError aborts a storybook whose field was blocked by moderation.
+type blockedError struct {
+	note moderation.Note
+}
+
+func (e *blockedError) Error() string {
+	return fmt.Sprintf("%s is not suitable for children: %s", e.note.Field, e.note.Reason)
+}
+
+// storybookStream tracks a streamed Storybook. The title and each page are
+// moderated when they are complete and only then reported, with a snapshot
+// of the moderated storybook so far. A blocked field aborts the generation
+// with a *blockedError.
+type storybookStream struct {
+	ctx context.Context
+	mod *moderation.Moderator
+	// raw is the storybook as generated and book the moderated storybook
+	// reported so far, with the notes on its fields.
+	raw       Storybook
+	book      Storybook
+	titleDone bool
+	notes     []moderation.Note
+	onPartial func(*Storybook)
+}
+
+func (s *storybookStream) handle(e jsonstream.Event) error {
+	switch {
+	case len(e.Path) == 1 && e.Path[0] == "bookTitle" && e.Kind == jsonstream.EndString:
+		s.raw.BookTitle, _ = e.Value.(string)
+		title := s.raw.BookTitle
+		if err := s.moderate(textField("bookTitle", moderation.Text, &title)); err != nil {
+			return err
+		}
+		s.book.BookTitle, s.titleDone = title, true
+		s.report(TitleExtended, 0, title)
+	case len(e.Path) >= 2 && e.Path[0] == "pages":
+		i, ok := e.Path[1].(int)
+		if !ok {
+			return nil
+		}
+		if len(e.Path) == 2 && e.Kind == jsonstream.BeginObject {
+			s.raw.Pages = append(s.raw.Pages, Page{})
+			return nil
+		}
+		if i >= len(s.raw.Pages) {
+			return nil
+		}
+		raw := &s.raw.Pages[i]
+		switch {
+		case len(e.Path) == 2 && e.Kind == jsonstream.EndObject:
+			p := *raw
+			if err := s.moderate(pageFields(i, &p)...); err != nil {
+				return err
+			}
+			s.book.Pages = append(s.book.Pages, p)
+			s.report(PageComplete, i, "")
+		case len(e.Path) == 3 && e.Kind == jsonstream.EndString && e.Path[2] == "text":
+			raw.Text, _ = e.Value.(string)
+		case len(e.Path) == 3 && e.Kind == jsonstream.EndString && e.Path[2] == "illustration":
+			raw.Illustration, _ = e.Value.(string)
+		case len(e.Path) == 4 && e.Kind == jsonstream.Scalar && e.Path[2] == "sources":
+			n, ok := e.Value.(json.Number)
+			if !ok {
 				return nil
-			}),
-		)
-		if err != nil {
-			return nil, fmt.Errorf("failed to generate storybook: %w", err)
+			}
+			if v, err := n.Int64(); err == nil {
+				raw.Sources = append(raw.Sources, int(v))
+			}
 		}
+	}
+	return nil
+}
 
-		return storybook, nil
-	})
+// moderate checks fields of the streamed storybook and keeps the notes on
+// them.
+func (s *storybookStream) moderate(fields ...moderated) error {
+	notes, blocked, err := moderate(s.ctx, s.mod, fields...)
+	if err != nil {
+		return err
+	}
+	if blocked != nil {
+		return &blockedError{*blocked}
+	}
+	s.notes = append(s.notes, notes...)
+	return nil
+}
+
+// moderated applies the moderation of the stream to b, the storybook it
+// streamed, and returns the notes. It returns false if s is nil or did not
+// moderate all of b, which must then be moderated again.
+func (s *storybookStream) moderated(b *Storybook) ([]moderation.Note, bool) {
+	if s == nil || !s.titleDone || s.raw.BookTitle != b.BookTitle || len(s.book.Pages) != len(b.Pages) {
+		return nil, false
+	}
+	for i, p := range b.Pages {
+		if raw := s.raw.Pages[i]; raw.Text != p.Text || raw.Illustration != p.Illustration {
+			return nil, false
+		}
+	}
+	b.BookTitle = s.book.BookTitle
+	for i, p := range s.book.Pages {
+		b.Pages[i].Text, b.Pages[i].Illustration = p.Text, p.Illustration
+	}
+	return s.notes, true
+}
+
+// linkSources removes the source numbers of pages that do not refer to one
+// of the storybook's sources.
+func (b *Storybook) linkSources() {
+	for i := range b.Pages {
+		var refs []int
+		for _, n := range b.Pages[i].Sources {
+			if n >= 1 && n <= len(b.Sources) && !slices.Contains(refs, n) {
+				refs = append(refs, n)
+			}
+		}
+		b.Pages[i].Sources = refs
+	}
+}
+
+func (s *storybookStream) report(t StorybookUpdateType, page int, text string) {
+	snapshot := s.book
+	snapshot.Pages = append([]Page(nil), s.book.Pages...)
+	snapshot.Update = &StorybookUpdate{Type: t, Page: page, Text: text}
+	s.onPartial(&snapshot)
 }
@@ -0,0 +1,92 @@
+package flows
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"eli5/jsonstream"
+	"eli5/moderation"
+)
+
+// streamStorybook feeds doc to a storybookStream one byte at a time and
+// returns the stream, the snapshots it reported and the error that stopped
+// it, if any.
+func streamStorybook(t *testing.T, doc string) (*storybookStream, []*Storybook, error) {
+	t.Helper()
+	mod, err := moderation.New(&moderation.Policy{Rules: []moderation.Rule{
+		{Name: "mild", Keywords: []string{"darn"}, Action: moderation.Rewrite, Replacement: "gosh"},
+		{Name: "weapons", Keywords: []string{"bomb"}, Action: moderation.Block, Reason: "no weapons"},
+	}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var snapshots []*Storybook
+	s := &storybookStream{ctx: context.Background(), mod: mod, onPartial: func(b *Storybook) {
+		snapshots = append(snapshots, b)
+	}}
+	p := jsonstream.NewParser(s.handle)
+	for i := range len(doc) {
+		if _, err := p.WriteString(doc[i : i+1]); err != nil {
+			return s, snapshots, err
+		}
+	}
+	return s, snapshots, p.Close()
+}
+
+func TestStorybookStream(t *testing.T) {
+	doc := `{"bookTitle": "Darn Sun", "pages": [{"text": "Hot.", "illustration": "A sun", "sources": [1, 2]}, {"text": "Gas, darn it.", "sources": [2]}]}`
+	s, snapshots, err := streamStorybook(t, doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var updates []StorybookUpdateType
+	for _, b := range snapshots {
+		updates = append(updates, b.Update.Type)
+	}
+	if want := []StorybookUpdateType{TitleExtended, PageComplete, PageComplete}; !reflect.DeepEqual(updates, want) {
+		t.Errorf("updates = %v, want %v", updates, want)
+	}
+	want := []Page{
+		{Text: "Hot.", Illustration: "A sun", Sources: []int{1, 2}},
+		{Text: "Gas, gosh it.", Sources: []int{2}},
+	}
+	last := snapshots[len(snapshots)-1]
+	if last.BookTitle != "gosh Sun" || !reflect.DeepEqual(last.Pages, want) {
+		t.Errorf("last snapshot = %q %+v, want %q %+v", last.BookTitle, last.Pages, "gosh Sun", want)
+	}
+
+	// The finished storybook gets the moderation of the stream.
+	book := &Storybook{BookTitle: "Darn Sun", Pages: []Page{
+		{Text: "Hot.", Illustration: "A sun", Sources: []int{1, 2}},
+		{Text: "Gas, darn it.", Sources: []int{2}},
+	}}
+	notes, ok := s.moderated(book)
+	if !ok {
+		t.Fatal("moderated() = false for the streamed storybook")
+	}
+	if book.BookTitle != "gosh Sun" || !reflect.DeepEqual(book.Pages, want) || len(notes) != 2 {
+		t.Errorf("moderated storybook = %q %+v with notes %v", book.BookTitle, book.Pages, notes)
+	}
+	if _, ok := s.moderated(&Storybook{BookTitle: "Darn Sun", Pages: book.Pages[:1]}); ok {
+		t.Error("moderated() = true for a storybook with pages the stream did not see")
+	}
+}
+
+func TestStorybookStreamBlocked(t *testing.T) {
+	doc := `{"bookTitle": "Sun", "pages": [{"text": "Hot."}, {"text": "Build a bomb."}, {"text": "Gas."}]}`
+	_, snapshots, err := streamStorybook(t, doc)
+	var blocked *blockedError
+	if !errors.As(err, &blocked) || blocked.note.Field != "pages[1].text" {
+		t.Fatalf("error = %v, want pages[1].text blocked", err)
+	}
+	for _, b := range snapshots {
+		for _, p := range b.Pages {
+			if p.Text != "Hot." {
+				t.Errorf("page %q was reported before it was moderated", p.Text)
+			}
+		}
+	}
+}
@@ -3,8 +3,10 @@ module eli5
 go 1.24.5
 
 require (
-	github.com/RealAlexandreAI/json-repair v0.0.14
+	flow-transports/go v0.0.0
 	github.com/firebase/genkit/go v1.0.5
+	golang.org/x/image v0.30.0
+	golang.org/x/text v0.28.0
 	google.golang.org/genai v1.24.0
 )
 
@@ -42,9 +44,10 @@ require (
 	golang.org/x/crypto v0.40.0 // indirect
 	golang.org/x/net v0.41.0 // indirect
 	golang.org/x/sys v0.34.0 // indirect
-	golang.org/x/text v0.27.0 // indirect
 	google.golang.org/genproto/googleapis/rpc v0.0.0-20250603155806-513f23925822 // indirect
 	google.golang.org/grpc v1.73.0 // indirect
 	google.golang.org/protobuf v1.36.6 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
+
+replace flow-transports/go => ../flow-transports/go
@@ -0,0 +1,120 @@
+// Package grounding turns the Google Search grounding metadata of a Gemini
+// response into a list of sources that readers can check, and cites them in
+// the grounded text.
+package grounding
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"google.golang.org/genai"
+)
+
+// Source is a web page that a grounded response is based on.
+type Source struct {
+	Title string `json:"title"`
+	URL   string `json:"url"`
+	// Domain is the site the page belongs to. The URL may be a redirect.
+	Domain string `json:"domain,omitempty"`
+	// Snippets are the passages of the response that the page supports.
+	Snippets []string `json:"snippets,omitempty"`
+}
+
+// maxSnippets is the number of snippets kept per source.
+const maxSnippets = 3
+
+// Sources returns the web sources in m, without duplicates, along with
+// chunks, which maps each grounding chunk index of m to the index of its
+// source, or -1 if it is not a web source.
+func Sources(m *genai.GroundingMetadata) (sources []Source, chunks []int) {
+	if m == nil {
+		return nil, nil
+	}
+	byURL := map[string]int{}
+	chunks = make([]int, len(m.GroundingChunks))
+	for i, c := range m.GroundingChunks {
+		chunks[i] = -1
+		if c == nil || c.Web == nil || c.Web.URI == "" {
+			continue
+		}
+		n, ok := byURL[c.Web.URI]
+		if !ok {
+			n = len(sources)
+			byURL[c.Web.URI] = n
+			title := c.Web.Title
+			if title == "" {
+				title = c.Web.Domain
+			}
+			sources = append(sources, Source{Title: title, URL: c.Web.URI, Domain: c.Web.Domain})
+		}
+		chunks[i] = n
+	}
+	for _, s := range m.GroundingSupports {
+		if s == nil || s.Segment == nil {
+			continue
+		}
+		snippet := strings.TrimSpace(s.Segment.Text)
+		if snippet == "" {
+			continue
+		}
+		for _, ci := range s.GroundingChunkIndices {
+			if ci < 0 || int(ci) >= len(chunks) || chunks[ci] < 0 {
+				continue
+			}
+			src := &sources[chunks[ci]]
+			if len(src.Snippets) < maxSnippets && !slices.Contains(src.Snippets, snippet) {
+				src.Snippets = append(src.Snippets, snippet)
+			}
+		}
+	}
+	return sources, chunks
+}
+
+// Cite returns text with the numbers of the sources that support each
+// passage, such as "[1][3]", inserted after the passage. Sources are numbered
+// from 1 in the order returned by Sources. Passages that cannot be found in
+// text are skipped.
+func Cite(text string, m *genai.GroundingMetadata) string {
+	_, chunks := Sources(m)
+	if len(chunks) == 0 {
+		return text
+	}
+	type citation struct {
+		at      int
+		sources []int
+	}
+	var cites []citation
+	for _, s := range m.GroundingSupports {
+		if s == nil || s.Segment == nil || strings.TrimSpace(s.Segment.Text) == "" {
+			continue
+		}
+		// The segment offsets are relative to the part the segment is in,
+		// so search for the text instead.
+		i := strings.Index(text, s.Segment.Text)
+		if i < 0 {
+			continue
+		}
+		c := citation{at: i + len(s.Segment.Text)}
+		for _, ci := range s.GroundingChunkIndices {
+			if ci >= 0 && int(ci) < len(chunks) && chunks[ci] >= 0 && !slices.Contains(c.sources, chunks[ci]) {
+				c.sources = append(c.sources, chunks[ci])
+			}
+		}
+		if len(c.sources) > 0 {
+			sort.Ints(c.sources)
+			cites = append(cites, c)
+		}
+	}
+	// Insert from the end so that earlier offsets stay valid.
+	sort.SliceStable(cites, func(i, j int) bool { return cites[i].at > cites[j].at })
+	for _, c := range cites {
+		var b strings.Builder
+		for _, n := range c.sources {
+			fmt.Fprintf(&b, "[%d]", n+1)
+		}
+		text = text[:c.at] + b.String() + text[c.at:]
+	}
+	return text
+}
@@ -0,0 +1,144 @@
+// Package jobs runs long-lived background work that outlives the HTTP request
+// that started it, and lets any number of clients follow its progress.
+package jobs
+
+import (
+	"context"
+	"sync"
+
+	"flow-transports/go/transport"
+)
+
+// Manager tracks the jobs that are currently running, keyed by ID.
+type Manager[T any] struct {
+	mu      sync.Mutex
+	running map[string]*Job[T]
+}
+
+func NewManager[T any]() *Manager[T] {
+	return &Manager[T]{running: make(map[string]*Job[T])}
+}
+
+// Job is a running unit of work that publishes snapshots of its state.
+type Job[T any] struct {
+	ID string
+
+	mu     sync.Mutex
+	latest T
+	subs   map[chan T]struct{}
+	done   chan struct{}
+	err    error
+}
+
+// Start runs fn in the background as the job with the given ID. The job runs
+// under ctx with its cancellation removed, so it keeps going after the
+// request that started it ends, and is registered as the transport run with
+// its ID, so that POST /runs/{id}/cancel cancels it. If a job with the ID is
+// already running, it is returned instead and started is false.
+func (m *Manager[T]) Start(ctx context.Context, id string, initial T, fn func(ctx context.Context, j *Job[T]) error) (j *Job[T], started bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if j, ok := m.running[id]; ok {
+		return j, false
+	}
+	j = &Job[T]{
+		ID:     id,
+		latest: initial,
+		subs:   make(map[chan T]struct{}),
+		done:   make(chan struct{}),
+	}
+	m.running[id] = j
+
+	ctx, done := transport.StartRun(context.WithoutCancel(ctx), id)
+	go func() {
+		err := fn(ctx, j)
+		done()
+
+		m.mu.Lock()
+		delete(m.running, id)
+		m.mu.Unlock()
+
+		j.mu.Lock()
+		j.err = err
+		for ch := range j.subs {
+			close(ch)
+		}
+		j.subs = nil
+		j.mu.Unlock()
+		close(j.done)
+	}()
+	return j, true
+}
+
+// Get returns the running job with the given ID.
+func (m *Manager[T]) Get(id string) (*Job[T], bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, ok := m.running[id]
+	return j, ok
+}
+
+// Publish records v as the job's latest state and sends it to every subscriber.
+// Slow subscribers miss intermediate states rather than blocking the job.
+func (j *Job[T]) Publish(v T) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.latest = v
+	for ch := range j.subs {
+		select {
+		case ch <- v:
+		default:
+			// Replace the stale value the subscriber has not read yet.
+			select {
+			case <-ch:
+			default:
+			}
+			ch <- v
+		}
+	}
+}
+
+// Subscribe returns a channel that first receives the job's latest state and
+// then every state published after it. The channel is closed when the job
+// finishes. Call the returned function to unsubscribe early.
+func (j *Job[T]) Subscribe() (<-chan T, func()) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	ch := make(chan T, 1)
+	ch <- j.latest
+	if j.subs == nil {
+		close(ch)
+		return ch, func() {}
+	}
+	j.subs[ch] = struct{}{}
+	return ch, func() {
+		j.mu.Lock()
+		defer j.mu.Unlock()
+		if _, ok := j.subs[ch]; ok {
+			delete(j.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// Latest returns the most recently published state.
+func (j *Job[T]) Latest() T {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.latest
+}
+
+// Done is closed when the job finishes.
+func (j *Job[T]) Done() <-chan struct{} {
+	return j.done
+}
+
+// Err returns the error the job finished with. It is only meaningful after Done is closed.
+func (j *Job[T]) Err() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.err
+}
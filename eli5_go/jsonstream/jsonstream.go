@@ -0,0 +1,469 @@
+// Package jsonstream parses a JSON document incrementally as it is streamed,
+// for example from a model generating structured output, and reports what
+// was added by each chunk as a sequence of events.
+//
+// Events are monotonic: a value is only ever extended, never replaced, so a
+// consumer can apply them to build a partial value that never regresses.
+package jsonstream
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// Kind is the type of an Event.
+type Kind int
+
+const (
+	// BeginObject starts an object value.
+	BeginObject Kind = iota
+	// EndObject completes an object value.
+	EndObject
+	// BeginArray starts an array value.
+	BeginArray
+	// EndArray completes an array value.
+	EndArray
+	// StringDelta extends a string value; Text holds the new characters.
+	StringDelta
+	// EndString completes a string value; Value holds the whole string.
+	EndString
+	// Scalar is a complete number, boolean or null; Value holds it as a
+	// json.Number, bool or nil.
+	Scalar
+)
+
+var kindNames = [...]string{"BeginObject", "EndObject", "BeginArray", "EndArray", "StringDelta", "EndString", "Scalar"}
+
+func (k Kind) String() string {
+	if int(k) < len(kindNames) {
+		return kindNames[k]
+	}
+	return fmt.Sprintf("Kind(%d)", int(k))
+}
+
+// Path locates a value within the document. Each element is an object key
+// (string) or an array index (int). The root value has an empty path.
+type Path []any
+
+// String returns the path in the form pages[2].text.
+func (p Path) String() string {
+	var b strings.Builder
+	for _, part := range p {
+		switch v := part.(type) {
+		case int:
+			fmt.Fprintf(&b, "[%d]", v)
+		case string:
+			if b.Len() > 0 {
+				b.WriteByte('.')
+			}
+			b.WriteString(v)
+		}
+	}
+	return b.String()
+}
+
+// Event reports a change to the value at Path.
+type Event struct {
+	Kind  Kind
+	Path  Path
+	Text  string
+	Value any
+}
+
+// SyntaxError is returned when the streamed data is not valid JSON.
+type SyntaxError struct {
+	Offset int64 // byte offset of the error
+	Msg    string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("invalid JSON at offset %d: %s", e.Offset, e.Msg)
+}
+
+type state int
+
+const (
+	stateBegin       state = iota // before the root value
+	stateValue                    // expecting a value
+	stateArrayStart               // after '[': expecting a value or ']'
+	stateObjectStart              // after '{': expecting a key or '}'
+	stateKey                      // after ',' in an object: expecting a key
+	stateColon                    // after a key: expecting ':'
+	stateAfter                    // after a value in a container: expecting ',' or a close
+	stateString                   // inside a string value
+	stateKeyString                // inside an object key
+	stateLiteral                  // inside a number, true, false or null
+	stateDone                     // after the root value
+)
+
+type frame struct {
+	array bool
+	index int    // index of the current element of an array
+	key   string // key of the current member of an object
+}
+
+// Parser is an incremental JSON parser. Write streamed data to it as it
+// arrives; it calls the event handler for every change the data makes to the
+// document. Text before the root value, such as a Markdown code fence, and
+// anything after it is ignored.
+type Parser struct {
+	emit   func(Event) error
+	state  state
+	stack  []frame
+	offset int64
+	err    error
+
+	full    strings.Builder // the current string or key so far
+	pending []byte          // string bytes not yet emitted as a delta
+	escape  []byte          // the escape sequence being read, starting after '\'
+	inEsc   bool
+	high    rune // a UTF-16 high surrogate waiting for its pair
+	literal []byte
+}
+
+// NewParser returns a Parser that calls emit for every event. If emit returns
+// an error, parsing stops and Write returns the error.
+func NewParser(emit func(Event) error) *Parser {
+	return &Parser{emit: emit}
+}
+
+// Write parses the next chunk of the document.
+func (p *Parser) Write(data []byte) (int, error) {
+	if p.err != nil {
+		return 0, p.err
+	}
+	for i, c := range data {
+		if err := p.step(c); err != nil {
+			p.err = err
+			return i, err
+		}
+		p.offset++
+	}
+	// Report string content as soon as it arrives rather than when the string ends.
+	if p.state == stateString {
+		if err := p.flushString(false); err != nil {
+			p.err = err
+			return len(data), err
+		}
+	}
+	return len(data), nil
+}
+
+// WriteString is like Write but takes a string.
+func (p *Parser) WriteString(s string) (int, error) {
+	return p.Write([]byte(s))
+}
+
+// Close reports an error if the document is incomplete.
+func (p *Parser) Close() error {
+	if p.err != nil {
+		return p.err
+	}
+	if p.state == stateLiteral && len(p.stack) == 0 {
+		if err := p.endLiteral(); err != nil {
+			p.err = err
+			return err
+		}
+	}
+	if p.state != stateDone {
+		p.err = p.syntaxError("unexpected end of JSON input")
+	}
+	return p.err
+}
+
+// Done reports whether the root value is complete.
+func (p *Parser) Done() bool {
+	return p.state == stateDone
+}
+
+func (p *Parser) step(c byte) error {
+	switch p.state {
+	case stateDone:
+		return nil
+	case stateString, stateKeyString:
+		return p.stringByte(c)
+	case stateLiteral:
+		if isLiteralByte(c) {
+			p.literal = append(p.literal, c)
+			return nil
+		}
+		if err := p.endLiteral(); err != nil {
+			return err
+		}
+		// c belongs to whatever follows the literal.
+		return p.step(c)
+	}
+
+	if c == ' ' || c == '\t' || c == '\n' || c == '\r' {
+		return nil
+	}
+	switch p.state {
+	case stateBegin:
+		if c == '{' || c == '[' {
+			return p.beginValue(c)
+		}
+		return nil
+	case stateValue:
+		return p.beginValue(c)
+	case stateArrayStart:
+		if c == ']' {
+			return p.endContainer(true)
+		}
+		return p.beginValue(c)
+	case stateObjectStart, stateKey:
+		if c == '"' {
+			p.full.Reset()
+			p.state = stateKeyString
+			return nil
+		}
+		if c == '}' && p.state == stateObjectStart {
+			return p.endContainer(false)
+		}
+		return p.unexpected(c, "object key")
+	case stateColon:
+		if c == ':' {
+			p.state = stateValue
+			return nil
+		}
+		return p.unexpected(c, "':' after object key")
+	case stateAfter:
+		top := &p.stack[len(p.stack)-1]
+		switch {
+		case c == ',' && top.array:
+			top.index++
+			p.state = stateValue
+			return nil
+		case c == ',':
+			p.state = stateKey
+			return nil
+		case c == ']' && top.array, c == '}' && !top.array:
+			return p.endContainer(top.array)
+		}
+		return p.unexpected(c, "',' or end of container")
+	}
+	return nil
+}
+
+func (p *Parser) beginValue(c byte) error {
+	switch {
+	case c == '{':
+		if err := p.emit(Event{Kind: BeginObject, Path: p.path()}); err != nil {
+			return err
+		}
+		p.stack = append(p.stack, frame{})
+		p.state = stateObjectStart
+	case c == '[':
+		if err := p.emit(Event{Kind: BeginArray, Path: p.path()}); err != nil {
+			return err
+		}
+		p.stack = append(p.stack, frame{array: true})
+		p.state = stateArrayStart
+	case c == '"':
+		p.full.Reset()
+		p.pending = p.pending[:0]
+		p.state = stateString
+	case c == '-' || c >= '0' && c <= '9' || c == 't' || c == 'f' || c == 'n':
+		p.literal = append(p.literal[:0], c)
+		p.state = stateLiteral
+	default:
+		return p.unexpected(c, "value")
+	}
+	return nil
+}
+
+func (p *Parser) endContainer(array bool) error {
+	p.stack = p.stack[:len(p.stack)-1]
+	kind := EndObject
+	if array {
+		kind = EndArray
+	}
+	if err := p.emit(Event{Kind: kind, Path: p.path()}); err != nil {
+		return err
+	}
+	p.endValue()
+	return nil
+}
+
+func (p *Parser) endValue() {
+	if len(p.stack) == 0 {
+		p.state = stateDone
+	} else {
+		p.state = stateAfter
+	}
+}
+
+func (p *Parser) endLiteral() error {
+	s := string(p.literal)
+	var v any
+	switch s {
+	case "true":
+		v = true
+	case "false":
+		v = false
+	case "null":
+		v = nil
+	default:
+		if !json.Valid(p.literal) {
+			return p.syntaxError(fmt.Sprintf("invalid literal %q", s))
+		}
+		v = json.Number(s)
+	}
+	if err := p.emit(Event{Kind: Scalar, Path: p.path(), Value: v}); err != nil {
+		return err
+	}
+	p.endValue()
+	return nil
+}
+
+func (p *Parser) stringByte(c byte) error {
+	if p.inEsc {
+		return p.escapeByte(c)
+	}
+	switch {
+	case c == '\\':
+		p.inEsc = true
+		p.escape = p.escape[:0]
+		return nil
+	case c == '"':
+		p.flushSurrogate()
+		if p.state == stateKeyString {
+			p.stack[len(p.stack)-1].key = p.full.String()
+			p.state = stateColon
+			return nil
+		}
+		if err := p.flushString(true); err != nil {
+			return err
+		}
+		if err := p.emit(Event{Kind: EndString, Path: p.path(), Value: p.full.String()}); err != nil {
+			return err
+		}
+		p.endValue()
+		return nil
+	case c < 0x20:
+		return p.syntaxError("control character in string")
+	}
+	p.flushSurrogate()
+	p.appendBytes(c)
+	return nil
+}
+
+func (p *Parser) escapeByte(c byte) error {
+	if len(p.escape) == 0 {
+		var r byte
+		switch c {
+		case '"', '\\', '/':
+			r = c
+		case 'b':
+			r = '\b'
+		case 'f':
+			r = '\f'
+		case 'n':
+			r = '\n'
+		case 'r':
+			r = '\r'
+		case 't':
+			r = '\t'
+		case 'u':
+			p.escape = append(p.escape, c)
+			return nil
+		default:
+			return p.syntaxError(fmt.Sprintf("invalid escape %q", "\\"+string(c)))
+		}
+		p.inEsc = false
+		p.flushSurrogate()
+		p.appendBytes(r)
+		return nil
+	}
+
+	p.escape = append(p.escape, c)
+	if len(p.escape) < 5 {
+		return nil
+	}
+	p.inEsc = false
+	n, err := strconv.ParseUint(string(p.escape[1:]), 16, 16)
+	if err != nil {
+		return p.syntaxError(fmt.Sprintf("invalid escape %q", "\\"+string(p.escape)))
+	}
+	r := rune(n)
+	switch {
+	case p.high != 0 && r >= 0xDC00 && r <= 0xDFFF:
+		r = utf16.DecodeRune(p.high, r)
+		p.high = 0
+	case r >= 0xD800 && r <= 0xDBFF:
+		p.flushSurrogate()
+		p.high = r
+		return nil
+	default:
+		p.flushSurrogate()
+	}
+	p.appendBytes([]byte(string(r))...)
+	return nil
+}
+
+// flushSurrogate writes a high surrogate that was not followed by its pair
+// as the replacement character.
+func (p *Parser) flushSurrogate() {
+	if p.high != 0 {
+		p.high = 0
+		p.appendBytes([]byte(string(utf8.RuneError))...)
+	}
+}
+
+func (p *Parser) appendBytes(b ...byte) {
+	p.full.Write(b)
+	if p.state == stateString {
+		p.pending = append(p.pending, b...)
+	}
+}
+
+// flushString emits the pending characters of the current string value. An
+// incomplete UTF-8 sequence at the end is held back unless final is set.
+func (p *Parser) flushString(final bool) error {
+	n := len(p.pending)
+	if !final {
+		// Back up over a trailing partial rune.
+		for i := n - 1; i >= 0 && i >= n-utf8.UTFMax; i-- {
+			if utf8.RuneStart(p.pending[i]) {
+				if !utf8.FullRune(p.pending[i:]) {
+					n = i
+				}
+				break
+			}
+		}
+	}
+	if n == 0 {
+		return nil
+	}
+	text := string(p.pending[:n])
+	p.pending = append(p.pending[:0], p.pending[n:]...)
+	return p.emit(Event{Kind: StringDelta, Path: p.path(), Text: text})
+}
+
+// path returns the path of the current value.
+func (p *Parser) path() Path {
+	path := make(Path, len(p.stack))
+	for i, f := range p.stack {
+		if f.array {
+			path[i] = f.index
+		} else {
+			path[i] = f.key
+		}
+	}
+	return path
+}
+
+func (p *Parser) unexpected(c byte, want string) error {
+	return p.syntaxError(fmt.Sprintf("unexpected %q, expecting %s", c, want))
+}
+
+func (p *Parser) syntaxError(msg string) error {
+	return &SyntaxError{Offset: p.offset, Msg: msg}
+}
+
+func isLiteralByte(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c == '.' || c == '-' || c == '+' || c == 'E'
+}
@@ -0,0 +1,264 @@
+package jsonstream
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"unicode/utf8"
+)
+
+// parse feeds chunks to a new Parser and returns the events it emitted, with
+// consecutive string deltas merged so that the result does not depend on
+// where the input was split.
+func parse(t *testing.T, chunks ...string) ([]Event, error) {
+	t.Helper()
+	var events []Event
+	p := NewParser(func(e Event) error {
+		if n := len(events); n > 0 && e.Kind == StringDelta && events[n-1].Kind == StringDelta &&
+			reflect.DeepEqual(events[n-1].Path, e.Path) {
+			events[n-1].Text += e.Text
+			return nil
+		}
+		events = append(events, e)
+		return nil
+	})
+	for _, c := range chunks {
+		if _, err := p.WriteString(c); err != nil {
+			return events, err
+		}
+	}
+	return events, p.Close()
+}
+
+func TestEvents(t *testing.T) {
+	events, err := parse(t, `{"title": "Hi", "pages": [{"n": 1}, true, null], "e": {}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Event{
+		{Kind: BeginObject, Path: Path{}},
+		{Kind: StringDelta, Path: Path{"title"}, Text: "Hi"},
+		{Kind: EndString, Path: Path{"title"}, Value: "Hi"},
+		{Kind: BeginArray, Path: Path{"pages"}},
+		{Kind: BeginObject, Path: Path{"pages", 0}},
+		{Kind: Scalar, Path: Path{"pages", 0, "n"}, Value: json.Number("1")},
+		{Kind: EndObject, Path: Path{"pages", 0}},
+		{Kind: Scalar, Path: Path{"pages", 1}, Value: true},
+		{Kind: Scalar, Path: Path{"pages", 2}, Value: nil},
+		{Kind: EndArray, Path: Path{"pages"}},
+		{Kind: BeginObject, Path: Path{"e"}},
+		{Kind: EndObject, Path: Path{"e"}},
+		{Kind: EndObject, Path: Path{}},
+	}
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("events =\n%v\nwant\n%v", events, want)
+	}
+}
+
+func TestSplitAtEveryByte(t *testing.T) {
+	docs := []string{
+		`{"bookTitle": "The Sun", "pages": [{"text": "It is hot.", "sources": [1, 2]}, {"text": "Très chaud ☀️"}]}`,
+		`[-1.5e3, 0, true, false, null, "", {"a": []}]`,
+		`{"emoji": "😀 and é\n\t\"quoted\" \\ \/"}`,
+	}
+	for _, doc := range docs {
+		whole, err := parse(t, doc)
+		if err != nil {
+			t.Fatalf("%s: %v", doc, err)
+		}
+		for i := 1; i < len(doc); i++ {
+			split, err := parse(t, doc[:i], doc[i:])
+			if err != nil {
+				t.Fatalf("%s split at %d: %v", doc, i, err)
+			}
+			if !reflect.DeepEqual(split, whole) {
+				t.Fatalf("%s split at %d: events =\n%v\nwant\n%v", doc, i, split, whole)
+			}
+		}
+		// One byte at a time.
+		var chunks []string
+		for i := range len(doc) {
+			chunks = append(chunks, doc[i:i+1])
+		}
+		bytewise, err := parse(t, chunks...)
+		if err != nil {
+			t.Fatalf("%s byte by byte: %v", doc, err)
+		}
+		if !reflect.DeepEqual(bytewise, whole) {
+			t.Fatalf("%s byte by byte: events =\n%v\nwant\n%v", doc, bytewise, whole)
+		}
+	}
+}
+
+func TestDeltasAreValidUTF8(t *testing.T) {
+	doc := `{"s": "é☀️😀"}`
+	p := NewParser(func(e Event) error {
+		if e.Kind == StringDelta && !utf8.ValidString(e.Text) {
+			t.Errorf("delta %q is not valid UTF-8", e.Text)
+		}
+		return nil
+	})
+	for i := range len(doc) {
+		if _, err := p.WriteString(doc[i : i+1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEscapes(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{`"a\"b\\c\/d"`, `a"b\c/d`},
+		{`"\b\f\n\r\t"`, "\b\f\n\r\t"},
+		{`"\u0041\u00e9\u2600"`, "Aé☀"},
+		{`"\ud83d\ude00"`, "😀"},
+		{`"\ud83dx"`, "�x"},
+		{`"\ud83dA"`, "�A"},
+		{`"\ud83d"`, "�"},
+	}
+	for _, tt := range tests {
+		doc := `{"s": ` + tt.in + `}`
+		// Split at every byte, which covers a surrogate pair split across chunks.
+		for i := 1; i < len(doc); i++ {
+			events, err := parse(t, doc[:i], doc[i:])
+			if err != nil {
+				t.Fatalf("%s split at %d: %v", tt.in, i, err)
+			}
+			if got := events[2].Value; events[2].Kind != EndString || got != tt.want {
+				t.Fatalf("%s split at %d: string = %q, want %q", tt.in, i, got, tt.want)
+			}
+			if got := events[1].Text; got != tt.want {
+				t.Fatalf("%s split at %d: deltas = %q, want %q", tt.in, i, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestCodeFence(t *testing.T) {
+	events, err := parse(t, "Here you go:\n```json\n", `{"a": "b"}`, "\n```\nEnjoy!")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Event{
+		{Kind: BeginObject, Path: Path{}},
+		{Kind: StringDelta, Path: Path{"a"}, Text: "b"},
+		{Kind: EndString, Path: Path{"a"}, Value: "b"},
+		{Kind: EndObject, Path: Path{}},
+	}
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("events =\n%v\nwant\n%v", events, want)
+	}
+}
+
+func TestMalformed(t *testing.T) {
+	tests := []struct {
+		in     string
+		offset int64
+		msg    string
+	}{
+		{`{"a" 1}`, 5, `unexpected '1', expecting ':' after object key`},
+		{`{"a": 1 "b": 2}`, 8, `unexpected '"', expecting ',' or end of container`},
+		{`{1: 2}`, 1, `unexpected '1', expecting object key`},
+		{`[1,]`, 3, `unexpected ']', expecting value`},
+		{`[1}`, 2, `unexpected '}', expecting ',' or end of container`},
+		{`{"a": tru}`, 9, `invalid literal "tru"`},
+		{`{"a": 01}`, 8, `invalid literal "01"`},
+		{`{"a": "\x"}`, 8, `invalid escape "\\x"`},
+		{`{"a": "\u12g4"}`, 12, `invalid escape "\\u12g4"`},
+		{"{\"a\": \"\n\"}", 7, `control character in string`},
+		{`{"a": [1, 2`, 11, `unexpected end of JSON input`},
+		{`no JSON here`, 12, `unexpected end of JSON input`},
+	}
+	for _, tt := range tests {
+		_, err := parse(t, tt.in)
+		var syntaxErr *SyntaxError
+		if !errors.As(err, &syntaxErr) {
+			t.Errorf("%s: error = %v, want a *SyntaxError", tt.in, err)
+			continue
+		}
+		if syntaxErr.Offset != tt.offset || syntaxErr.Msg != tt.msg {
+			t.Errorf("%s: error = %v, want %q at offset %d", tt.in, err, tt.msg, tt.offset)
+		}
+	}
+}
+
+func TestErrorsAreSticky(t *testing.T) {
+	p := NewParser(func(Event) error { return nil })
+	n, err := p.WriteString(`[1 2]`)
+	if err == nil || n != 3 {
+		t.Fatalf("Write() = %d, %v, want 3 and a syntax error", n, err)
+	}
+	if _, err2 := p.WriteString(`]`); err2 != err {
+		t.Errorf("Write after an error = %v, want %v", err2, err)
+	}
+	if err2 := p.Close(); err2 != err {
+		t.Errorf("Close after an error = %v, want %v", err2, err)
+	}
+}
+
+func TestEmitError(t *testing.T) {
+	stop := errors.New("stop")
+	var events int
+	p := NewParser(func(e Event) error {
+		events++
+		if e.Kind == StringDelta {
+			return stop
+		}
+		return nil
+	})
+	if _, err := p.WriteString(`{"a": "partial`); err != stop {
+		t.Fatalf("Write() = %v, want the handler's error", err)
+	}
+	if _, err := p.WriteString(` text"}`); err != stop {
+		t.Errorf("Write after a handler error = %v, want the handler's error", err)
+	}
+	if events != 2 {
+		t.Errorf("handler called %d times, want 2", events)
+	}
+}
+
+func TestDone(t *testing.T) {
+	p := NewParser(func(Event) error { return nil })
+	p.WriteString(`{"a": [`)
+	if p.Done() {
+		t.Error("Done() = true for an incomplete document")
+	}
+	p.WriteString(`]} trailing`)
+	if !p.Done() {
+		t.Error("Done() = false after the root value")
+	}
+	if err := p.Close(); err != nil {
+		t.Errorf("Close() = %v", err)
+	}
+}
+
+func TestPathString(t *testing.T) {
+	for _, tt := range []struct {
+		path Path
+		want string
+	}{
+		{Path{}, ""},
+		{Path{"pages", 2, "text"}, "pages[2].text"},
+		{Path{0, "a"}, "[0].a"},
+	} {
+		if got := tt.path.String(); got != tt.want {
+			t.Errorf("%v.String() = %q, want %q", []any(tt.path), got, tt.want)
+		}
+	}
+}
+
+func TestKindString(t *testing.T) {
+	if got := fmt.Sprint(StringDelta); got != "StringDelta" {
+		t.Errorf("StringDelta prints as %q", got)
+	}
+	if got := fmt.Sprint(Kind(42)); got != "Kind(42)" {
+		t.Errorf("Kind(42) prints as %q", got)
+	}
+}
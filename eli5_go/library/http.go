@@ -0,0 +1,89 @@
+package library
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// RequireToken returns next guarded by a bearer token, for the endpoints that
+// list every saved book or delete one. Without a token, next is returned
+// unchanged and the endpoints are open to anyone who can reach the server.
+func RequireToken(token string, next http.HandlerFunc) http.HandlerFunc {
+	if token == "" {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "invalid or missing library token", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// HandleList serves GET /api/books.
+func (s *Store) HandleList(w http.ResponseWriter, r *http.Request) {
+	books, err := s.List()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, books)
+}
+
+// HandleGet serves GET /api/books/{id}.
+func (s *Store) HandleGet(w http.ResponseWriter, r *http.Request) {
+	b, err := s.Get(r.PathValue("id"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, b)
+}
+
+// HandleDelete serves DELETE /api/books/{id}.
+func (s *Store) HandleDelete(w http.ResponseWriter, r *http.Request) {
+	if err := s.Delete(r.PathValue("id")); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleImage serves GET /api/books/{id}/images/{file}.
+func (s *Store) HandleImage(w http.ResponseWriter, r *http.Request) {
+	p, err := s.ImagePath(r.PathValue("id"), r.PathValue("file"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	http.ServeFile(w, r, p)
+}
+
+// HandleAudio serves GET /api/books/{id}/audio/{file}.
+func (s *Store) HandleAudio(w http.ResponseWriter, r *http.Request) {
+	p, err := s.AudioPath(r.PathValue("id"), r.PathValue("file"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	http.ServeFile(w, r, p)
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	if errors.Is(err, ErrNotFound) {
+		http.Error(w, "book not found", http.StatusNotFound)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
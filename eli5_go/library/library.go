@@ -0,0 +1,470 @@
+// Package library persists generated storybooks and their images on disk so
+// they can be revisited and shared.
+//
+// Each book is stored in its own directory:
+//
+//	<dir>/<id>/book.json
+//	<dir>/<id>/avatar.png
+//	<dir>/<id>/page-01.png
+//	<dir>/<id>/page-01.wav
+package library
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"eli5/grounding"
+	"eli5/media"
+	"eli5/moderation"
+	"eli5/tts"
+)
+
+// ErrNotFound is returned when a book or image does not exist.
+var ErrNotFound = errors.New("not found")
+
+// BookStatus is the generation state of a book.
+type BookStatus string
+
+const (
+	BookWriting      BookStatus = "writing"
+	BookIllustrating BookStatus = "illustrating"
+	BookComplete     BookStatus = "complete"
+	BookFailed       BookStatus = "failed"
+)
+
+// PageStatus is the illustration state of a page.
+type PageStatus string
+
+const (
+	PagePending      PageStatus = "pending"
+	PageIllustrating PageStatus = "illustrating"
+	PageDone         PageStatus = "done"
+	PageFailed       PageStatus = "failed"
+)
+
+type Page struct {
+	Text         string `json:"text"`
+	Illustration string `json:"illustration"`
+	// Image is the URL of the page's generated illustration, if any.
+	Image string `json:"image,omitempty"`
+	// Sources are the numbers of the book's sources that support the page,
+	// starting at 1.
+	Sources  []int      `json:"sources,omitempty"`
+	Status   PageStatus `json:"status,omitempty"`
+	Attempts int        `json:"attempts,omitempty"`
+	Error    string     `json:"error,omitempty"`
+	// Narration is the page's text read aloud, if it has been narrated.
+	Narration *Narration `json:"narration,omitempty"`
+}
+
+// Narration is a recording of a page's text.
+type Narration struct {
+	// Audio is the URL of the recording.
+	Audio      string `json:"audio"`
+	Voice      string `json:"voice,omitempty"`
+	DurationMs int    `json:"durationMs"`
+	// Words are the timings of the words of the page's text, for highlighting
+	// each word as it is read.
+	Words []tts.Word `json:"words"`
+}
+
+// Character is the canonical look of the user's character in a book, used as
+// the reference for every illustration.
+type Character struct {
+	// Image is the URL of the character reference sheet.
+	Image string `json:"image"`
+	// Description describes the character's appearance, outfit and colors.
+	Description string `json:"description,omitempty"`
+}
+
+type Book struct {
+	ID        string    `json:"id"`
+	Question  string    `json:"question"`
+	Title     string    `json:"bookTitle"`
+	CreatedAt time.Time `json:"createdAt"`
+	// Language is the BCP 47 tag of the language the book is written in.
+	Language     string `json:"language,omitempty"`
+	ReadingLevel string `json:"readingLevel,omitempty"`
+	Tone         string `json:"tone,omitempty"`
+	// PageCount is the requested number of pages, or 0 for the default.
+	PageCount int `json:"pageCount,omitempty"`
+	// Avatar is the URL of the user's cartoon avatar, if any.
+	Avatar    string     `json:"avatar,omitempty"`
+	Character *Character `json:"character,omitempty"`
+	Pages     []Page     `json:"pages"`
+	// Sources are the web pages the lesson is based on.
+	Sources []grounding.Source `json:"sources,omitempty"`
+	Status  BookStatus         `json:"status,omitempty"`
+	// Message describes the current generation step.
+	Message string `json:"message,omitempty"`
+	Error   string `json:"error,omitempty"`
+	// Moderation lists the parts of the book that were rewritten or flagged
+	// by content moderation.
+	Moderation []moderation.Note `json:"moderation,omitempty"`
+}
+
+// Clone returns a deep copy of b.
+func (b *Book) Clone() *Book {
+	c := *b
+	c.Pages = append([]Page{}, b.Pages...)
+	for i, p := range c.Pages {
+		if p.Narration != nil {
+			narration := *p.Narration
+			c.Pages[i].Narration = &narration
+		}
+	}
+	c.Sources = append([]grounding.Source(nil), b.Sources...)
+	c.Moderation = append([]moderation.Note(nil), b.Moderation...)
+	if b.Character != nil {
+		character := *b.Character
+		c.Character = &character
+	}
+	return &c
+}
+
+// Finished reports whether the book is no longer being generated.
+func (b *Book) Finished() bool {
+	return b.Status == "" || b.Status == BookComplete || b.Status == BookFailed
+}
+
+// Summary is the list view of a Book.
+type Summary struct {
+	ID        string     `json:"id"`
+	Question  string     `json:"question"`
+	Title     string     `json:"bookTitle"`
+	CreatedAt time.Time  `json:"createdAt"`
+	Language  string     `json:"language,omitempty"`
+	Avatar    string     `json:"avatar,omitempty"`
+	PageCount int        `json:"pageCount"`
+	Status    BookStatus `json:"status,omitempty"`
+}
+
+// Store is a directory of books.
+type Store struct {
+	dir string
+	mu  sync.RWMutex
+}
+
+// NewStore returns a Store rooted at dir, creating the directory if needed.
+func NewStore(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create library directory: %w", err)
+	}
+	return &Store{dir: dir}, nil
+}
+
+var (
+	idPattern        = regexp.MustCompile(`^[0-9a-f]{16}$`)
+	imageNamePattern = regexp.MustCompile(`^[a-z0-9-]+\.(png|jpg|webp|gif)$`)
+	audioNamePattern = regexp.MustCompile(`^[a-z0-9-]+\.(wav|mp3|ogg)$`)
+)
+
+// NewID returns a random book ID.
+func NewID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// ValidID reports whether id has the format of a book ID.
+func ValidID(id string) bool {
+	return idPattern.MatchString(id)
+}
+
+// Create stores a new book, assigning it an ID and creation time.
+func (s *Store) Create(b *Book) (*Book, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b.ID = NewID()
+	if b.CreatedAt.IsZero() {
+		b.CreatedAt = time.Now().UTC()
+	}
+	if b.Pages == nil {
+		b.Pages = []Page{}
+	}
+	if err := os.MkdirAll(s.bookDir(b.ID), 0o755); err != nil {
+		return nil, err
+	}
+	if err := s.write(b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Update replaces the stored metadata of an existing book.
+func (s *Store) Update(b *Book) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.exists(b.ID) {
+		return ErrNotFound
+	}
+	return s.write(b)
+}
+
+// Get returns the book with the given ID.
+func (s *Store) Get(id string) (*Book, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.read(id)
+}
+
+// List returns summaries of every book, newest first.
+func (s *Store) List() ([]*Summary, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	summaries := []*Summary{}
+	for _, e := range entries {
+		if !e.IsDir() || !ValidID(e.Name()) {
+			continue
+		}
+		b, err := s.read(e.Name())
+		if err != nil {
+			// Skip books that are half-written or were removed concurrently.
+			continue
+		}
+		summaries = append(summaries, &Summary{
+			ID:        b.ID,
+			Question:  b.Question,
+			Title:     b.Title,
+			CreatedAt: b.CreatedAt,
+			Language:  b.Language,
+			Avatar:    b.Avatar,
+			PageCount: len(b.Pages),
+			Status:    b.Status,
+		})
+	}
+	sort.Slice(summaries, func(i, j int) bool {
+		return summaries[i].CreatedAt.After(summaries[j].CreatedAt)
+	})
+	return summaries, nil
+}
+
+// Delete removes a book and all of its files.
+func (s *Store) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.exists(id) {
+		return ErrNotFound
+	}
+	return os.RemoveAll(s.bookDir(id))
+}
+
+// PutImage decodes a data URI and stores it as an image of the book. name is
+// the file name without extension, e.g. "avatar" or "page-01"; the extension
+// is derived from the media type, which must match the decoded data. It
+// returns the URL the image is served at.
+func (s *Store) PutImage(id, name, dataURI string) (string, error) {
+	contentType, data, err := media.ParseDataURI(dataURI)
+	if err != nil {
+		return "", err
+	}
+	ext, ok := imageExtensions[contentType]
+	if !ok {
+		return "", fmt.Errorf("unsupported image type %q", contentType)
+	}
+	if sniffed := http.DetectContentType(data); sniffed != contentType {
+		return "", fmt.Errorf("image data is %s, not %s", sniffed, contentType)
+	}
+	return s.PutImageBytes(id, name+ext, data)
+}
+
+// PutImageBytes stores raw image data under file name and returns its URL.
+func (s *Store) PutImageBytes(id, file string, data []byte) (string, error) {
+	if !imageNamePattern.MatchString(file) {
+		return "", fmt.Errorf("invalid image name %q", file)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.exists(id) {
+		return "", ErrNotFound
+	}
+	if err := writeFileAtomic(filepath.Join(s.bookDir(id), file), data); err != nil {
+		return "", err
+	}
+	return ImageURL(id, file), nil
+}
+
+// ImagePath returns the file path of a stored image.
+func (s *Store) ImagePath(id, file string) (string, error) {
+	if !ValidID(id) || !imageNamePattern.MatchString(file) {
+		return "", ErrNotFound
+	}
+	p := filepath.Join(s.bookDir(id), file)
+	if _, err := os.Stat(p); err != nil {
+		return "", ErrNotFound
+	}
+	return p, nil
+}
+
+// ReadImage returns the content type and data of a stored image, given its URL.
+func (s *Store) ReadImage(url string) (string, []byte, error) {
+	rest, ok := strings.CutPrefix(url, "/api/books/")
+	if !ok {
+		return "", nil, ErrNotFound
+	}
+	id, file, ok := strings.Cut(rest, "/images/")
+	if !ok {
+		return "", nil, ErrNotFound
+	}
+	p, err := s.ImagePath(id, file)
+	if err != nil {
+		return "", nil, err
+	}
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return "", nil, err
+	}
+	for contentType, ext := range imageExtensions {
+		if filepath.Ext(file) == ext {
+			return contentType, data, nil
+		}
+	}
+	return "", nil, fmt.Errorf("unsupported image %q", file)
+}
+
+// PutAudio stores a recording under name, the file name without extension,
+// e.g. "page-01". The extension is derived from the content type. It returns
+// the URL the recording is served at.
+func (s *Store) PutAudio(id, name, contentType string, data []byte) (string, error) {
+	ext, ok := audioExtensions[contentType]
+	if !ok {
+		return "", fmt.Errorf("unsupported audio type %q", contentType)
+	}
+	file := name + ext
+	if !audioNamePattern.MatchString(file) {
+		return "", fmt.Errorf("invalid audio name %q", file)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.exists(id) {
+		return "", ErrNotFound
+	}
+	if err := writeFileAtomic(filepath.Join(s.bookDir(id), file), data); err != nil {
+		return "", err
+	}
+	return AudioURL(id, file), nil
+}
+
+// AudioPath returns the file path of a stored recording.
+func (s *Store) AudioPath(id, file string) (string, error) {
+	if !ValidID(id) || !audioNamePattern.MatchString(file) {
+		return "", ErrNotFound
+	}
+	p := filepath.Join(s.bookDir(id), file)
+	if _, err := os.Stat(p); err != nil {
+		return "", ErrNotFound
+	}
+	return p, nil
+}
+
+// AudioURL returns the URL at which a recording of a book is served.
+func AudioURL(id, file string) string {
+	return "/api/books/" + id + "/audio/" + file
+}
+
+// ImageURL returns the URL at which an image of a book is served.
+func ImageURL(id, file string) string {
+	return "/api/books/" + id + "/images/" + file
+}
+
+// PageImageName returns the file name, without extension, of a page's illustration.
+func PageImageName(index int) string {
+	return fmt.Sprintf("page-%02d", index+1)
+}
+
+// PageAudioName returns the file name, without extension, of a page's narration.
+func PageAudioName(index int) string {
+	return fmt.Sprintf("page-%02d", index+1)
+}
+
+func (s *Store) bookDir(id string) string {
+	return filepath.Join(s.dir, id)
+}
+
+func (s *Store) exists(id string) bool {
+	if !ValidID(id) {
+		return false
+	}
+	_, err := os.Stat(filepath.Join(s.bookDir(id), "book.json"))
+	return err == nil
+}
+
+func (s *Store) read(id string) (*Book, error) {
+	if !ValidID(id) {
+		return nil, ErrNotFound
+	}
+	data, err := os.ReadFile(filepath.Join(s.bookDir(id), "book.json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	b := &Book{}
+	if err := json.Unmarshal(data, b); err != nil {
+		return nil, fmt.Errorf("failed to read book %s: %w", id, err)
+	}
+	return b, nil
+}
+
+func (s *Store) write(b *Book) error {
+	data, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(s.bookDir(b.ID), "book.json"), data)
+}
+
+// writeFileAtomic writes data to a temporary file and renames it into place so
+// readers never observe a partially written file.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+var imageExtensions = map[string]string{
+	"image/png":  ".png",
+	"image/jpeg": ".jpg",
+	"image/webp": ".webp",
+	"image/gif":  ".gif",
+}
+
+var audioExtensions = map[string]string{
+	"audio/wav":  ".wav",
+	"audio/mpeg": ".mp3",
+	"audio/ogg":  ".ogg",
+}
@@ -4,12 +4,21 @@ import (
 	"context"
 	"log"
 	"net/http"
+	"os"
+	"strconv"
 
+	"eli5/export"
 	"eli5/flows"
+	"eli5/library"
+	"eli5/media"
+	"eli5/moderation"
+	"eli5/tts"
+	"flow-transports/go/transport"
 
 	"github.com/firebase/genkit/go/genkit"
 	"github.com/firebase/genkit/go/plugins/googlegenai"
 	"github.com/firebase/genkit/go/plugins/server"
+	"google.golang.org/genai"
 )
 
 func main() {
@@ -19,9 +28,85 @@ func main() {
 		genkit.WithPlugins(&googlegenai.GoogleAI{}),
 	)
 
-	cartoonifyFlow := flows.DefineCartoonifyFlow(g)
-	illustrateFlow := flows.DefineIllustrateFlow(g)
-	storifyFlow := flows.DefineStorifyFlow(g)
+	// The lesson is researched with a model that keeps the search grounding
+	// metadata, which the googleai plugin drops.
+	genaiClient, err := genai.NewClient(ctx, &genai.ClientConfig{Backend: genai.BackendGeminiAPI})
+	if err != nil {
+		log.Fatal(err)
+	}
+	flows.DefineSearchModel(g, genaiClient)
+
+	dataDir := os.Getenv("ELI5_DATA_DIR")
+	if dataDir == "" {
+		dataDir = "data"
+	}
+	store, err := library.NewStore(dataDir + "/books")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	imageOpts := media.DefaultOptions
+	imageOpts.MaxBytes = envInt("ELI5_MAX_IMAGE_BYTES", imageOpts.MaxBytes)
+	imageOpts.MaxDimension = envInt("ELI5_MAX_IMAGE_DIMENSION", imageOpts.MaxDimension)
+
+	policy := moderation.DefaultPolicy()
+	if path := os.Getenv("ELI5_MODERATION_POLICY"); path != "" {
+		if policy, err = moderation.LoadPolicy(path); err != nil {
+			log.Fatal(err)
+		}
+	}
+	var classifier moderation.Classifier
+	switch model := os.Getenv("ELI5_MODERATION_MODEL"); model {
+	case "off":
+	case "":
+		classifier = flows.NewModelClassifier(g, "googleai/gemini-2.5-flash")
+	default:
+		classifier = flows.NewModelClassifier(g, model)
+	}
+	mod, err := moderation.New(policy, classifier)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var synth tts.Synthesizer
+	switch model := os.Getenv("ELI5_TTS_MODEL"); model {
+	case "stub":
+		synth = tts.Silent{}
+	case "":
+		synth = flows.NewModelSynthesizer(g, "googleai/gemini-2.5-flash-preview-tts")
+	default:
+		synth = flows.NewModelSynthesizer(g, model)
+	}
+
+	cartoonifyFlow := flows.DefineCartoonifyFlow(g, imageOpts, mod)
+	illustrateFlow := flows.DefineIllustrateFlow(g, imageOpts, mod)
+	storifyFlow := flows.DefineStorifyFlow(g, mod)
+	characterSheetFlow := flows.DefineCharacterSheetFlow(g, imageOpts, mod)
+	saveBookFlow := flows.DefineSaveBookFlow(g, store, mod)
+
+	storybookJobs := flows.NewStorybookJobs(g, store, imageOpts, mod)
+	createStorybookFlow := flows.DefineCreateStorybookFlow(g, storybookJobs)
+	watchStorybookFlow := flows.DefineWatchStorybookFlow(g, storybookJobs)
+	regeneratePageFlow := flows.DefineRegeneratePageFlow(g, storybookJobs)
+	narrateFlow := flows.DefineNarrateFlow(g, storybookJobs, synth)
+	if err := storybookJobs.Resume(ctx); err != nil {
+		log.Printf("failed to resume storybook jobs: %v", err)
+	}
+
+	jobStore, err := transport.NewDirStore(dataDir + "/jobs")
+	if err != nil {
+		log.Fatal(err)
+	}
+	flowJobs, err := transport.NewJobs(transport.JobsOptions{
+		Store:         jobStore,
+		WebhookSecret: os.Getenv("ELI5_WEBHOOK_SECRET"),
+		// Webhooks to local addresses are only for development.
+		AllowPrivateCallbacks: os.Getenv("ELI5_ALLOW_PRIVATE_CALLBACKS") == "true",
+	}, cartoonifyFlow, illustrateFlow, storifyFlow, characterSheetFlow, saveBookFlow,
+		createStorybookFlow, watchStorybookFlow, regeneratePageFlow, narrateFlow)
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	mux := http.NewServeMux()
 
@@ -30,23 +115,89 @@ func main() {
 	mux.Handle("/", fs)
 
 	mux.HandleFunc("OPTIONS /api/cartoonify", corsMiddleware(nil))
-	mux.HandleFunc("POST /api/cartoonify", corsMiddleware(genkit.Handler(cartoonifyFlow)))
+	mux.HandleFunc("POST /api/cartoonify", corsMiddleware(transport.Handler(cartoonifyFlow)))
 
 	mux.HandleFunc("OPTIONS /api/illustrate", corsMiddleware(nil))
-	mux.HandleFunc("POST /api/illustrate", corsMiddleware(genkit.Handler(illustrateFlow)))
+	mux.HandleFunc("POST /api/illustrate", corsMiddleware(transport.Handler(illustrateFlow)))
 
 	mux.HandleFunc("OPTIONS /api/storify", corsMiddleware(nil))
-	mux.HandleFunc("POST /api/storify", corsMiddleware(genkit.Handler(storifyFlow)))
+	mux.HandleFunc("POST /api/storify", corsMiddleware(transport.Handler(storifyFlow)))
+
+	mux.HandleFunc("OPTIONS /api/characterSheet", corsMiddleware(nil))
+	mux.HandleFunc("POST /api/characterSheet", corsMiddleware(transport.Handler(characterSheetFlow)))
+
+	mux.HandleFunc("OPTIONS /api/saveBook", corsMiddleware(nil))
+	mux.HandleFunc("POST /api/saveBook", corsMiddleware(transport.Handler(saveBookFlow)))
+
+	mux.HandleFunc("OPTIONS /api/createStorybook", corsMiddleware(nil))
+	mux.HandleFunc("POST /api/createStorybook", corsMiddleware(transport.Handler(createStorybookFlow)))
+
+	mux.HandleFunc("OPTIONS /api/watchStorybook", corsMiddleware(nil))
+	mux.HandleFunc("POST /api/watchStorybook", corsMiddleware(transport.Handler(watchStorybookFlow)))
+
+	mux.HandleFunc("OPTIONS /api/regeneratePage", corsMiddleware(nil))
+	mux.HandleFunc("POST /api/regeneratePage", corsMiddleware(transport.Handler(regeneratePageFlow)))
+
+	mux.HandleFunc("OPTIONS /api/narrate", corsMiddleware(nil))
+	mux.HandleFunc("POST /api/narrate", corsMiddleware(transport.Handler(narrateFlow)))
+
+	// The streaming flows are also served as server-sent events, and all
+	// of them over one WebSocket.
+	streamingFlows := []transport.Flow{storifyFlow, createStorybookFlow, watchStorybookFlow, regeneratePageFlow, narrateFlow}
+	for _, flow := range streamingFlows {
+		sse := corsMiddleware(transport.SSEHandler(flow))
+		mux.HandleFunc("OPTIONS /api/sse/"+flow.Name(), corsMiddleware(nil))
+		mux.HandleFunc("GET /api/sse/"+flow.Name(), sse)
+		mux.HandleFunc("POST /api/sse/"+flow.Name(), sse)
+	}
+	// Any origin may connect, like the CORS settings of the other routes.
+	mux.Handle("GET /api/ws", transport.WebSocketHandler(streamingFlows, transport.WithAllowedOrigins("*")))
+
+	// Every run's ID is in its X-Run-Id response header, its SSE event IDs or
+	// its WebSocket "started" message, and stops the run when posted here.
+	mux.HandleFunc("OPTIONS /api/runs/{id}/cancel", corsMiddleware(nil))
+	mux.HandleFunc("POST /api/runs/{id}/cancel", corsMiddleware(transport.CancelHandler()))
+
+	// Any flow can also run as a job, for clients that cannot wait for the
+	// response, such as a storify run with Gemini 2.5 Pro behind a proxy.
+	mux.HandleFunc("OPTIONS /api/jobs/", corsMiddleware(nil))
+	mux.HandleFunc("POST /api/jobs/{flow}", corsMiddleware(flowJobs))
+	mux.HandleFunc("GET /api/jobs/{id}", corsMiddleware(flowJobs))
+
+	// Books are shared by ID, so only listing and deleting them need the
+	// library token, when ELI5_LIBRARY_TOKEN sets one.
+	libraryToken := os.Getenv("ELI5_LIBRARY_TOKEN")
+	mux.HandleFunc("OPTIONS /api/books/", corsMiddleware(nil))
+	mux.HandleFunc("GET /api/books", corsMiddleware(library.RequireToken(libraryToken, store.HandleList)))
+	mux.HandleFunc("GET /api/books/{id}", corsMiddleware(http.HandlerFunc(store.HandleGet)))
+	mux.HandleFunc("DELETE /api/books/{id}", corsMiddleware(library.RequireToken(libraryToken, store.HandleDelete)))
+	mux.HandleFunc("GET /api/books/{id}/images/{file}", corsMiddleware(http.HandlerFunc(store.HandleImage)))
+	mux.HandleFunc("GET /api/books/{id}/audio/{file}", corsMiddleware(http.HandlerFunc(store.HandleAudio)))
+	mux.HandleFunc("GET /api/books/{id}/export", corsMiddleware(export.Handler(store)))
 
 	log.Println("Starting server on http://localhost:3001")
 	log.Fatal(server.Start(ctx, "127.0.0.1:3001", mux))
 }
 
+// envInt returns the integer value of an environment variable, or def if it is unset.
+func envInt(name string, def int) int {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n <= 0 {
+		log.Fatalf("%s must be a positive integer, got %q", name, v)
+	}
+	return n
+}
+
 func corsMiddleware(next http.Handler) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Last-Event-ID")
+		w.Header().Set("Access-Control-Expose-Headers", transport.RunIDHeader+", Location")
 		if r.Method == "OPTIONS" {
 			w.WriteHeader(http.StatusOK)
 			return
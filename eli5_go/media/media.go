@@ -0,0 +1,192 @@
+// Package media validates and normalizes images uploaded by users before
+// they are sent to a model or stored.
+package media
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"strings"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/webp"
+)
+
+// Options limits the images accepted by Ingest.
+type Options struct {
+	// MaxBytes is the largest accepted decoded payload.
+	MaxBytes int
+	// MaxPixels is the largest accepted image area, which guards against
+	// small files that decode to huge images.
+	MaxPixels int
+	// MaxDimension is the longest side of the normalized image. Larger images
+	// are downscaled to fit.
+	MaxDimension int
+}
+
+// DefaultOptions are the limits used when none are configured.
+var DefaultOptions = Options{
+	MaxBytes:     10 << 20,
+	MaxPixels:    50_000_000,
+	MaxDimension: 1024,
+}
+
+var (
+	// ErrMalformed is returned for input that is not a base64 data URI.
+	ErrMalformed = errors.New("malformed data URI")
+	// ErrNotImage is returned for data that is not an image of a supported type.
+	ErrNotImage = errors.New("not a supported image")
+	// ErrTooLarge is returned for images that exceed the configured limits.
+	ErrTooLarge = errors.New("image too large")
+)
+
+// Image is a validated image.
+type Image struct {
+	ContentType string
+	Data        []byte
+	Width       int
+	Height      int
+}
+
+// DataURI returns the image as a base64 data URI.
+func (img *Image) DataURI() string {
+	return "data:" + img.ContentType + ";base64," + base64.StdEncoding.EncodeToString(img.Data)
+}
+
+// Ingest decodes a data URI, checks that its content really is a supported
+// image regardless of the declared media type, and re-encodes it. Re-encoding
+// drops all metadata, including EXIF location data; the EXIF orientation is
+// applied to the pixels first so that photos stay upright. Images larger than
+// opts.MaxDimension are downscaled. JPEG input is returned as JPEG and
+// everything else as PNG.
+func Ingest(dataURI string, opts Options) (*Image, error) {
+	_, data, err := ParseDataURI(dataURI)
+	if err != nil {
+		return nil, err
+	}
+	if opts.MaxBytes > 0 && len(data) > opts.MaxBytes {
+		return nil, fmt.Errorf("%w: %d bytes exceeds the limit of %d", ErrTooLarge, len(data), opts.MaxBytes)
+	}
+
+	contentType := Sniff(data)
+	decode, ok := decoders[contentType]
+	if !ok {
+		return nil, fmt.Errorf("%w: detected %s; use JPEG, PNG, WebP or GIF", ErrNotImage, contentType)
+	}
+	cfg, err := decode.config(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrNotImage, err)
+	}
+	if opts.MaxPixels > 0 && cfg.Width*cfg.Height > opts.MaxPixels {
+		return nil, fmt.Errorf("%w: %dx%d pixels", ErrTooLarge, cfg.Width, cfg.Height)
+	}
+	src, err := decode.image(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrNotImage, err)
+	}
+
+	// Scaling first is cheaper, and orientation only swaps the sides.
+	src = fit(src, opts.MaxDimension)
+	if contentType == "image/jpeg" {
+		src = orient(src, exifOrientation(data))
+	}
+
+	var buf bytes.Buffer
+	outType := "image/png"
+	if contentType == "image/jpeg" {
+		outType = "image/jpeg"
+		err = jpeg.Encode(&buf, src, &jpeg.Options{Quality: 90})
+	} else {
+		err = png.Encode(&buf, src)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode image: %w", err)
+	}
+	b := src.Bounds()
+	return &Image{ContentType: outType, Data: buf.Bytes(), Width: b.Dx(), Height: b.Dy()}, nil
+}
+
+// ParseDataURI returns the declared media type and decoded payload of a
+// base64 data URI.
+func ParseDataURI(uri string) (string, []byte, error) {
+	rest, ok := strings.CutPrefix(uri, "data:")
+	if !ok {
+		return "", nil, fmt.Errorf("%w: missing data: prefix", ErrMalformed)
+	}
+	meta, payload, ok := strings.Cut(rest, ",")
+	if !ok {
+		return "", nil, ErrMalformed
+	}
+	contentType, ok := strings.CutSuffix(meta, ";base64")
+	if !ok {
+		return "", nil, fmt.Errorf("%w: not base64 encoded", ErrMalformed)
+	}
+	data, err := base64.StdEncoding.DecodeString(payload)
+	if err != nil {
+		return "", nil, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	return contentType, data, nil
+}
+
+// Sniff returns the content type of data based on its contents. HEIC/HEIF
+// images, which browsers on iOS commonly send, are recognized so that they
+// can be reported as unsupported rather than as unknown data.
+func Sniff(data []byte) string {
+	if len(data) >= 12 && string(data[4:8]) == "ftyp" {
+		switch string(data[8:12]) {
+		case "heic", "heix", "hevc", "hevx", "heim", "heis":
+			return "image/heic"
+		case "mif1", "msf1":
+			return "image/heif"
+		}
+	}
+	return strings.TrimSuffix(http.DetectContentType(data), "; charset=utf-8")
+}
+
+type decoder struct {
+	config func(r *bytes.Reader) (image.Config, error)
+	image  func(r *bytes.Reader) (image.Image, error)
+}
+
+var decoders = map[string]decoder{
+	"image/jpeg": {
+		config: func(r *bytes.Reader) (image.Config, error) { return jpeg.DecodeConfig(r) },
+		image:  func(r *bytes.Reader) (image.Image, error) { return jpeg.Decode(r) },
+	},
+	"image/png": {
+		config: func(r *bytes.Reader) (image.Config, error) { return png.DecodeConfig(r) },
+		image:  func(r *bytes.Reader) (image.Image, error) { return png.Decode(r) },
+	},
+	"image/gif": {
+		// Only the first frame of an animation is kept.
+		config: func(r *bytes.Reader) (image.Config, error) { return gif.DecodeConfig(r) },
+		image:  func(r *bytes.Reader) (image.Image, error) { return gif.Decode(r) },
+	},
+	"image/webp": {
+		config: func(r *bytes.Reader) (image.Config, error) { return webp.DecodeConfig(r) },
+		image:  func(r *bytes.Reader) (image.Image, error) { return webp.Decode(r) },
+	},
+}
+
+// fit downscales img so that neither side exceeds maxDim.
+func fit(img image.Image, maxDim int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if maxDim <= 0 || w <= maxDim && h <= maxDim {
+		return img
+	}
+	if w >= h {
+		w, h = maxDim, max(1, h*maxDim/w)
+	} else {
+		w, h = max(1, w*maxDim/h), maxDim
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, b, draw.Src, nil)
+	return dst
+}
@@ -0,0 +1,275 @@
+// Package moderation screens user input and generated content against a
+// child-safety policy. A policy combines local keyword and regular expression
+// rules with an optional classifier, usually a model, for everything the rules
+// cannot catch.
+package moderation
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+
+	"eli5/media"
+)
+
+// Action is what happens to moderated content.
+type Action string
+
+const (
+	// Allow lets the content through unchanged.
+	Allow Action = "allow"
+	// Flag lets the content through but records the reason, e.g. for review.
+	Flag Action = "flag"
+	// Rewrite replaces the offending parts of the content.
+	Rewrite Action = "rewrite"
+	// Block rejects the content.
+	Block Action = "block"
+)
+
+// severity orders actions so that the strictest decision wins.
+var severity = map[Action]int{Allow: 0, Flag: 1, Rewrite: 2, Block: 3}
+
+// Target is the kind of content being moderated.
+type Target string
+
+const (
+	// Input is text typed by the user, such as a question or instructions.
+	Input Target = "input"
+	// Image is an image uploaded by the user.
+	Image Target = "image"
+	// Text is generated text, such as a book title or page text.
+	Text Target = "text"
+	// Illustration is a generated description of an illustration.
+	Illustration Target = "illustration"
+)
+
+// Content is a piece of content to moderate. Image targets set Image and all
+// other targets set Text.
+type Content struct {
+	Target Target
+	Text   string
+	Image  *media.Image
+}
+
+// Decision is the outcome of moderating a piece of content.
+type Decision struct {
+	Action Action
+	// Reason explains the decision to the user. It is empty for Allow.
+	Reason string
+	// Rule is the name of the rule that made the decision, or "classifier".
+	Rule string
+	// Text is the content's text after any rewrites.
+	Text string
+}
+
+// Note records a decision about part of a request or result so that it can be
+// returned to the client.
+type Note struct {
+	// Field is the moderated field, e.g. "question" or "pages[2].text".
+	Field  string `json:"field"`
+	Action Action `json:"action"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// Rule matches content by keyword or regular expression.
+type Rule struct {
+	Name string `json:"name"`
+	// Targets are the targets the rule applies to. By default it applies to
+	// all text targets.
+	Targets []Target `json:"targets,omitempty"`
+	// Keywords are matched as whole words, ignoring case.
+	Keywords []string `json:"keywords,omitempty"`
+	// Patterns are regular expressions in RE2 syntax, matched ignoring case.
+	Patterns []string `json:"patterns,omitempty"`
+	Action   Action   `json:"action"`
+	Reason   string   `json:"reason"`
+	// Replacement replaces every match when the action is Rewrite.
+	Replacement string `json:"replacement,omitempty"`
+}
+
+// Policy configures a Moderator.
+type Policy struct {
+	Rules []Rule `json:"rules"`
+	// Classify lists the targets that are passed to the classifier, if there
+	// is one. Content that a rule blocks is not classified.
+	Classify []Target `json:"classify,omitempty"`
+	// Guidelines describe acceptable content to the classifier.
+	Guidelines string `json:"guidelines,omitempty"`
+}
+
+//go:embed default_policy.json
+var defaultPolicy []byte
+
+// DefaultPolicy returns the built-in policy for a children's app.
+func DefaultPolicy() *Policy {
+	var p Policy
+	if err := json.Unmarshal(defaultPolicy, &p); err != nil {
+		panic(fmt.Sprintf("invalid default moderation policy: %v", err))
+	}
+	return &p
+}
+
+// LoadPolicy reads a policy from a JSON file.
+func LoadPolicy(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var p Policy
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("invalid moderation policy %s: %w", path, err)
+	}
+	return &p, nil
+}
+
+// Classifier decides on content that the local rules let through. It returns
+// one decision per item, in order. A Rewrite decision carries the rewritten
+// text; Rule is ignored.
+type Classifier interface {
+	Classify(ctx context.Context, guidelines string, items []Content) ([]Decision, error)
+}
+
+// Moderator applies a policy.
+type Moderator struct {
+	rules      []rule
+	classify   []Target
+	guidelines string
+	classifier Classifier
+}
+
+type rule struct {
+	Rule
+	re *regexp.Regexp
+}
+
+// New compiles a policy. The classifier may be nil, in which case only the
+// rules are applied and images are always allowed.
+func New(p *Policy, classifier Classifier) (*Moderator, error) {
+	m := &Moderator{classify: p.Classify, guidelines: p.Guidelines, classifier: classifier}
+	for _, r := range p.Rules {
+		if _, ok := severity[r.Action]; !ok {
+			return nil, fmt.Errorf("moderation rule %q: unknown action %q", r.Name, r.Action)
+		}
+		var alts []string
+		for _, k := range r.Keywords {
+			alts = append(alts, `\b`+regexp.QuoteMeta(k)+`\b`)
+		}
+		alts = append(alts, r.Patterns...)
+		if len(alts) == 0 {
+			return nil, fmt.Errorf("moderation rule %q has no keywords or patterns", r.Name)
+		}
+		re, err := regexp.Compile(`(?i)(?:` + strings.Join(alts, "|") + `)`)
+		if err != nil {
+			return nil, fmt.Errorf("moderation rule %q: %w", r.Name, err)
+		}
+		m.rules = append(m.rules, rule{r, re})
+	}
+	return m, nil
+}
+
+// Check moderates items and returns a decision for each, in order. Rules are
+// applied first, in policy order: a blocking rule decides immediately, and
+// rewrites apply to the text seen by later rules and the classifier. The
+// strictest decision wins, and the reasons of Flag and Rewrite decisions are
+// combined. If a rule blocks any item, the classifier is skipped, since
+// callers reject the whole request or result anyway.
+func (m *Moderator) Check(ctx context.Context, items ...Content) ([]Decision, error) {
+	decisions := make([]Decision, len(items))
+	var pending []int
+	blocked := false
+	for i, c := range items {
+		d := &decisions[i]
+		*d = Decision{Action: Allow, Text: c.Text}
+		if c.Target != Image {
+			m.applyRules(c.Target, d)
+		}
+		blocked = blocked || d.Action == Block
+		if m.classifier != nil && slices.Contains(m.classify, c.Target) {
+			pending = append(pending, i)
+		}
+	}
+	if blocked || len(pending) == 0 {
+		return decisions, nil
+	}
+
+	batch := make([]Content, len(pending))
+	for j, i := range pending {
+		batch[j] = items[i]
+		batch[j].Text = decisions[i].Text
+	}
+	verdicts, err := m.classifier.Classify(ctx, m.guidelines, batch)
+	if err != nil {
+		return nil, fmt.Errorf("failed to classify content: %w", err)
+	}
+	if len(verdicts) != len(batch) {
+		return nil, fmt.Errorf("classifier returned %d decisions for %d items", len(verdicts), len(batch))
+	}
+	for j, i := range pending {
+		v := verdicts[j]
+		v.Rule = "classifier"
+		if v.Action == Rewrite && (items[i].Target == Image || strings.TrimSpace(v.Text) == "") {
+			// Images and empty rewrites cannot be used in place of the content.
+			v.Action = Block
+		}
+		merge(&decisions[i], v)
+	}
+	return decisions, nil
+}
+
+func (m *Moderator) applyRules(t Target, d *Decision) {
+	for _, r := range m.rules {
+		if len(r.Targets) > 0 && !slices.Contains(r.Targets, t) || !r.re.MatchString(d.Text) {
+			continue
+		}
+		v := Decision{Action: r.Action, Reason: r.Reason, Rule: r.Name, Text: d.Text}
+		if r.Action == Rewrite {
+			v.Text = r.re.ReplaceAllLiteralString(d.Text, r.Replacement)
+		}
+		merge(d, v)
+		if d.Action == Block {
+			return
+		}
+	}
+}
+
+// merge combines decision v into d. An action merge does not recognize,
+// such as a misspelling from a model, blocks the content.
+func merge(d *Decision, v Decision) {
+	if _, ok := severity[v.Action]; !ok {
+		if v.Reason == "" {
+			v.Reason = fmt.Sprintf("unknown moderation action %q", v.Action)
+		}
+		v.Action = Block
+	}
+	switch {
+	case v.Action == Allow:
+		return
+	case severity[v.Action] > severity[d.Action]:
+		d.Action, d.Rule = v.Action, v.Rule
+		if v.Action == Block {
+			d.Reason = v.Reason
+		} else {
+			d.Reason = joinReasons(d.Reason, v.Reason)
+		}
+	case d.Action != Block:
+		d.Reason = joinReasons(d.Reason, v.Reason)
+	}
+	if v.Action == Rewrite {
+		d.Text = v.Text
+	}
+}
+
+func joinReasons(a, b string) string {
+	switch {
+	case a == "" || a == b:
+		return b
+	case b == "":
+		return a
+	}
+	return a + "; " + b
+}
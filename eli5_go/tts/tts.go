@@ -0,0 +1,189 @@
+// Package tts turns text into speech, with the timing of every word so that
+// a client can highlight the text as it is read aloud.
+package tts
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"mime"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf16"
+)
+
+// Request is text to speak.
+type Request struct {
+	Text string
+	// Language is the BCP 47 tag of the language of the text.
+	Language string
+	// Voice is the name of the voice to use. Synthesizers pick a default
+	// voice if it is empty.
+	Voice string
+}
+
+// Speech is synthesized audio.
+type Speech struct {
+	ContentType string
+	Data        []byte
+	Duration    time.Duration
+	Words       []Word
+}
+
+// Word is the timing of a word of the spoken text.
+type Word struct {
+	Text string `json:"text"`
+	// Start and End are the offsets of the word in the text, in UTF-16 code
+	// units so that they can be used as JavaScript string indexes.
+	Start int `json:"start"`
+	End   int `json:"end"`
+	// StartMs and EndMs are when the word is spoken, in milliseconds from the
+	// start of the audio.
+	StartMs int `json:"startMs"`
+	EndMs   int `json:"endMs"`
+}
+
+// Synthesizer converts text to speech. Implementations that cannot report
+// word timings should estimate them with Timings.
+type Synthesizer interface {
+	Synthesize(ctx context.Context, req *Request) (*Speech, error)
+}
+
+// Silent is a Synthesizer that does not need a model: it returns silence as
+// long as it would take to read the text aloud, with estimated word timings.
+// It is meant for offline development and tests.
+type Silent struct {
+	// WordsPerMinute is the reading speed. It defaults to 150.
+	WordsPerMinute int
+}
+
+// silentSampleRate is the sample rate of the 8-bit mono audio from Silent.
+const silentSampleRate = 8000
+
+func (s Silent) Synthesize(ctx context.Context, req *Request) (*Speech, error) {
+	wpm := s.WordsPerMinute
+	if wpm <= 0 {
+		wpm = 150
+	}
+	words := len(strings.Fields(req.Text))
+	d := time.Duration(words) * time.Minute / time.Duration(wpm)
+	// 8-bit PCM is unsigned, so silence is the midpoint.
+	pcm := bytes.Repeat([]byte{0x80}, int(d.Seconds()*silentSampleRate))
+	return &Speech{
+		ContentType: "audio/wav",
+		Data:        WAV(pcm, silentSampleRate, 1, 8),
+		Duration:    d,
+		Words:       Timings(req.Text, d),
+	}, nil
+}
+
+// Timings estimates when each word of text is spoken if reading it aloud
+// takes d. Every word gets time in proportion to its length, and punctuation
+// at the end of a word adds a pause after it.
+func Timings(text string, d time.Duration) []Word {
+	type span struct {
+		text       string
+		start, end int
+		weight     float64
+		pause      float64
+	}
+	var spans []span
+	var total float64
+	offset := 0 // in UTF-16 code units
+	start, startOffset := -1, 0
+	runes := []rune(text)
+	flush := func(i int) {
+		if start < 0 {
+			return
+		}
+		w := string(runes[start:i])
+		letters := 0
+		for _, r := range w {
+			if unicode.IsLetter(r) || unicode.IsDigit(r) {
+				letters++
+			}
+		}
+		sp := span{text: w, start: startOffset, end: offset, weight: 2 + float64(letters)}
+		switch runes[i-1] {
+		case ',', ';', ':':
+			sp.pause = 3
+		case '.', '!', '?':
+			sp.pause = 6
+		}
+		total += sp.weight + sp.pause
+		spans = append(spans, sp)
+		start = -1
+	}
+	for i, r := range runes {
+		if unicode.IsSpace(r) {
+			flush(i)
+		} else if start < 0 {
+			start, startOffset = i, offset
+		}
+		offset += utf16.RuneLen(r)
+	}
+	flush(len(runes))
+
+	words := make([]Word, len(spans))
+	if total == 0 {
+		return words
+	}
+	ms := float64(d.Milliseconds())
+	at := 0.0
+	for i, sp := range spans {
+		end := at + sp.weight/total*ms
+		words[i] = Word{Text: sp.text, Start: sp.start, End: sp.end, StartMs: int(at), EndMs: int(end)}
+		at = end + sp.pause/total*ms
+	}
+	return words
+}
+
+// WAV wraps raw little-endian PCM samples in a WAV container.
+func WAV(pcm []byte, sampleRate, channels, bitsPerSample int) []byte {
+	var b bytes.Buffer
+	blockAlign := channels * bitsPerSample / 8
+	b.WriteString("RIFF")
+	binary.Write(&b, binary.LittleEndian, uint32(36+len(pcm)))
+	b.WriteString("WAVEfmt ")
+	binary.Write(&b, binary.LittleEndian, uint32(16))
+	binary.Write(&b, binary.LittleEndian, uint16(1)) // PCM
+	binary.Write(&b, binary.LittleEndian, uint16(channels))
+	binary.Write(&b, binary.LittleEndian, uint32(sampleRate))
+	binary.Write(&b, binary.LittleEndian, uint32(sampleRate*blockAlign))
+	binary.Write(&b, binary.LittleEndian, uint16(blockAlign))
+	binary.Write(&b, binary.LittleEndian, uint16(bitsPerSample))
+	b.WriteString("data")
+	binary.Write(&b, binary.LittleEndian, uint32(len(pcm)))
+	b.Write(pcm)
+	return b.Bytes()
+}
+
+// FromPCM converts raw 16-bit PCM audio, such as the "audio/L16;rate=24000"
+// returned by Gemini speech models, to WAV and returns it with its duration.
+// The sample rate and channel count are read from the media type parameters.
+func FromPCM(contentType string, data []byte) ([]byte, time.Duration, error) {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid audio type %q: %w", contentType, err)
+	}
+	if mediaType != "audio/l16" && mediaType != "audio/pcm" {
+		return nil, 0, fmt.Errorf("unsupported audio type %q", contentType)
+	}
+	rate, channels := 24000, 1
+	if v, ok := params["rate"]; ok {
+		if rate, err = strconv.Atoi(v); err != nil || rate <= 0 {
+			return nil, 0, fmt.Errorf("invalid sample rate in %q", contentType)
+		}
+	}
+	if v, ok := params["channels"]; ok {
+		if channels, err = strconv.Atoi(v); err != nil || channels <= 0 {
+			return nil, 0, fmt.Errorf("invalid channel count in %q", contentType)
+		}
+	}
+	frames := len(data) / (2 * channels)
+	d := time.Duration(frames) * time.Second / time.Duration(rate)
+	return WAV(data, rate, channels, 16), d, nil
+}
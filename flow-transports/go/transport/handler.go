@@ -0,0 +1,188 @@
+package transport
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/firebase/genkit/go/core"
+)
+
+// HandlerOption configures Handler, SSEHandler and WebSocketHandler.
+type HandlerOption func(*handlerOptions)
+
+type handlerOptions struct {
+	contextProviders []core.ContextProvider
+	allowedOrigins   []string
+}
+
+func newHandlerOptions(opts []HandlerOption) *handlerOptions {
+	o := &handlerOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithContextProviders is the equivalent of genkit.WithContextProviders. The
+// providers are called with each request in the order given, and the flow
+// gets the action context they return from core.FromContext. If a provider
+// fails, the request is rejected with the status of the Genkit error it
+// returned, e.g. UNAUTHENTICATED, or INTERNAL.
+func WithContextProviders(providers ...core.ContextProvider) HandlerOption {
+	return func(o *handlerOptions) {
+		o.contextProviders = append(o.contextProviders, providers...)
+	}
+}
+
+// WithAllowedOrigins lets pages from the given origins, such as
+// "https://app.example.com", connect to WebSocketHandler. "*" allows any
+// origin. Without it, only pages from the server's own origin can connect,
+// and clients that send no Origin header, which are not browsers. The other
+// handlers leave cross-origin requests to CORS middleware and ignore it.
+func WithAllowedOrigins(origins ...string) HandlerOption {
+	return func(o *handlerOptions) {
+		o.allowedOrigins = append(o.allowedOrigins, origins...)
+	}
+}
+
+// Handler returns a drop-in replacement for genkit.Handler(flow) whose runs
+// can be cancelled. Like genkit.Handler, it takes the input from the "data"
+// field of the request body and returns {"result": output}, or streams
+// {"message": chunk} events then a {"result": output} or {"error": {...}}
+// event when the request accepts text/event-stream or has ?stream=true.
+//
+// Each run gets an ID, sent in the X-Run-Id response header before anything
+// else, which CancelHandler cancels. Errors of streamed runs keep their
+// status instead of always being INTERNAL, so a cancelled run ends with
+// {"error": {"status": "CANCELLED", "message": "run cancelled", ...}}.
+// Like genkit.Handler, it logs the errors of runs that did not fail because
+// of the request, such as INTERNAL errors, with slog.
+func Handler(flow Flow, opts ...HandlerOption) http.Handler {
+	o := newHandlerOptions(opts)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Data json.RawMessage `json:"data"`
+		}
+		if r.Body != nil {
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil && !errors.Is(err, io.EOF) {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		stream := r.Header.Get("Accept") == "text/event-stream"
+		if s := r.URL.Query().Get("stream"); s != "" {
+			b, err := strconv.ParseBool(s)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			stream = stream || b
+		}
+
+		ctx, err := o.actionContext(r.Context(), r, body.Data)
+		if err != nil {
+			logError(flow, "", err)
+			http.Error(w, err.Error(), core.HTTPStatusCode(NewFlowError(err).Status))
+			return
+		}
+
+		ctx, id, _, done := runs.start(ctx)
+		defer done()
+		w.Header().Set(RunIDHeader, id)
+
+		if !stream {
+			out, err := runFlow(ctx, flow, body.Data, nil)
+			if err != nil {
+				logError(flow, id, err)
+				http.Error(w, err.Error(), core.HTTPStatusCode(NewFlowError(err).Status))
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprintf(w, "{\"result\": %s}\n", out)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		write := func(payload any) error {
+			if _, err := fmt.Fprintf(w, "data: %s\n\n", mustMarshal(payload)); err != nil {
+				return err
+			}
+			flush(w)
+			return nil
+		}
+		// The headers, and the run ID with them, are sent right away rather
+		// than with the first chunk.
+		w.WriteHeader(http.StatusOK)
+		flush(w)
+		out, err := runFlow(ctx, flow, body.Data, func(ctx context.Context, chunk json.RawMessage) error {
+			return write(map[string]json.RawMessage{"message": chunk})
+		})
+		if err != nil {
+			logError(flow, id, err)
+			write(map[string]any{"error": NewFlowError(err)})
+			return
+		}
+		write(map[string]json.RawMessage{"result": out})
+	})
+}
+
+// actionContext returns ctx with the action context the context providers
+// return for r and the flow input, as genkit.Handler does.
+func (o *handlerOptions) actionContext(ctx context.Context, r *http.Request, input json.RawMessage) (context.Context, error) {
+	if len(o.contextProviders) == 0 {
+		return ctx, nil
+	}
+	headers := make(map[string]string, len(r.Header))
+	for k, v := range r.Header {
+		headers[strings.ToLower(k)] = strings.Join(v, " ")
+	}
+	for _, provide := range o.contextProviders {
+		actionCtx, err := provide(ctx, core.RequestData{Method: r.Method, Headers: headers, Input: input})
+		if err != nil {
+			return nil, err
+		}
+		if existing := core.FromContext(ctx); existing != nil {
+			merged := maps.Clone(existing)
+			maps.Copy(merged, actionCtx)
+			actionCtx = merged
+		}
+		ctx = core.WithActionContext(ctx, actionCtx)
+	}
+	return ctx, nil
+}
+
+// checkOrigin reports whether a WebSocket connection may be opened from the
+// origin of r.
+func (o *handlerOptions) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	for _, allowed := range o.allowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+// logError logs the error of a run of flow unless the request caused it,
+// i.e. unless its status is a client error or CANCELLED.
+func logError(flow Flow, runID string, err error) {
+	if core.HTTPStatusCode(NewFlowError(err).Status) < http.StatusInternalServerError {
+		return
+	}
+	slog.Error("flow failed", "flow", flow.Name(), "runId", runID, "err", err)
+}
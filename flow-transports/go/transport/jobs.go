@@ -0,0 +1,429 @@
+package transport
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/firebase/genkit/go/core"
+)
+
+const (
+	// maxJobChunks is how many of its latest chunks a job keeps.
+	maxJobChunks = 1000
+	// maxJobAttempts is how many times a job is started before it is failed,
+	// when the server keeps stopping while it runs.
+	maxJobAttempts = 3
+)
+
+// JobStatus is the state of a job.
+type JobStatus string
+
+const (
+	JobQueued    JobStatus = "queued"
+	JobRunning   JobStatus = "running"
+	JobSucceeded JobStatus = "succeeded"
+	JobFailed    JobStatus = "failed"
+	JobCancelled JobStatus = "cancelled"
+)
+
+// Done reports whether the job has finished, one way or another.
+func (s JobStatus) Done() bool {
+	return s == JobSucceeded || s == JobFailed || s == JobCancelled
+}
+
+// Job is a run of a flow started with the jobs API.
+type Job struct {
+	ID     string    `json:"id"`
+	Flow   string    `json:"flow"`
+	Status JobStatus `json:"status"`
+	// Input is kept so that the job can be run again if the server stops
+	// while it runs.
+	Input json.RawMessage `json:"input,omitempty"`
+	// Chunks are the latest chunks the flow has streamed, up to 1000, and
+	// ChunkCount the number it has streamed in all.
+	Chunks     []json.RawMessage `json:"chunks,omitempty"`
+	ChunkCount int               `json:"chunkCount"`
+	Result     json.RawMessage   `json:"result,omitempty"`
+	Error      *FlowError        `json:"error,omitempty"`
+	// Attempts is the number of times the job has been started.
+	Attempts    int              `json:"attempts"`
+	CallbackURL string           `json:"callbackUrl,omitempty"`
+	Webhook     *WebhookDelivery `json:"webhook,omitempty"`
+	CreatedAt   time.Time        `json:"createdAt"`
+	StartedAt   *time.Time       `json:"startedAt,omitempty"`
+	FinishedAt  *time.Time       `json:"finishedAt,omitempty"`
+}
+
+// public returns a copy of the job to send to clients, which shares nothing
+// with it. The input is left out, since it can be large and the client has
+// it.
+func (j *Job) public() *Job {
+	c := *j
+	c.Input = nil
+	c.Chunks = slices.Clone(j.Chunks)
+	if j.Webhook != nil {
+		w := *j.Webhook
+		c.Webhook = &w
+	}
+	return &c
+}
+
+// JobsOptions configures Jobs.
+type JobsOptions struct {
+	// Workers is how many jobs run at once. Defaults to 4.
+	Workers int
+	// Store keeps the jobs, so that they survive restarts. Without it, jobs
+	// are only kept in memory.
+	Store JobStore
+	// WebhookSecret signs the webhooks sent to callback URLs. Without it,
+	// jobs with a callback URL are refused.
+	WebhookSecret string
+	// Retention is how long finished jobs are kept. Defaults to 24 hours.
+	Retention time.Duration
+	// Client sends the webhooks. Defaults to a client with a 10-second
+	// timeout that does not follow redirects and only connects to public
+	// addresses, so that callback URLs cannot reach services on the server's
+	// own network.
+	Client *http.Client
+	// AllowPrivateCallbacks lets the default Client connect to loopback,
+	// private and link-local addresses, for local development.
+	AllowPrivateCallbacks bool
+}
+
+// Jobs runs flows asynchronously on a pool of workers, for runs that take
+// longer than clients or proxies wait for a response. It serves:
+//
+//   - POST .../jobs/{flow} with {"data": input, "callbackUrl": url} queues a
+//     run of the flow and answers 202 Accepted with the Job, whose ID is in
+//     the Location header.
+//   - GET .../jobs/{id} returns the Job: its status, the chunks it has
+//     streamed so far, and its result or error. With ?chunksFrom=n, only the
+//     chunks from the n-th on are included, so that pollers can pass the
+//     ChunkCount they last saw.
+//
+// Either path segment can also be given as the {flow} or {id} path value.
+// When a job with a callback URL finishes, the Job is posted to the URL as a
+// webhook signed with WebhookSecret. A job's ID is also its run ID, so
+// CancelHandler cancels queued and running jobs.
+//
+// Jobs that are queued or running when the server stops are run again from
+// the start when it restarts with the same Store, and webhooks that were not
+// delivered are sent again.
+type Jobs struct {
+	flows map[string]Flow
+	opts  JobsOptions
+
+	mu    sync.Mutex
+	jobs  map[string]*Job
+	queue []string
+	// wake has a value when there may be jobs in the queue.
+	wake chan struct{}
+}
+
+// NewJobs returns Jobs that run the given flows, loading the jobs in the
+// store and starting the workers.
+func NewJobs(opts JobsOptions, flows ...Flow) (*Jobs, error) {
+	if opts.Workers <= 0 {
+		opts.Workers = 4
+	}
+	if opts.Retention <= 0 {
+		opts.Retention = 24 * time.Hour
+	}
+	if opts.Client == nil {
+		opts.Client = webhookClient(opts.AllowPrivateCallbacks)
+	}
+	js := &Jobs{flows: map[string]Flow{}, opts: opts, jobs: map[string]*Job{}, wake: make(chan struct{}, 1)}
+	for _, f := range flows {
+		js.flows[f.Name()] = f
+	}
+
+	if opts.Store != nil {
+		stored, err := opts.Store.Load()
+		if err != nil {
+			return nil, fmt.Errorf("failed to load jobs: %w", err)
+		}
+		slices.SortFunc(stored, func(a, b *Job) int { return a.CreatedAt.Compare(b.CreatedAt) })
+		for _, job := range stored {
+			js.restore(job)
+		}
+	}
+	for range opts.Workers {
+		go js.work()
+	}
+	return js, nil
+}
+
+// restore takes over a job loaded from the store: unfinished jobs are queued
+// again, and finished ones have their webhook sent if it was not.
+func (js *Jobs) restore(job *Job) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	js.jobs[job.ID] = job
+	switch {
+	case job.Status.Done():
+		js.expire(job)
+		if job.Webhook != nil {
+			go js.deliver(job.ID)
+		}
+	case js.flows[job.Flow] == nil:
+		js.finish(job, nil, &FlowError{Status: core.NOT_FOUND, Message: "job aborted", Details: "the server no longer has the flow " + job.Flow})
+	case job.Attempts >= maxJobAttempts:
+		js.finish(job, nil, &FlowError{Status: core.ABORTED, Message: "job aborted", Details: fmt.Sprintf("the server stopped while the job ran %d times", job.Attempts)})
+	default:
+		job.Status = JobQueued
+		js.enqueue(job)
+	}
+}
+
+func (js *Jobs) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		js.submit(w, r)
+	case http.MethodGet:
+		js.get(w, r)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		writeError(w, http.StatusMethodNotAllowed, core.INVALID_ARGUMENT, "method not allowed")
+	}
+}
+
+// submit queues a job.
+func (js *Jobs) submit(w http.ResponseWriter, r *http.Request) {
+	name := pathParam(r, "flow")
+	if _, ok := js.flows[name]; !ok {
+		writeError(w, http.StatusNotFound, core.NOT_FOUND, "unknown flow "+name)
+		return
+	}
+	var body struct {
+		Data        json.RawMessage `json:"data"`
+		CallbackURL string          `json:"callbackUrl"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil && !errors.Is(err, io.EOF) {
+		writeError(w, http.StatusBadRequest, core.INVALID_ARGUMENT, "invalid request body: "+err.Error())
+		return
+	}
+	if body.CallbackURL != "" {
+		if js.opts.WebhookSecret == "" {
+			writeError(w, http.StatusBadRequest, core.FAILED_PRECONDITION, "callbacks are disabled because no webhook secret is configured")
+			return
+		}
+		if u, err := url.Parse(body.CallbackURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			writeError(w, http.StatusBadRequest, core.INVALID_ARGUMENT, "callbackUrl must be an http or https URL")
+			return
+		}
+	}
+
+	job := &Job{
+		ID:          newID(),
+		Flow:        name,
+		Status:      JobQueued,
+		Input:       body.Data,
+		CallbackURL: body.CallbackURL,
+		CreatedAt:   time.Now().UTC(),
+	}
+	if job.CallbackURL != "" {
+		job.Webhook = &WebhookDelivery{}
+	}
+	js.mu.Lock()
+	err := js.save(job)
+	if err == nil {
+		js.jobs[job.ID] = job
+		js.enqueue(job)
+	}
+	resp := job.public()
+	js.mu.Unlock()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, core.INTERNAL, "failed to save the job: "+err.Error())
+		return
+	}
+
+	w.Header().Set("Location", path.Join(path.Dir(r.URL.Path), job.ID))
+	writeJob(w, http.StatusAccepted, resp)
+}
+
+// get returns a job.
+func (js *Jobs) get(w http.ResponseWriter, r *http.Request) {
+	id := pathParam(r, "id")
+	from := 0
+	if s := r.URL.Query().Get("chunksFrom"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			writeError(w, http.StatusBadRequest, core.INVALID_ARGUMENT, "chunksFrom must be a number of chunks")
+			return
+		}
+		from = n
+	}
+
+	js.mu.Lock()
+	job, ok := js.jobs[id]
+	if ok {
+		job = job.public()
+	}
+	js.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, core.NOT_FOUND, "no job "+id)
+		return
+	}
+	// The chunks kept are the last ones, so the first of them is not the
+	// first one streamed once some have been dropped.
+	if skip := from - (job.ChunkCount - len(job.Chunks)); skip > 0 {
+		job.Chunks = job.Chunks[min(skip, len(job.Chunks)):]
+	}
+	writeJob(w, http.StatusOK, job)
+}
+
+// pathParam returns the path value with the given name, or else the last
+// segment of the path.
+func pathParam(r *http.Request, name string) string {
+	if v := r.PathValue(name); v != "" {
+		return v
+	}
+	return path.Base(r.URL.Path)
+}
+
+func writeJob(w http.ResponseWriter, code int, job *Job) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(job)
+}
+
+// enqueue adds a queued job to the queue and makes it cancellable. js.mu
+// must be held.
+func (js *Jobs) enqueue(job *Job) {
+	js.queue = append(js.queue, job.ID)
+	runs.add(job.ID, func(cause error) {
+		js.mu.Lock()
+		defer js.mu.Unlock()
+		switch job.Status {
+		case JobQueued:
+			js.finish(job, nil, NewFlowError(cause))
+		case JobRunning:
+			// The job started between Cancel finding this function and
+			// calling it, so the run is cancelled instead.
+			Cancel(job.ID)
+		}
+	})
+	js.signal()
+}
+
+func (js *Jobs) signal() {
+	select {
+	case js.wake <- struct{}{}:
+	default:
+	}
+}
+
+// work runs queued jobs, one at a time.
+func (js *Jobs) work() {
+	for {
+		js.run(js.next())
+	}
+}
+
+// next waits for a queued job, marks it as running and returns it with the
+// context to run it under and the function to call when it is done.
+func (js *Jobs) next() (context.Context, *Job, func()) {
+	for {
+		js.mu.Lock()
+		for len(js.queue) > 0 {
+			job := js.jobs[js.queue[0]]
+			js.queue = js.queue[1:]
+			if job == nil || job.Status != JobQueued {
+				// The job was cancelled while it was queued.
+				continue
+			}
+			if len(js.queue) > 0 {
+				js.signal()
+			}
+			// The run is registered before the lock is released, so that a
+			// cancellation cannot fall between the job being queued and
+			// running.
+			ctx, _, _, done := runs.startAs(context.Background(), job.ID)
+			now := time.Now().UTC()
+			job.Status = JobRunning
+			job.StartedAt = &now
+			job.Attempts++
+			job.Chunks, job.ChunkCount = nil, 0
+			js.save(job)
+			js.mu.Unlock()
+			return ctx, job, done
+		}
+		js.mu.Unlock()
+		<-js.wake
+	}
+}
+
+// run runs a job and records how it ended.
+func (js *Jobs) run(ctx context.Context, job *Job, done func()) {
+	defer done()
+	out, err := runFlow(ctx, js.flows[job.Flow], job.Input, func(ctx context.Context, chunk json.RawMessage) error {
+		js.mu.Lock()
+		defer js.mu.Unlock()
+		job.Chunks = append(job.Chunks, chunk)
+		if len(job.Chunks) > maxJobChunks {
+			job.Chunks = job.Chunks[len(job.Chunks)-maxJobChunks:]
+		}
+		job.ChunkCount++
+		return nil
+	})
+
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	var flowErr *FlowError
+	if err != nil {
+		flowErr = NewFlowError(err)
+	}
+	js.finish(job, out, flowErr)
+}
+
+// finish records the end of a job, saves it and sends its webhook. js.mu
+// must be held.
+func (js *Jobs) finish(job *Job, out json.RawMessage, flowErr *FlowError) {
+	now := time.Now().UTC()
+	job.FinishedAt = &now
+	job.Result, job.Error = out, flowErr
+	switch {
+	case flowErr == nil:
+		job.Status = JobSucceeded
+	case flowErr.Status == core.CANCELLED:
+		job.Status = JobCancelled
+	default:
+		job.Status = JobFailed
+	}
+	runs.remove(job.ID)
+	js.save(job)
+	js.expire(job)
+	if job.Webhook != nil {
+		go js.deliver(job.ID)
+	}
+}
+
+// expire removes a finished job once it is older than the retention period.
+func (js *Jobs) expire(job *Job) {
+	time.AfterFunc(time.Until(job.FinishedAt.Add(js.opts.Retention)), func() {
+		js.mu.Lock()
+		defer js.mu.Unlock()
+		delete(js.jobs, job.ID)
+		if js.opts.Store != nil {
+			js.opts.Store.Delete(job.ID)
+		}
+	})
+}
+
+// save stores a job, if there is a store. js.mu must be held.
+func (js *Jobs) save(job *Job) error {
+	if js.opts.Store == nil {
+		return nil
+	}
+	return js.opts.Store.Save(job)
+}
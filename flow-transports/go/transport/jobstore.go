@@ -0,0 +1,104 @@
+package transport
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// JobStore keeps the jobs of Jobs.
+type JobStore interface {
+	// Save stores a job, replacing the one with the same ID.
+	Save(job *Job) error
+	// Delete removes a job.
+	Delete(id string) error
+	// Load returns every job.
+	Load() ([]*Job, error)
+}
+
+// DirStore is a JobStore that keeps each job in a JSON file of a directory.
+type DirStore struct {
+	dir string
+}
+
+// NewDirStore returns a DirStore in dir, creating the directory if needed.
+func NewDirStore(dir string) (*DirStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create job directory: %w", err)
+	}
+	return &DirStore{dir: dir}, nil
+}
+
+var jobIDPattern = regexp.MustCompile(`^[0-9a-f]{16}$`)
+
+func (s *DirStore) path(id string) (string, error) {
+	if !jobIDPattern.MatchString(id) {
+		return "", fmt.Errorf("invalid job ID %q", id)
+	}
+	return filepath.Join(s.dir, id+".json"), nil
+}
+
+// Save writes the job to a temporary file and renames it into place, so that
+// a crash leaves either the old or the new version.
+func (s *DirStore) Save(job *Job) error {
+	p, err := s.path(job.ID)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(s.dir, ".job-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), p)
+}
+
+func (s *DirStore) Delete(id string) error {
+	p, err := s.path(id)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (s *DirStore) Load() ([]*Job, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	var jobs []*Job
+	for _, e := range entries {
+		id, ok := strings.CutSuffix(e.Name(), ".json")
+		if !ok || !jobIDPattern.MatchString(id) {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(s.dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		var job Job
+		if err := json.Unmarshal(data, &job); err != nil {
+			return nil, fmt.Errorf("%s: %w", e.Name(), err)
+		}
+		jobs = append(jobs, &job)
+	}
+	return jobs, nil
+}
@@ -0,0 +1,116 @@
+package transport
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"path"
+	"sync"
+
+	"github.com/firebase/genkit/go/core"
+)
+
+// RunIDHeader is the response header that carries the ID of a run started by
+// Handler.
+const RunIDHeader = "X-Run-Id"
+
+// ErrCancelled is the cause of the context of a run cancelled by a client.
+var ErrCancelled = errors.New("run cancelled")
+
+// runs are the runs in progress on every transport, by ID, so that
+// CancelHandler can cancel any of them.
+var runs = &registry{cancels: map[string]context.CancelCauseFunc{}}
+
+type registry struct {
+	mu      sync.Mutex
+	cancels map[string]context.CancelCauseFunc
+}
+
+// start returns a context for a new run under ctx, the run's ID and a
+// function to call when the run is done.
+func (r *registry) start(ctx context.Context) (context.Context, string, context.CancelCauseFunc, func()) {
+	return r.startAs(ctx, newID())
+}
+
+// startAs is start for a run with the given ID. It replaces any cancel
+// function registered for the ID, such as that of a queued job.
+func (r *registry) startAs(ctx context.Context, id string) (context.Context, string, context.CancelCauseFunc, func()) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	r.add(id, cancel)
+	return ctx, id, cancel, func() {
+		r.remove(id)
+		cancel(nil)
+	}
+}
+
+// add registers cancel as the way to cancel the run with the given ID until
+// it is removed.
+func (r *registry) add(id string, cancel context.CancelCauseFunc) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cancels[id] = cancel
+}
+
+func (r *registry) remove(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.cancels, id)
+}
+
+// StartRun registers work that does not run on one of the transports, such
+// as a background job, as the run with the given ID, so that Cancel and
+// CancelHandler can cancel it. It returns the context to do the work under,
+// which is cancelled with ErrCancelled, and a function to call when the work
+// is done.
+func StartRun(ctx context.Context, id string) (context.Context, func()) {
+	ctx, _, _, done := runs.startAs(ctx, id)
+	return ctx, done
+}
+
+// Cancel cancels the run in progress with the given ID, which then ends with
+// a CANCELLED error. It reports whether there was such a run.
+func Cancel(id string) bool {
+	runs.mu.Lock()
+	cancel, ok := runs.cancels[id]
+	runs.mu.Unlock()
+	if ok {
+		cancel(ErrCancelled)
+	}
+	return ok
+}
+
+// CancelHandler returns a handler for POST /runs/{id}/cancel, which cancels
+// the run with that ID, whichever transport it was started on. It answers
+// 204 No Content, or 404 Not Found if the run is unknown or already done.
+// The ID is taken from the {id} path value, or else from the path segment
+// before the last one, so the handler can be mounted on any router.
+func CancelHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			writeError(w, http.StatusMethodNotAllowed, core.INVALID_ARGUMENT, "method not allowed")
+			return
+		}
+		id := r.PathValue("id")
+		if id == "" {
+			id = path.Base(path.Dir(r.URL.Path))
+		}
+		if !Cancel(id) {
+			writeError(w, http.StatusNotFound, core.NOT_FOUND, "no run "+id+" in progress")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// runFlow runs flow under ctx. A run whose context is cancelled fails with the
+// cause of the cancellation, even if the flow returned first or returned
+// another error, so that it always ends as CANCELLED.
+func runFlow(ctx context.Context, flow Flow, input json.RawMessage, cb core.StreamCallback[json.RawMessage]) (json.RawMessage, error) {
+	out, err := flow.RunJSON(ctx, input, cb)
+	if ctx.Err() != nil {
+		return nil, context.Cause(ctx)
+	}
+	return out, err
+}
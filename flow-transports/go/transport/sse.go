@@ -0,0 +1,307 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/firebase/genkit/go/core"
+)
+
+const (
+	// retention is how long a finished run is kept for clients to resume.
+	retention = 5 * time.Minute
+	// abandonAfter is how long a run goes on without any client before it
+	// is cancelled.
+	abandonAfter = 30 * time.Second
+	// keepAlive is how often a comment is sent on idle streams, so that
+	// proxies do not close them.
+	keepAlive = 15 * time.Second
+	// retryMillis is the reconnection delay suggested to EventSource.
+	retryMillis = 1000
+)
+
+// event is a server-sent event of a run.
+type event struct {
+	// name is "" for chunks, which EventSource delivers as messages,
+	// "result" or "failure".
+	name string
+	data []byte
+}
+
+// sseRun is a run of a flow whose events are kept so that clients can
+// resume it.
+type sseRun struct {
+	id     string
+	cancel context.CancelCauseFunc
+
+	mu     sync.Mutex
+	events []event
+	done   bool
+	// changed is closed and replaced whenever events are added.
+	changed     chan struct{}
+	subscribers int
+	abandon     *time.Timer
+}
+
+func (r *sseRun) add(name string, payload any) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		name, data = "failure", mustMarshal(map[string]any{"error": NewFlowError(err)})
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.done {
+		return
+	}
+	r.events = append(r.events, event{name, data})
+	r.done = name != ""
+	close(r.changed)
+	r.changed = make(chan struct{})
+}
+
+// next returns the events from the from-th on, whether the run is done and a
+// channel closed when there are more.
+func (r *sseRun) next(from int) ([]event, bool, <-chan struct{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if from > len(r.events) {
+		from = len(r.events)
+	}
+	return r.events[from:], r.done, r.changed
+}
+
+// subscribe counts a client in, and returns a function that counts it out.
+// A run that is left without clients for abandonAfter is cancelled.
+func (r *sseRun) subscribe() (unsubscribe func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.subscribers++
+	if r.abandon != nil {
+		r.abandon.Stop()
+		r.abandon = nil
+	}
+	return func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if r.subscribers--; r.subscribers == 0 && !r.done {
+			r.abandon = time.AfterFunc(abandonAfter, func() { r.cancel(nil) })
+		}
+	}
+}
+
+type sseHandler struct {
+	flow Flow
+	opts *handlerOptions
+
+	mu   sync.Mutex
+	runs map[string]*sseRun
+}
+
+// SSEHandler returns a handler that runs flow and streams it as server-sent
+// events. The input is the "data" field of a POST body, as for
+// genkit.Handler, or the JSON in the "data" query parameter of a GET.
+//
+// Chunks are sent as "message" events with data {"message": chunk}, then the
+// output as a "result" event with data {"result": output}, or the error as a
+// "failure" event with data {"error": {"status", "message", "details"}}.
+// Every event has an ID. A request with a Last-Event-ID header, or a
+// lastEventId query parameter, resumes the run the ID belongs to after that
+// event instead of starting a new one; if there is nothing left to send, the
+// response is 204 No Content, which tells EventSource to stop reconnecting.
+//
+// Runs go on while their clients reconnect, and are cancelled after 30s
+// without any. Finished runs can be resumed for 5 minutes.
+//
+// The context providers of WithContextProviders are called with every
+// request, including those that resume a run, which fail with the
+// provider's error.
+func SSEHandler(flow Flow, opts ...HandlerOption) http.Handler {
+	return &sseHandler{flow: flow, opts: newHandlerOptions(opts), runs: map[string]*sseRun{}}
+}
+
+func (h *sseHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		w.Header().Set("Allow", "GET, POST")
+		writeError(w, http.StatusMethodNotAllowed, core.INVALID_ARGUMENT, "method not allowed")
+		return
+	}
+
+	input, err := readInput(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, core.INVALID_ARGUMENT, err.Error())
+		return
+	}
+	ctx, err := h.opts.actionContext(r.Context(), r, input)
+	if err != nil {
+		logError(h.flow, "", err)
+		ferr := NewFlowError(err)
+		writeError(w, core.HTTPStatusCode(ferr.Status), ferr.Status, ferr.Message)
+		return
+	}
+
+	if last := lastEventID(r); last != "" {
+		runID, seq, ok := parseEventID(last)
+		h.mu.Lock()
+		run := h.runs[runID]
+		h.mu.Unlock()
+		if !ok || run == nil {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		if events, done, _ := run.next(seq + 1); done && len(events) == 0 {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		h.stream(w, r, run, seq+1)
+		return
+	}
+
+	h.stream(w, r, h.start(ctx, input), 0)
+}
+
+// lastEventID returns the ID of the last event the client got, if it is
+// resuming.
+func lastEventID(r *http.Request) string {
+	if id := r.Header.Get("Last-Event-ID"); id != "" {
+		return id
+	}
+	return r.URL.Query().Get("lastEventId")
+}
+
+// parseEventID splits an event ID into its run ID and sequence number.
+func parseEventID(id string) (string, int, bool) {
+	runID, seq, ok := strings.Cut(id, ":")
+	n, err := strconv.Atoi(seq)
+	return runID, n, ok && err == nil && n >= 0
+}
+
+// readInput returns the flow input of a request.
+func readInput(r *http.Request) (json.RawMessage, error) {
+	if r.Method == http.MethodGet {
+		data := r.URL.Query().Get("data")
+		if data == "" {
+			return nil, nil
+		}
+		if !json.Valid([]byte(data)) {
+			return nil, fmt.Errorf("the data query parameter is not valid JSON")
+		}
+		return json.RawMessage(data), nil
+	}
+	var body struct {
+		Data json.RawMessage `json:"data"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("invalid request body: %v", err)
+	}
+	return body.Data, nil
+}
+
+// start runs the flow in the background and returns the run. The run is not
+// tied to ctx, so that it survives the client reconnecting, but keeps its
+// values. Its ID is also the one CancelHandler cancels it with.
+func (h *sseHandler) start(ctx context.Context, input json.RawMessage) *sseRun {
+	ctx, id, cancel, done := runs.start(context.WithoutCancel(ctx))
+	run := &sseRun{id: id, cancel: cancel, changed: make(chan struct{})}
+	h.mu.Lock()
+	h.runs[run.id] = run
+	h.mu.Unlock()
+
+	go func() {
+		defer done()
+		out, err := runFlow(ctx, h.flow, input, func(ctx context.Context, chunk json.RawMessage) error {
+			run.add("", map[string]json.RawMessage{"message": chunk})
+			return nil
+		})
+		if err != nil {
+			run.add("failure", map[string]any{"error": NewFlowError(err)})
+		} else {
+			run.add("result", map[string]json.RawMessage{"result": out})
+		}
+		time.AfterFunc(retention, func() {
+			h.mu.Lock()
+			delete(h.runs, run.id)
+			h.mu.Unlock()
+		})
+	}()
+	return run
+}
+
+// stream writes the events of run from the from-th on until it is done or
+// the client goes away.
+func (h *sseHandler) stream(w http.ResponseWriter, r *http.Request, run *sseRun, from int) {
+	unsubscribe := run.subscribe()
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: %d\n\n", retryMillis)
+	flush(w)
+
+	ticker := time.NewTicker(keepAlive)
+	defer ticker.Stop()
+	for {
+		events, done, changed := run.next(from)
+		for i, e := range events {
+			if err := writeEvent(w, fmt.Sprintf("%s:%d", run.id, from+i), e); err != nil {
+				return
+			}
+		}
+		from += len(events)
+		flush(w)
+		if done {
+			return
+		}
+		select {
+		case <-changed:
+		case <-ticker.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			flush(w)
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func writeEvent(w http.ResponseWriter, id string, e event) error {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "id: %s\n", id)
+	if e.name != "" {
+		fmt.Fprintf(&b, "event: %s\n", e.name)
+	}
+	fmt.Fprintf(&b, "data: %s\n\n", e.data)
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+func flush(w http.ResponseWriter) {
+	http.NewResponseController(w).Flush()
+}
+
+// writeError writes a JSON error response in genkit.Handler's format.
+func writeError(w http.ResponseWriter, code int, status core.StatusName, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]any{"error": map[string]any{"status": status, "message": message}})
+}
+
+func mustMarshal(v any) []byte {
+	data, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
@@ -0,0 +1,73 @@
+// Package transport serves streaming Genkit flows over HTTP in ways whose runs
+// can be cancelled:
+//
+//   - Handler is a drop-in replacement for genkit.Handler, with the same
+//     request and response formats.
+//   - SSEHandler serves one flow as a standard text/event-stream, over GET
+//     (for EventSource) or POST. Events have IDs, and a client that
+//     reconnects with Last-Event-ID resumes the run where it left off.
+//   - WebSocketHandler serves a set of flows over WebSocket connections, each
+//     of which can run several flows at once and cancel them.
+//   - Jobs runs flows in the background for clients that poll for the
+//     result or get it in a signed webhook, and keeps the jobs across
+//     restarts.
+//
+// All of them send the same payloads as genkit.Handler's streaming format, so
+// the chunks, results and errors of a flow look the same on every transport.
+// Every run gets an ID, and CancelHandler serves POST /runs/{id}/cancel to
+// cancel a run by its ID whichever transport it was started on. Cancelled
+// runs end with a CANCELLED error.
+package transport
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+
+	"github.com/firebase/genkit/go/core"
+)
+
+// Flow is a flow that can be run with JSON input and streamed, such as the
+// *core.Flow returned by genkit.DefineStreamingFlow.
+type Flow interface {
+	Name() string
+	RunJSON(ctx context.Context, input json.RawMessage, cb core.StreamCallback[json.RawMessage]) (json.RawMessage, error)
+}
+
+// FlowError is the error payload of a failed run, as in genkit.Handler's
+// streaming format.
+type FlowError struct {
+	Status  core.StatusName `json:"status"`
+	Message string          `json:"message"`
+	Details string          `json:"details,omitempty"`
+}
+
+// NewFlowError returns the payload for err, with the status of the Genkit
+// error it wraps, if any. Cancelled runs get the CANCELLED status and their
+// own message, so that clients can tell them from failures. Other servers of
+// flows can use it to report errors as the transports do.
+func NewFlowError(err error) *FlowError {
+	status := core.INTERNAL
+	var ufErr *core.UserFacingError
+	var gErr *core.GenkitError
+	switch {
+	case errors.As(err, &ufErr):
+		status = ufErr.Status
+	case errors.As(err, &gErr):
+		status = gErr.Status
+	case errors.Is(err, ErrCancelled), errors.Is(err, context.Canceled):
+		return &FlowError{Status: core.CANCELLED, Message: "run cancelled", Details: err.Error()}
+	case errors.Is(err, context.DeadlineExceeded):
+		status = core.DEADLINE_EXCEEDED
+	}
+	return &FlowError{Status: status, Message: "stream flow error", Details: err.Error()}
+}
+
+// newID returns a random ID for a run.
+func newID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,173 @@
+package transport
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// maxWebhookAttempts is how many times a webhook is sent before giving up.
+const maxWebhookAttempts = 5
+
+// WebhookDelivery is the state of the webhook of a job with a callback URL.
+type WebhookDelivery struct {
+	Delivered bool `json:"delivered"`
+	// Attempts is the number of times the webhook has been sent.
+	Attempts  int    `json:"attempts"`
+	LastError string `json:"lastError,omitempty"`
+}
+
+// webhookClient returns the default client for webhooks. Unless
+// allowPrivate is set, it refuses to connect to addresses that are not
+// public. The check is made on the address actually dialled, after DNS
+// resolution, so a public name that resolves to a private address is refused
+// too, and no proxy is used, since it would make the connection instead.
+func webhookClient(allowPrivate bool) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if !allowPrivate {
+		dialer := &net.Dialer{Timeout: 10 * time.Second, Control: publicOnly}
+		transport.Proxy = nil
+		transport.DialContext = dialer.DialContext
+	}
+	return &http.Client{
+		Transport: transport,
+		Timeout:   10 * time.Second,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// sharedAddressSpace is the carrier-grade NAT range, which is not public
+// either.
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// publicOnly is a net.Dialer Control function that refuses loopback,
+// private, link-local, multicast and unspecified addresses.
+func publicOnly(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return err
+	}
+	ip = ip.Unmap()
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() || sharedAddressSpace.Contains(ip) {
+		return fmt.Errorf("callback address %s is not public", ip)
+	}
+	return nil
+}
+
+// Failed reports whether the webhook was given up on.
+func (d *WebhookDelivery) Failed() bool {
+	return !d.Delivered && d.Attempts >= maxWebhookAttempts
+}
+
+// deliver posts the webhook of a finished job to its callback URL until a
+// 2xx response, waiting 1, 2, 4 then 8 seconds between attempts.
+func (js *Jobs) deliver(id string) {
+	for {
+		js.mu.Lock()
+		job, ok := js.jobs[id]
+		if !ok || job.Webhook.Delivered || job.Webhook.Failed() {
+			js.mu.Unlock()
+			return
+		}
+		attempt := job.Webhook.Attempts
+		payload := job.public()
+		payload.Chunks = nil
+		js.mu.Unlock()
+
+		if attempt > 0 {
+			time.Sleep(time.Second << (attempt - 1))
+		}
+		err := js.post(payload)
+
+		js.mu.Lock()
+		job.Webhook.Attempts++
+		if err != nil {
+			job.Webhook.LastError = err.Error()
+		} else {
+			job.Webhook.Delivered, job.Webhook.LastError = true, ""
+		}
+		js.save(job)
+		js.mu.Unlock()
+	}
+}
+
+// post sends a webhook with the finished job.
+func (js *Jobs) post(job *Job) error {
+	body, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, job.CallbackURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Webhook-Id", job.ID)
+	req.Header.Set("Webhook-Timestamp", timestamp)
+	req.Header.Set("Webhook-Signature", "v1,"+signWebhook(js.opts.WebhookSecret, job.ID, timestamp, body))
+	resp, err := js.opts.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("callback returned %s", resp.Status)
+	}
+	return nil
+}
+
+// signWebhook returns the signature of a webhook as in the Standard Webhooks
+// specification: the base64 HMAC-SHA256 of "id.timestamp.body". A secret
+// with the "whsec_" prefix is base64, as the specification's secrets are.
+func signWebhook(secret, id, timestamp string, body []byte) string {
+	key := []byte(secret)
+	if b64, ok := strings.CutPrefix(secret, "whsec_"); ok {
+		if decoded, err := base64.StdEncoding.DecodeString(b64); err == nil {
+			key = decoded
+		}
+	}
+	mac := hmac.New(sha256.New, key)
+	fmt.Fprintf(mac, "%s.%s.", id, timestamp)
+	mac.Write(body)
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyWebhook checks the signature of a webhook sent by Jobs with the given
+// secret, and that it was sent within tolerance of now, so that it cannot be
+// replayed later.
+func VerifyWebhook(secret string, header http.Header, body []byte, tolerance time.Duration) error {
+	id, timestamp := header.Get("Webhook-Id"), header.Get("Webhook-Timestamp")
+	sent, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errors.New("missing or invalid Webhook-Timestamp header")
+	}
+	if age := time.Since(time.Unix(sent, 0)); age > tolerance || age < -tolerance {
+		return errors.New("webhook timestamp is too far from now")
+	}
+	want := signWebhook(secret, id, timestamp, body)
+	for _, sig := range strings.Fields(header.Get("Webhook-Signature")) {
+		if v1, ok := strings.CutPrefix(sig, "v1,"); ok && hmac.Equal([]byte(v1), []byte(want)) {
+			return nil
+		}
+	}
+	return errors.New("invalid webhook signature")
+}
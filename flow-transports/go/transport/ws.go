@@ -0,0 +1,230 @@
+package transport
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/firebase/genkit/go/core"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// maxRuns is how many runs a WebSocket connection may have at once.
+	maxRuns = 16
+	// pingPeriod is how often connections are pinged, and pongWait how long
+	// a connection may go without answering.
+	pingPeriod = 30 * time.Second
+	pongWait   = 60 * time.Second
+	writeWait  = 10 * time.Second
+	// maxMessageSize limits the size of client messages.
+	maxMessageSize = 1 << 20
+)
+
+// wsRequest is a message from the client: "run" starts a flow, "cancel"
+// cancels a run.
+type wsRequest struct {
+	Type string `json:"type"`
+	// ID is chosen by the client to tell its runs apart. It must be unique
+	// among the connection's current runs.
+	ID   string          `json:"id"`
+	Flow string          `json:"flow,omitempty"`
+	Data json.RawMessage `json:"data,omitempty"`
+}
+
+// wsResponse is a message to the client: that a run has "started", a
+// "chunk", the "result" or an "error" of a run. Errors of messages that
+// cannot be tied to a run have an empty ID.
+type wsResponse struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+	// RunID is the ID that CancelHandler cancels the run with, sent when
+	// the run has started.
+	RunID   string          `json:"runId,omitempty"`
+	Message json.RawMessage `json:"message,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *FlowError      `json:"error,omitempty"`
+}
+
+// WebSocketHandler returns a handler that serves flows over WebSocket. Each
+// message is a JSON object. The client starts a run with
+//
+//	{"type": "run", "id": "1", "flow": "bargainChefFlow", "data": {...}}
+//
+// and the server answers with
+//
+//	{"type": "started", "id": "1", "runId": "5f0c3a9e1b2d4c68"}
+//
+// then any number of
+//
+//	{"type": "chunk", "id": "1", "message": {...}}
+//
+// followed by {"type": "result", "id": "1", "result": {...}} or
+// {"type": "error", "id": "1", "error": {"status", "message", "details"}}.
+// Up to 16 runs can go on at once on a connection, and their messages are
+// interleaved. {"type": "cancel", "id": "1"} cancels a run, which then ends
+// with a CANCELLED error. Closing the connection cancels all of its runs.
+//
+// Browsers can only connect from the origins of WithAllowedOrigins, or the
+// server's own. The context providers of WithContextProviders are called
+// with the upgrade request and the input of each run, and a run they reject
+// ends with their error.
+func WebSocketHandler(flows []Flow, opts ...HandlerOption) http.Handler {
+	o := newHandlerOptions(opts)
+	upgrader := websocket.Upgrader{CheckOrigin: o.checkOrigin}
+	byName := map[string]Flow{}
+	for _, f := range flows {
+		byName[f.Name()] = f
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			// The upgrader has already written an error response.
+			return
+		}
+		c := &wsConn{conn: conn, req: r, opts: o, flows: byName, runs: map[string]context.CancelCauseFunc{}}
+		c.serve(r.Context())
+	})
+}
+
+// wsConn is a WebSocket connection and its runs.
+type wsConn struct {
+	conn *websocket.Conn
+	// req is the upgrade request, which the context providers are called
+	// with.
+	req   *http.Request
+	opts  *handlerOptions
+	flows map[string]Flow
+
+	// writeMu serializes writes, since a connection supports one writer at a
+	// time. Pings are control messages, which need not be serialized.
+	writeMu sync.Mutex
+
+	mu   sync.Mutex
+	runs map[string]context.CancelCauseFunc
+	wg   sync.WaitGroup
+}
+
+func (c *wsConn) serve(ctx context.Context) {
+	// Closing the connection cancels the runs.
+	ctx, cancel := context.WithCancel(ctx)
+	defer func() {
+		cancel()
+		c.wg.Wait()
+		c.conn.Close()
+	}()
+
+	c.conn.SetReadLimit(maxMessageSize)
+	c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+	go c.ping(ctx)
+
+	for {
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		var req wsRequest
+		if err := json.Unmarshal(data, &req); err != nil {
+			c.send(wsResponse{Type: "error", Error: &FlowError{Status: core.INVALID_ARGUMENT, Message: "invalid message: " + err.Error()}})
+			continue
+		}
+		switch req.Type {
+		case "run":
+			c.run(ctx, req)
+		case "cancel":
+			c.mu.Lock()
+			if cancel, ok := c.runs[req.ID]; ok {
+				cancel(ErrCancelled)
+			}
+			c.mu.Unlock()
+		default:
+			c.send(wsResponse{Type: "error", ID: req.ID, Error: &FlowError{Status: core.INVALID_ARGUMENT, Message: `type must be "run" or "cancel", got "` + req.Type + `"`}})
+		}
+	}
+}
+
+// run starts a run of the requested flow.
+func (c *wsConn) run(ctx context.Context, req wsRequest) {
+	fail := func(status core.StatusName, message string) {
+		c.send(wsResponse{Type: "error", ID: req.ID, Error: &FlowError{Status: status, Message: message}})
+	}
+	flow, ok := c.flows[req.Flow]
+	if !ok {
+		fail(core.NOT_FOUND, "unknown flow "+req.Flow)
+		return
+	}
+	if req.ID == "" {
+		fail(core.INVALID_ARGUMENT, "a run needs an id")
+		return
+	}
+	ctx, err := c.opts.actionContext(ctx, c.req, req.Data)
+	if err != nil {
+		logError(flow, "", err)
+		c.send(wsResponse{Type: "error", ID: req.ID, Error: NewFlowError(err)})
+		return
+	}
+
+	c.mu.Lock()
+	if _, ok := c.runs[req.ID]; ok {
+		c.mu.Unlock()
+		fail(core.ALREADY_EXISTS, "a run with id "+req.ID+" is already going on")
+		return
+	}
+	if len(c.runs) >= maxRuns {
+		c.mu.Unlock()
+		fail(core.RESOURCE_EXHAUSTED, "too many runs at once")
+		return
+	}
+	ctx, runID, cancel, done := runs.start(ctx)
+	c.runs[req.ID] = cancel
+	c.mu.Unlock()
+
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		defer func() {
+			c.mu.Lock()
+			delete(c.runs, req.ID)
+			c.mu.Unlock()
+			done()
+		}()
+		c.send(wsResponse{Type: "started", ID: req.ID, RunID: runID})
+		out, err := runFlow(ctx, flow, req.Data, func(ctx context.Context, chunk json.RawMessage) error {
+			return c.send(wsResponse{Type: "chunk", ID: req.ID, Message: chunk})
+		})
+		if err != nil {
+			c.send(wsResponse{Type: "error", ID: req.ID, Error: NewFlowError(err)})
+			return
+		}
+		c.send(wsResponse{Type: "result", ID: req.ID, Result: out})
+	}()
+}
+
+func (c *wsConn) send(resp wsResponse) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+	return c.conn.WriteJSON(resp)
+}
+
+// ping pings the client until ctx is done, so that dead connections are
+// noticed.
+func (c *wsConn) ping(ctx context.Context) {
+	ticker := time.NewTicker(pingPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeWait)); err != nil {
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,367 @@
+// Package bargainchef holds the domain types, the getIngredientsOnSale tool
+// and the bargainChefFlow shared by the Go backend framework quickstarts, so
+// that each quickstart only has to wire the flow into its framework.
+package bargainchef
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+	_ "time/tzdata"
+
+	"example/bargainchef/pricing"
+	"flow-transports/go/transport"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/core"
+	"github.com/firebase/genkit/go/genkit"
+	"github.com/firebase/genkit/go/plugins/googlegenai"
+	"google.golang.org/genai"
+)
+
+type SaleQuery struct {
+	Store string `json:"store,omitempty" jsonschema:"description=Only return sales at this store"`
+}
+
+// SalesOnDay is what getIngredientsOnSale returns: the sales on the day the
+// user is cooking. The day is worked out by the flow from the user's time
+// zone, not chosen by the model.
+type SalesOnDay struct {
+	Date    string         `json:"date" jsonschema:"description=The day of the sales (YYYY-MM-DD)"`
+	Weekday string         `json:"weekday" jsonschema:"description=The day of the week"`
+	DayType string         `json:"dayType" jsonschema:"enum=weekday,enum=weekend,description=Whether the day is a weekday or on the weekend"`
+	Sales   []pricing.Sale `json:"sales" jsonschema:"description=The ingredients on sale"`
+}
+
+type RecipeIngredient struct {
+	Name     string  `json:"name" jsonschema:"description=Ingredient name"`
+	Quantity string  `json:"quantity" jsonschema:"description=Amount needed (e.g. '2 cups', '1 lb')"`
+	Amount   float64 `json:"amount" jsonschema:"description=The amount needed as a number, in unit (e.g. 2 for '2 cups')"`
+	Unit     string  `json:"unit" jsonschema:"enum=g,enum=kg,enum=100g,enum=oz,enum=lb,enum=ml,enum=l,enum=tsp,enum=tbsp,enum=cup,enum=each,enum=dozen,enum=clove,enum=head,enum=bunch,enum=pack,enum=can,enum=bottle,description=The unit of amount"`
+	OnSale   bool    `json:"onSale" jsonschema:"description=True if this ingredient is in the sale list"`
+}
+
+type Recipe struct {
+	Title       string             `json:"title" jsonschema:"description=Recipe title"`
+	Description string             `json:"description" jsonschema:"description=Short description of the dish"`
+	Servings    int                `json:"servings" jsonschema:"description=Number of servings"`
+	Ingredients []RecipeIngredient `json:"ingredients" jsonschema:"description=The ingredient list"`
+	Steps       []string           `json:"steps" jsonschema:"description=The ordered preparation steps"`
+}
+
+// PricedRecipe is a recipe with the cost of its ingredients. The shopping
+// list is worked out from the sale catalog in Go, not by the model, and
+// OnSale is set from it.
+type PricedRecipe struct {
+	Recipe
+	ShoppingList pricing.ShoppingList `json:"shoppingList"`
+	// Repairs lists the changes made to the model's recipe to fit the
+	// constraints, if any.
+	Repairs []string `json:"repairs,omitempty"`
+}
+
+type CravingInput struct {
+	Craving string `json:"craving" jsonschema:"description=What the user feels like eating right now" validate:"required"`
+	Localization
+	Constraints
+}
+
+// Localization is where the user is. Their time zone decides which day's
+// sales apply, and their locale how prices and quantities are shown.
+type Localization struct {
+	TimeZone string `json:"timeZone,omitempty" jsonschema:"description=The user's IANA time zone (e.g. America/New_York); defaults to the server's"`
+	Locale   string `json:"locale,omitempty" jsonschema:"description=The user's BCP 47 locale (e.g. en-US or fr-FR) to show prices and quantities in; defaults to en-US"`
+}
+
+// resolve returns the user's time zone and locale.
+func (l *Localization) resolve() (*time.Location, pricing.Locale, error) {
+	loc := time.Local
+	if l.TimeZone != "" {
+		var err error
+		if loc, err = time.LoadLocation(l.TimeZone); err != nil {
+			return nil, pricing.Locale{}, core.NewError(core.INVALID_ARGUMENT, "unknown time zone %q", l.TimeZone)
+		}
+	}
+	locale := pricing.DefaultLocale
+	if l.Locale != "" {
+		var err error
+		if locale, err = pricing.ParseLocale(l.Locale); err != nil {
+			return nil, pricing.Locale{}, core.NewError(core.INVALID_ARGUMENT, "%s", err.Error())
+		}
+	}
+	return loc, locale, nil
+}
+
+// saleDay is the day getIngredientsOnSale looks up sales for and the locale
+// to show them in. The flows put it in the context of the generate calls.
+type saleDay struct {
+	date   time.Time
+	locale pricing.Locale
+}
+
+type saleDayKey struct{}
+
+// maxRepairs is how many times the model is asked to fix a recipe that breaks
+// the constraints before the flow gives up.
+const maxRepairs = 2
+
+// FakeModel is the value of BARGAINCHEF_MODEL that selects the offline model.
+const FakeModel = "fake"
+
+// Init initializes Genkit with the model named by the BARGAINCHEF_MODEL
+// environment variable, which defaults to googleai/gemini-flash-latest. If it
+// is FakeModel, a deterministic offline model is used instead and no API key
+// is needed.
+func Init(ctx context.Context) *genkit.Genkit {
+	if os.Getenv("BARGAINCHEF_MODEL") == FakeModel {
+		g := genkit.Init(ctx)
+		defineFakeModel(g)
+		return g
+	}
+	return genkit.Init(ctx,
+		genkit.WithPlugins(&googlegenai.GoogleAI{}),
+	)
+}
+
+// modelName returns the name of the model selected by BARGAINCHEF_MODEL.
+func modelName() string {
+	switch model := os.Getenv("BARGAINCHEF_MODEL"); model {
+	case "":
+		return "googleai/gemini-flash-latest"
+	case FakeModel:
+		return fakeModelName
+	default:
+		return model
+	}
+}
+
+// LoadCatalog opens the pricing catalog in the JSON file named by the
+// BARGAINCHEF_CATALOG environment variable. Without it, the built-in sample
+// flyers are used and changes are not saved.
+func LoadCatalog() (*pricing.Catalog, error) {
+	return pricing.Open(os.Getenv("BARGAINCHEF_CATALOG"))
+}
+
+// AdminHandler returns the catalog's admin API, to be mounted at /admin/. It
+// is protected by the BARGAINCHEF_ADMIN_TOKEN environment variable, and
+// disabled if it is unset.
+func AdminHandler(catalog *pricing.Catalog) http.Handler {
+	return catalog.AdminHandler(os.Getenv("BARGAINCHEF_ADMIN_TOKEN"))
+}
+
+// Jobs returns the jobs API for the flows, to be mounted at /jobs/. Jobs are
+// kept in the directory named by the BARGAINCHEF_JOBS_DIR environment
+// variable, so that they survive restarts, or only in memory without it.
+// Callback URLs are accepted if BARGAINCHEF_WEBHOOK_SECRET is set, and the
+// webhooks are signed with it. They may only point to local addresses if
+// BARGAINCHEF_ALLOW_PRIVATE_CALLBACKS is set to true.
+func Jobs(flows ...transport.Flow) (*transport.Jobs, error) {
+	opts := transport.JobsOptions{WebhookSecret: os.Getenv("BARGAINCHEF_WEBHOOK_SECRET")}
+	opts.AllowPrivateCallbacks = os.Getenv("BARGAINCHEF_ALLOW_PRIVATE_CALLBACKS") == "true"
+	if dir := os.Getenv("BARGAINCHEF_JOBS_DIR"); dir != "" {
+		store, err := transport.NewDirStore(dir)
+		if err != nil {
+			return nil, err
+		}
+		opts.Store = store
+	}
+	return transport.NewJobs(opts, flows...)
+}
+
+// Addr returns the address to listen on: ":" followed by the PORT environment
+// variable, or ":8080".
+func Addr() string {
+	if port := os.Getenv("PORT"); port != "" {
+		return ":" + port
+	}
+	return ":8080"
+}
+
+// recipeInstructions tells the model how to answer once it has looked up the
+// sales, with quantities in the units of the locale.
+func recipeInstructions(l pricing.Locale) string {
+	units := "metric units (g, kg, ml, l)"
+	if l.Imperial() {
+		units = "US customary units (oz, lb, tsp, tbsp, cup)"
+	}
+	return `Then propose ONE recipe that takes advantage of those deals. For each ingredient, give the amount as a number with a unit as well as the quantity as text, preferring ` + units + ` for weights and volumes, and set onSale=true if it appears in the tool's response, false otherwise.`
+}
+
+// DefineFlow defines the getIngredientsOnSale tool, which looks up sales in
+// catalog, and the streaming bargainChefFlow, which proposes a recipe for a
+// craving that makes the most of today's sales and prices its shopping list.
+// Today is the date in the user's time zone, and prices and quantities are
+// shown in their locale.
+// Partial recipes are streamed as they are generated. A recipe that breaks the
+// input's constraints is sent back to the model to be fixed, up to maxRepairs
+// times, and the flow fails with FAILED_PRECONDITION if it still does.
+func DefineFlow(g *genkit.Genkit, catalog *pricing.Catalog) *core.Flow[CravingInput, *PricedRecipe, *Recipe] {
+	getIngredientsOnSale := defineTool(g, catalog)
+
+	return genkit.DefineStreamingFlow(g, "bargainChefFlow",
+		func(ctx context.Context, input CravingInput, sendChunk func(context.Context, *Recipe) error) (*PricedRecipe, error) {
+			loc, locale, err := input.resolve()
+			if err != nil {
+				return nil, err
+			}
+			day := saleDay{date: time.Now().In(loc), locale: locale}
+
+			prompt := fmt.Sprintf(`Today is %s (%s) in the user's time zone. The user is craving: %s.
+
+Call the getIngredientsOnSale tool to get today's sales. %s`, day.date.Format("Monday, "+pricing.DateLayout), pricing.DayType(day.date), input.Craving, recipeInstructions(locale)) + input.prompt(locale)
+
+			return cook(ctx, g, getIngredientsOnSale, catalog, day, prompt, &input.Constraints, sendChunk)
+		},
+	)
+}
+
+// defineTool defines the getIngredientsOnSale tool, which looks up sales in
+// catalog on the saleDay in the context, or today, or returns it if it is
+// already defined.
+func defineTool(g *genkit.Genkit, catalog *pricing.Catalog) ai.Tool {
+	if tool := genkit.LookupTool(g, "getIngredientsOnSale"); tool != nil {
+		return tool
+	}
+	return genkit.DefineTool(g, "getIngredientsOnSale",
+		"Returns the ingredients on sale at local grocery stores on the day the user is cooking, with structured prices. Sales change from day to day, and differ between weekdays and weekends.",
+		func(toolCtx *ai.ToolContext, input SaleQuery) (*SalesOnDay, error) {
+			day, ok := toolCtx.Value(saleDayKey{}).(saleDay)
+			if !ok {
+				day = saleDay{date: time.Now(), locale: pricing.DefaultLocale}
+			}
+			return &SalesOnDay{
+				Date:    day.date.Format(pricing.DateLayout),
+				Weekday: day.date.Weekday().String(),
+				DayType: pricing.DayType(day.date),
+				Sales:   day.locale.Sales(catalog.OnSale(input.Store, day.date)),
+			}, nil
+		},
+	)
+}
+
+// cook generates a recipe for prompt, streaming partial recipes to sendChunk
+// if it is not nil, prices it at the sales of day and has the model repair
+// it until it fits c.
+func cook(ctx context.Context, g *genkit.Genkit, tool ai.Tool, catalog *pricing.Catalog, day saleDay, prompt string, c *Constraints, sendChunk func(context.Context, *Recipe) error) (*PricedRecipe, error) {
+	ctx = context.WithValue(ctx, saleDayKey{}, day)
+	sales := catalog.OnSale("", day.date)
+
+	var final *Recipe
+	for result, err := range genkit.GenerateDataStream[*Recipe](ctx, g, generateOptions(ai.WithPrompt("%s", prompt), ai.WithTools(tool))...) {
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate recipe: %w", err)
+		}
+		if result.Done {
+			final = result.Output
+			break
+		}
+		if result.Chunk != nil && sendChunk != nil {
+			if err := sendChunk(ctx, result.Chunk); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if final == nil {
+		return nil, errors.New("failed to generate recipe")
+	}
+
+	var repairs []string
+	for attempt := 0; ; attempt++ {
+		note := c.scale(final)
+		priced := priceRecipe(final, sales, c)
+		priced.ShoppingList = day.locale.ShoppingList(priced.ShoppingList)
+		violations := c.check(priced, day.locale)
+		if len(violations) == 0 {
+			if note != "" {
+				repairs = append(repairs, note)
+			}
+			priced.Repairs = repairs
+			return priced, nil
+		}
+		problems := make([]string, len(violations))
+		for i, v := range violations {
+			problems[i] = v.message
+		}
+		if attempt == maxRepairs {
+			return nil, core.NewError(core.FAILED_PRECONDITION, "could not find a recipe that fits the constraints: %s", strings.Join(problems, "; "))
+		}
+		var err error
+		if final, err = repairRecipe(ctx, g, prompt, final, violations, tool); err != nil {
+			return nil, err
+		}
+		repairs = append(repairs, "Regenerated because "+strings.Join(problems, "; ")+".")
+	}
+}
+
+// generateOptions returns the options to generate a recipe with the selected
+// model, followed by opts.
+func generateOptions(opts ...ai.GenerateOption) []ai.GenerateOption {
+	model := modelName()
+	all := []ai.GenerateOption{ai.WithModelName(model)}
+	if strings.HasPrefix(model, "googleai/") {
+		all = append(all, ai.WithConfig(&genai.GenerateContentConfig{
+			ThinkingConfig: &genai.ThinkingConfig{
+				ThinkingLevel: genai.ThinkingLevelMinimal,
+			},
+		}))
+	}
+	return append(all, opts...)
+}
+
+// repairRecipe asks the model to fix the violations of a recipe it generated
+// for prompt.
+func repairRecipe(ctx context.Context, g *genkit.Genkit, prompt string, r *Recipe, violations []violation, tools ...ai.ToolRef) (*Recipe, error) {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return nil, err
+	}
+	var feedback strings.Builder
+	feedback.WriteString("This recipe breaks the constraints:\n")
+	var avoid []string
+	for _, v := range violations {
+		fmt.Fprintf(&feedback, "- %s\n", v.message)
+		if v.ingredient != "" && !slices.Contains(avoid, v.ingredient) {
+			avoid = append(avoid, v.ingredient)
+		}
+	}
+	if len(avoid) > 0 {
+		fmt.Fprintf(&feedback, "Do not use: %s.\n", strings.Join(avoid, ", "))
+	}
+	feedback.WriteString("Return the whole corrected recipe.")
+
+	fixed, _, err := genkit.GenerateData[Recipe](ctx, g, generateOptions(
+		ai.WithMessages(
+			ai.NewUserTextMessage(prompt),
+			ai.NewModelTextMessage(string(data)),
+			ai.NewUserTextMessage(feedback.String()),
+		),
+		ai.WithTools(tools...),
+	)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to repair recipe: %w", err)
+	}
+	return fixed, nil
+}
+
+// priceRecipe prices the ingredients of r at the given sales, leaving out
+// the ones in the pantry and what the leftovers in c cover.
+func priceRecipe(r *Recipe, sales []pricing.Sale, c *Constraints) *PricedRecipe {
+	needs := make([]pricing.Need, len(r.Ingredients))
+	for i, ing := range r.Ingredients {
+		needs[i] = pricing.Need{Name: ing.Name, Quantity: pricing.Quantity{Amount: ing.Amount, Unit: ing.Unit}, OnHand: c.inPantry(ing.Name)}
+	}
+	needs = pricing.Use(needs, slices.Clone(c.leftovers))
+	priced := &PricedRecipe{Recipe: *r, ShoppingList: pricing.Shop(needs, sales)}
+	priced.Ingredients = append([]RecipeIngredient{}, r.Ingredients...)
+	for i, item := range priced.ShoppingList.Items {
+		priced.Ingredients[i].OnSale = item.Sale != nil
+	}
+	return priced
+}
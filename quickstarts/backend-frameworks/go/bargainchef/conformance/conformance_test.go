@@ -0,0 +1,502 @@
+package conformance
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	root    = flag.String("root", "../..", "the directory containing the quickstarts")
+	servers = flag.String("servers", "nethttp,gin,echo,chi", "comma-separated quickstarts to check")
+)
+
+// check is a request sent to every server and the response it must get.
+type check struct {
+	name    string
+	method  string
+	path    string
+	headers map[string]string
+	body    string
+	// status is the expected status code, or 0 to only require every server
+	// to return the same one. anySuccess accepts any 2xx status, since
+	// frameworks differ in how they answer CORS preflight requests.
+	status int
+	// contentType is the expected media type of the response, if any.
+	contentType string
+	// allowOrigin is the expected Access-Control-Allow-Origin header, if any.
+	allowOrigin string
+	// sameBody requires the body to be identical on every server. JSON
+	// bodies are compared without their whitespace.
+	sameBody bool
+	// contains is text that the body must contain, for errors that each
+	// framework answers in its own format.
+	contains string
+	// eventIDs replaces the random run IDs in the "id:" lines of a
+	// server-sent event stream, so that streams can be compared.
+	eventIDs bool
+	// do, if set, runs the check instead of sending the request above.
+	do func(url string) (*response, error)
+}
+
+// response is what a server answered to a check.
+type response struct {
+	status int
+	body   []byte
+}
+
+const anySuccess = -1
+
+var checks = []check{
+	{
+		name:        "run flow",
+		method:      http.MethodPost,
+		path:        "/bargainChefFlow",
+		headers:     map[string]string{"Content-Type": "application/json"},
+		body:        `{"data":{"craving":"something warm with chicken"}}`,
+		status:      http.StatusOK,
+		contentType: "application/json",
+		sameBody:    true,
+	},
+	{
+		name:        "stream flow",
+		method:      http.MethodPost,
+		path:        "/bargainChefFlow",
+		headers:     map[string]string{"Content-Type": "application/json", "Accept": "text/event-stream"},
+		body:        `{"data":{"craving":"a light lunch"}}`,
+		status:      http.StatusOK,
+		contentType: "text/event-stream",
+		sameBody:    true,
+	},
+	{
+		name:        "cors request",
+		method:      http.MethodPost,
+		path:        "/bargainChefFlow",
+		headers:     map[string]string{"Content-Type": "application/json", "Origin": "http://localhost:5173"},
+		body:        `{"data":{"craving":"pasta"}}`,
+		status:      http.StatusOK,
+		allowOrigin: "*",
+		sameBody:    true,
+	},
+	{
+		name:   "cors preflight",
+		method: http.MethodOptions,
+		path:   "/bargainChefFlow",
+		headers: map[string]string{
+			"Origin":                         "http://localhost:5173",
+			"Access-Control-Request-Method":  "POST",
+			"Access-Control-Request-Headers": "content-type",
+		},
+		status:      anySuccess,
+		allowOrigin: "*",
+	},
+	{
+		name:        "constraints",
+		method:      http.MethodPost,
+		path:        "/bargainChefFlow",
+		headers:     map[string]string{"Content-Type": "application/json"},
+		body:        `{"data":{"craving":"dinner","allergies":["dairy"],"diets":["gluten-free"],"servings":4,"pantry":["rice","garlic"]}}`,
+		status:      http.StatusOK,
+		contentType: "application/json",
+		sameBody:    true,
+	},
+	{
+		name:        "time zone and locale",
+		method:      http.MethodPost,
+		path:        "/bargainChefFlow",
+		headers:     map[string]string{"Content-Type": "application/json"},
+		body:        `{"data":{"craving":"pasta","timeZone":"Pacific/Kiritimati","locale":"fr-FR"}}`,
+		status:      http.StatusOK,
+		contentType: "application/json",
+		sameBody:    true,
+	},
+	{
+		name:     "unknown time zone",
+		method:   http.MethodPost,
+		path:     "/bargainChefFlow",
+		headers:  map[string]string{"Content-Type": "application/json"},
+		body:     `{"data":{"craving":"pasta","timeZone":"Mars/Olympus_Mons"}}`,
+		status:   http.StatusBadRequest,
+		contains: `unknown time zone`,
+	},
+	{
+		name:     "over budget",
+		method:   http.MethodPost,
+		path:     "/bargainChefFlow",
+		headers:  map[string]string{"Content-Type": "application/json"},
+		body:     `{"data":{"craving":"dinner","maxBudget":100}}`,
+		status:   http.StatusBadRequest,
+		contains: `could not find a recipe that fits the constraints`,
+	},
+	{
+		name:        "meal plan",
+		method:      http.MethodPost,
+		path:        "/mealPlanFlow",
+		headers:     map[string]string{"Content-Type": "application/json"},
+		body:        `{"data":{"preferences":"comfort food","startDate":"2030-01-04","servings":2,"pantry":["salt"],"maxBudget":20000}}`,
+		status:      http.StatusOK,
+		contentType: "application/json",
+		sameBody:    true,
+	},
+	{
+		name:        "stream meal plan",
+		method:      http.MethodPost,
+		path:        "/mealPlanFlow",
+		headers:     map[string]string{"Content-Type": "application/json", "Accept": "text/event-stream"},
+		body:        `{"data":{"startDate":"2030-01-04","allergies":["egg"]}}`,
+		status:      http.StatusOK,
+		contentType: "text/event-stream",
+		sameBody:    true,
+	},
+	{
+		name:        "sse post",
+		method:      http.MethodPost,
+		path:        "/sse/bargainChefFlow",
+		headers:     map[string]string{"Content-Type": "application/json"},
+		body:        `{"data":{"craving":"a light lunch"}}`,
+		status:      http.StatusOK,
+		contentType: "text/event-stream",
+		sameBody:    true,
+		eventIDs:    true,
+	},
+	{
+		name:        "sse get",
+		method:      http.MethodGet,
+		path:        "/sse/mealPlanFlow?data=" + url.QueryEscape(`{"startDate":"2030-01-04"}`),
+		headers:     map[string]string{"Accept": "text/event-stream"},
+		status:      http.StatusOK,
+		contentType: "text/event-stream",
+		sameBody:    true,
+		eventIDs:    true,
+	},
+	{
+		name:     "sse resume",
+		do:       resumeSSE,
+		sameBody: true,
+	},
+	{
+		name:    "sse resume unknown run",
+		method:  http.MethodGet,
+		path:    "/sse/bargainChefFlow",
+		headers: map[string]string{"Last-Event-ID": "0123456789abcdef:3"},
+		status:  http.StatusNoContent,
+	},
+	{
+		name:     "websocket",
+		do:       runWebSocket,
+		sameBody: true,
+	},
+	{
+		name:     "cancel unknown run",
+		method:   http.MethodPost,
+		path:     "/runs/0123456789abcdef/cancel",
+		status:   http.StatusNotFound,
+		sameBody: true,
+	},
+	{
+		name:     "job",
+		do:       runJob,
+		sameBody: true,
+	},
+	{
+		name:     "job webhook",
+		do:       receiveWebhook,
+		sameBody: true,
+	},
+	{
+		name:     "unknown job",
+		method:   http.MethodGet,
+		path:     "/jobs/0123456789abcdef",
+		status:   http.StatusNotFound,
+		sameBody: true,
+	},
+	{
+		name:     "job of unknown flow",
+		method:   http.MethodPost,
+		path:     "/jobs/unknownFlow",
+		headers:  map[string]string{"Content-Type": "application/json"},
+		body:     `{"data":{}}`,
+		status:   http.StatusNotFound,
+		sameBody: true,
+	},
+	{
+		name:    "malformed body",
+		method:  http.MethodPost,
+		path:    "/bargainChefFlow",
+		headers: map[string]string{"Content-Type": "application/json"},
+		body:    `{"data":`,
+		status:  http.StatusBadRequest,
+	},
+	{
+		name:     "invalid input",
+		method:   http.MethodPost,
+		path:     "/bargainChefFlow",
+		headers:  map[string]string{"Content-Type": "application/json"},
+		body:     `{"data":{"craving":"soup","servings":"two"}}`,
+		status:   http.StatusBadRequest,
+		contains: "servings",
+	},
+	{
+		name:   "wrong method",
+		method: http.MethodGet,
+		path:   "/bargainChefFlow",
+		status: http.StatusMethodNotAllowed,
+	},
+	{
+		name:   "unknown flow",
+		method: http.MethodPost,
+		path:   "/unknownFlow",
+		status: http.StatusNotFound,
+	},
+	{
+		name:     "admin without token",
+		method:   http.MethodGet,
+		path:     "/admin/flyers",
+		status:   http.StatusUnauthorized,
+		sameBody: true,
+	},
+	{
+		name:    "upload flyer",
+		method:  http.MethodPost,
+		path:    "/admin/flyers",
+		headers: map[string]string{"Authorization": "Bearer " + adminToken, "Content-Type": "application/json"},
+		body: `{"store":"Conformance Market","validFrom":"2030-01-01","validTo":"2030-01-07","currency":"EUR",
+			"items":[{"name":"Leeks","price":{"amount":129,"unit":"bunch"},"regularPrice":{"amount":199,"unit":"bunch"}}]}`,
+		status:      http.StatusCreated,
+		contentType: "application/json",
+		sameBody:    true,
+	},
+	{
+		name:     "invalid flyer",
+		method:   http.MethodPost,
+		path:     "/admin/flyers",
+		headers:  map[string]string{"Authorization": "Bearer " + adminToken, "Content-Type": "application/json"},
+		body:     `{"store":"Conformance Market","items":[{"name":"leeks","price":{"amount":-1,"unit":"bunch"}}]}`,
+		status:   http.StatusBadRequest,
+		sameBody: true,
+	},
+	{
+		name:     "sales on date",
+		method:   http.MethodGet,
+		path:     "/admin/sales?date=2030-01-05&store=conformance%20market",
+		headers:  map[string]string{"Authorization": "Bearer " + adminToken},
+		status:   http.StatusOK,
+		sameBody: true,
+	},
+	{
+		name:    "delete flyer",
+		method:  http.MethodDelete,
+		path:    "/admin/flyers/conformance-market-2030-01-01",
+		headers: map[string]string{"Authorization": "Bearer " + adminToken},
+		status:  http.StatusNoContent,
+	},
+}
+
+// adminToken is the admin API token the servers are started with.
+const adminToken = "conformance"
+
+// server is a quickstart built by TestMain.
+type server struct {
+	name string
+	bin  string
+	// err is why the quickstart could not be built, if it could not.
+	err error
+}
+
+// built holds the quickstarts to check, in the order of -servers.
+var built []*server
+
+// TestMain builds the quickstarts named by -servers before the tests run.
+// With -short, nothing is built and the tests are skipped.
+func TestMain(m *testing.M) {
+	flag.Parse()
+	if testing.Short() {
+		os.Exit(m.Run())
+	}
+
+	bin, err := os.MkdirTemp("", "conformance-")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	for _, name := range strings.Split(*servers, ",") {
+		s := &server{name: name, bin: filepath.Join(bin, name)}
+		build := exec.Command("go", "build", "-o", s.bin, ".")
+		build.Dir = filepath.Join(*root, name)
+		if out, err := build.CombinedOutput(); err != nil {
+			s.err = fmt.Errorf("build failed: %v\n%s", err, out)
+		}
+		built = append(built, s)
+	}
+	code := m.Run()
+	os.RemoveAll(bin)
+	os.Exit(code)
+}
+
+// TestConformance sends every check to each quickstart in turn and compares
+// the responses with those of the first one.
+func TestConformance(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds and runs every quickstart")
+	}
+	// first holds the first server's response to each check, to compare the
+	// others against.
+	first := map[string]*response{}
+	for _, s := range built {
+		t.Run(s.name, func(t *testing.T) {
+			if s.err != nil {
+				t.Fatal(s.err)
+			}
+			base, stop, err := start(s.bin)
+			if err != nil {
+				t.Fatalf("could not start: %v", err)
+			}
+			defer stop()
+			for _, c := range checks {
+				t.Run(c.name, func(t *testing.T) {
+					resp, err := c.run(base)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if want, ok := first[c.name]; !ok {
+						first[c.name] = resp
+					} else if err := c.compare(resp, want); err != nil {
+						t.Error(err)
+					}
+				})
+			}
+		})
+	}
+}
+
+// start runs the quickstart binary bin on a free port with the offline model
+// and waits until it accepts connections.
+func start(bin string) (url string, stop func(), err error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", nil, err
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+
+	var logs bytes.Buffer
+	cmd := exec.Command(bin)
+	cmd.Env = append(os.Environ(),
+		fmt.Sprintf("PORT=%d", port),
+		"BARGAINCHEF_MODEL=fake",
+		"BARGAINCHEF_CATALOG=",
+		"BARGAINCHEF_ADMIN_TOKEN="+adminToken,
+		"BARGAINCHEF_JOBS_DIR="+bin+"-jobs",
+		"BARGAINCHEF_WEBHOOK_SECRET="+webhookSecret,
+		// The webhook check receives the webhook on 127.0.0.1.
+		"BARGAINCHEF_ALLOW_PRIVATE_CALLBACKS=true",
+	)
+	cmd.Stdout = &logs
+	cmd.Stderr = &logs
+	if err := cmd.Start(); err != nil {
+		return "", nil, err
+	}
+	stop = func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	}
+
+	addr := fmt.Sprintf("127.0.0.1:%d", port)
+	for deadline := time.Now().Add(30 * time.Second); time.Now().Before(deadline); time.Sleep(100 * time.Millisecond) {
+		if conn, err := net.Dial("tcp", addr); err == nil {
+			conn.Close()
+			return "http://" + addr, stop, nil
+		}
+	}
+	stop()
+	return "", nil, fmt.Errorf("server did not start listening on %s:\n%s", addr, logs.String())
+}
+
+// run sends the check's request to the server at url and returns the
+// response if it is as expected.
+func (c check) run(url string) (*response, error) {
+	if c.do != nil {
+		return c.do(url)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, c.method, url+c.path, strings.NewReader(c.body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range c.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	switch c.status {
+	case 0:
+	case anySuccess:
+		if resp.StatusCode/100 != 2 {
+			return nil, fmt.Errorf("got status %d, want 2xx", resp.StatusCode)
+		}
+	default:
+		if resp.StatusCode != c.status {
+			return nil, fmt.Errorf("got status %d, want %d: %s", resp.StatusCode, c.status, bytes.TrimSpace(body))
+		}
+	}
+	if c.contentType != "" {
+		if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mediaType != c.contentType {
+			return nil, fmt.Errorf("got content type %q, want %q", resp.Header.Get("Content-Type"), c.contentType)
+		}
+	}
+	if c.allowOrigin != "" {
+		if got := resp.Header.Get("Access-Control-Allow-Origin"); got != c.allowOrigin {
+			return nil, fmt.Errorf("got Access-Control-Allow-Origin %q, want %q", got, c.allowOrigin)
+		}
+	}
+	if c.contains != "" && !bytes.Contains(body, []byte(c.contains)) {
+		return nil, fmt.Errorf("body does not contain %q: %s", c.contains, bytes.TrimSpace(body))
+	}
+	if c.eventIDs {
+		body = eventID.ReplaceAll(body, []byte("id: <run>:$1"))
+	}
+	return &response{status: resp.StatusCode, body: body}, nil
+}
+
+// compare checks that got matches the first server's response.
+func (c check) compare(got, want *response) error {
+	if c.status != anySuccess && got.status != want.status {
+		return fmt.Errorf("got status %d, but the first server returned %d", got.status, want.status)
+	}
+	if c.sameBody && !bytes.Equal(compact(got.body), compact(want.body)) {
+		return fmt.Errorf("body differs from the first server:\n got: %s\nwant: %s", got.body, want.body)
+	}
+	return nil
+}
+
+// compact returns a JSON body without its whitespace, and any other body as
+// is.
+func compact(body []byte) []byte {
+	var b bytes.Buffer
+	if err := json.Compact(&b, body); err != nil {
+		return body
+	}
+	return b.Bytes()
+}
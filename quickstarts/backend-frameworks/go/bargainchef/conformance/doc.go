@@ -0,0 +1,9 @@
+// Package conformance checks that the Go backend quickstarts behave the same
+// way. Its test builds each quickstart, starts it with the offline
+// bargainChef model, sends every server the same HTTP requests and compares
+// the responses:
+//
+//	cd quickstarts/backend-frameworks/go/bargainchef
+//	go test -count=1 ./conformance
+//	go test -count=1 ./conformance -servers gin,chi
+package conformance
@@ -0,0 +1,139 @@
+package conformance
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"flow-transports/go/transport"
+)
+
+// webhookSecret is the secret the servers sign the webhooks of jobs with.
+const webhookSecret = "whsec_Y29uZm9ybWFuY2U="
+
+// runJob submits a job, polls it until it finishes and returns it without
+// its ID and times, which differ on every run.
+func runJob(url string) (*response, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	status, header, body, err := send(ctx, http.MethodPost, url+"/jobs/mealPlanFlow", `{"data":{"startDate":"2030-01-04"}}`)
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusAccepted {
+		return nil, fmt.Errorf("got status %d, want 202: %s", status, bytes.TrimSpace(body))
+	}
+	location := header.Get("Location")
+	if !strings.HasPrefix(location, "/jobs/") {
+		return nil, fmt.Errorf("got Location %q, want /jobs/{id}", location)
+	}
+	for {
+		var job transport.Job
+		if err := json.Unmarshal(body, &job); err != nil {
+			return nil, fmt.Errorf("invalid job %s: %v", body, err)
+		}
+		if job.Status.Done() {
+			return &response{status: status, body: stripJob(body)}, nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("job still %s: %w", job.Status, ctx.Err())
+		case <-time.After(100 * time.Millisecond):
+		}
+		if status, _, body, err = send(ctx, http.MethodGet, url+location, ""); err != nil {
+			return nil, err
+		}
+		if status != http.StatusOK {
+			return nil, fmt.Errorf("polling %s: got status %d, want 200: %s", location, status, bytes.TrimSpace(body))
+		}
+	}
+}
+
+// receiveWebhook submits a job with a callback URL and waits for its webhook,
+// whose signature must be valid. It returns the job in the webhook without
+// its ID and times.
+func receiveWebhook(url string) (*response, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	received := make(chan []byte, 1)
+	invalid := make(chan error, 1)
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err == nil {
+			err = transport.VerifyWebhook(webhookSecret, r.Header, body, time.Minute)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			select {
+			case invalid <- err:
+			default:
+			}
+			return
+		}
+		select {
+		case received <- body:
+		default:
+		}
+	})}
+	go srv.Serve(l)
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	status, _, body, err := send(ctx, http.MethodPost, url+"/jobs/bargainChefFlow",
+		fmt.Sprintf(`{"data":{"craving":"a light lunch"},"callbackUrl":"http://%s/done"}`, l.Addr()))
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusAccepted {
+		return nil, fmt.Errorf("got status %d, want 202: %s", status, bytes.TrimSpace(body))
+	}
+	select {
+	case body := <-received:
+		return &response{status: http.StatusOK, body: stripJob(body)}, nil
+	case err := <-invalid:
+		return nil, fmt.Errorf("invalid webhook: %v", err)
+	case <-ctx.Done():
+		return nil, fmt.Errorf("no webhook: %w", ctx.Err())
+	}
+}
+
+// send sends a request with a JSON body, if any, and returns the response.
+func send(ctx context.Context, method, url, body string) (int, http.Header, []byte, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, strings.NewReader(body))
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	return resp.StatusCode, resp.Header, data, err
+}
+
+// stripJob removes the fields of a job that differ on every run, and the
+// callback URL, whose port does.
+func stripJob(body []byte) []byte {
+	var job map[string]json.RawMessage
+	if err := json.Unmarshal(body, &job); err != nil {
+		return body
+	}
+	for _, k := range []string{"id", "callbackUrl", "createdAt", "startedAt", "finishedAt"} {
+		delete(job, k)
+	}
+	stripped, _ := json.Marshal(job)
+	return stripped
+}
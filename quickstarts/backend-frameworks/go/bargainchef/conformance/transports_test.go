@@ -0,0 +1,165 @@
+package conformance
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// eventID matches the ID lines of server-sent events, which are the random
+// ID of the run and the number of the event.
+var eventID = regexp.MustCompile(`(?m)^id: [0-9a-f]+:([0-9]+)$`)
+
+// runID matches the random run IDs of WebSocket messages.
+var runID = regexp.MustCompile(`"runId":"[0-9a-f]+"`)
+
+// resumeSSE streams a run as server-sent events, then reconnects with the ID
+// of its first event, as EventSource does after a dropped connection, and
+// checks that the server sends the rest of the run again.
+func resumeSSE(url string) (*response, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	get := func(method, body string, headers map[string]string) (int, []byte, error) {
+		req, err := http.NewRequestWithContext(ctx, method, url+"/sse/bargainChefFlow", strings.NewReader(body))
+		if err != nil {
+			return 0, nil, err
+		}
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return 0, nil, err
+		}
+		defer resp.Body.Close()
+		data, err := io.ReadAll(resp.Body)
+		return resp.StatusCode, data, err
+	}
+
+	status, full, err := get(http.MethodPost, `{"data":{"craving":"soup"}}`, map[string]string{"Content-Type": "application/json"})
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("got status %d, want 200: %s", status, bytes.TrimSpace(full))
+	}
+	first := eventID.Find(full)
+	if first == nil {
+		return nil, fmt.Errorf("stream has no event IDs:\n%s", full)
+	}
+	last := strings.TrimPrefix(string(first), "id: ")
+
+	status, rest, err := get(http.MethodGet, "", map[string]string{"Last-Event-ID": last})
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("resuming after %s: got status %d, want 200: %s", last, status, bytes.TrimSpace(rest))
+	}
+	// The resumed stream starts with the retry field, then has the events
+	// after the first.
+	_, events, _ := bytes.Cut(rest, []byte("\n\n"))
+	if !bytes.HasSuffix(full, events) || len(events) == 0 {
+		return nil, fmt.Errorf("resuming after %s did not send the rest of the run:\n got: %s\nfull: %s", last, rest, full)
+	}
+	return &response{status: status, body: eventID.ReplaceAll(rest, []byte("id: <run>:$1"))}, nil
+}
+
+// wsMessage is a message of the WebSocket transport.
+type wsMessage struct {
+	Type    string          `json:"type"`
+	ID      string          `json:"id"`
+	Flow    string          `json:"flow,omitempty"`
+	Data    json.RawMessage `json:"data,omitempty"`
+	Message json.RawMessage `json:"message,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *struct {
+		Status string `json:"status"`
+	} `json:"error,omitempty"`
+}
+
+// runWebSocket runs both flows at once over one WebSocket connection, along
+// with a run that is cancelled right away and one of an unknown flow, and
+// returns the messages of each run. The cancelled run may finish before the
+// cancellation arrives, so only how it ended is kept.
+func runWebSocket(url string) (*response, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	conn, resp, err := websocket.DefaultDialer.DialContext(ctx, "ws"+strings.TrimPrefix(url, "http")+"/ws", nil)
+	if err != nil {
+		if resp != nil {
+			return nil, fmt.Errorf("%v: got status %d", err, resp.StatusCode)
+		}
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetReadDeadline(deadline)
+	}
+
+	requests := []wsMessage{
+		{Type: "run", ID: "chef", Flow: "bargainChefFlow", Data: json.RawMessage(`{"craving":"a light lunch"}`)},
+		{Type: "run", ID: "plan", Flow: "mealPlanFlow", Data: json.RawMessage(`{"startDate":"2030-01-04"}`)},
+		{Type: "run", ID: "cancelled", Flow: "mealPlanFlow", Data: json.RawMessage(`{"startDate":"2030-01-04"}`)},
+		{Type: "cancel", ID: "cancelled"},
+		{Type: "run", ID: "unknown", Flow: "unknownFlow"},
+	}
+	for _, req := range requests {
+		if err := conn.WriteJSON(req); err != nil {
+			return nil, err
+		}
+	}
+
+	runs := map[string][]json.RawMessage{}
+	for pending := 4; pending > 0; {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return nil, err
+		}
+		var msg wsMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return nil, fmt.Errorf("invalid message %s: %v", data, err)
+		}
+		switch msg.ID {
+		case "chef", "plan", "unknown":
+			runs[msg.ID] = append(runs[msg.ID], runID.ReplaceAll(data, []byte(`"runId":"<run>"`)))
+		case "cancelled":
+			switch {
+			case msg.Type == "result":
+				runs[msg.ID] = []json.RawMessage{json.RawMessage(`"finished before the cancellation"`)}
+			case msg.Type == "error" && msg.Error != nil && msg.Error.Status == "CANCELLED":
+				runs[msg.ID] = []json.RawMessage{json.RawMessage(`"cancelled"`)}
+			case msg.Type == "error":
+				return nil, fmt.Errorf("cancelled run failed: %s", data)
+			}
+		default:
+			return nil, fmt.Errorf("message of an unknown run: %s", data)
+		}
+		if msg.Type == "result" || msg.Type == "error" {
+			pending--
+		}
+	}
+
+	var ids []string
+	for id := range runs {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	var b bytes.Buffer
+	for _, id := range ids {
+		fmt.Fprintf(&b, "%s:\n", id)
+		for _, msg := range runs[id] {
+			fmt.Fprintf(&b, "  %s\n", msg)
+		}
+	}
+	return &response{status: http.StatusSwitchingProtocols, body: b.Bytes()}, nil
+}
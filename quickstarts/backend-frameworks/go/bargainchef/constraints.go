@@ -0,0 +1,255 @@
+package bargainchef
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"example/bargainchef/pricing"
+)
+
+// Constraints are the user's dietary restrictions, budget and kitchen. They
+// are given to the model in the prompt and checked against its recipe, which
+// is repaired or rejected if it breaks them.
+type Constraints struct {
+	Allergies []string `json:"allergies,omitempty" jsonschema:"enum=peanut,enum=tree nut,enum=dairy,enum=egg,enum=gluten,enum=soy,enum=fish,enum=shellfish,enum=sesame,description=Allergens the recipe must not contain"`
+	Diets     []string `json:"diets,omitempty" jsonschema:"enum=vegetarian,enum=vegan,enum=pescatarian,enum=halal,enum=gluten-free,enum=dairy-free,description=Diets the recipe must follow"`
+	// MaxBudget only counts the ingredients on sale, since they are the only
+	// ones with a known price.
+	MaxBudget int64  `json:"maxBudget,omitempty" jsonschema:"minimum=0,description=The most the ingredients to buy may cost, in the minor unit of currency (e.g. cents)" validate:"gte=0"`
+	Currency  string `json:"currency,omitempty" jsonschema:"pattern=^[A-Z]{3}$,description=The ISO 4217 currency of maxBudget; defaults to USD" validate:"omitempty,iso4217"`
+	// Servings is enforced by scaling the recipe.
+	Servings  int      `json:"servings,omitempty" jsonschema:"minimum=0,maximum=100,description=How many servings to make" validate:"gte=0,lte=100"`
+	Equipment []string `json:"equipment,omitempty" jsonschema:"description=The kitchen equipment available (e.g. stovetop, oven, microwave); anything goes if empty"`
+	// Pantry ingredients are left out of the shopping list.
+	Pantry []string `json:"pantry,omitempty" jsonschema:"description=Ingredients already on hand, which do not need to be bought"`
+
+	// leftovers were bought for earlier days of a meal plan. They are used
+	// up before anything is bought.
+	leftovers []pricing.Need
+}
+
+// foodGroup is a kind of ingredient that allergies and diets rule out.
+type foodGroup struct {
+	// words identify the group's ingredients. They are matched against whole
+	// words of ingredient names, with their plurals.
+	words []string
+	// substitutes are words that make an ingredient a substitute free of the
+	// group, such as "vegan" in "vegan butter" or "almond" in "almond milk".
+	// "<group>-free" always does.
+	substitutes []string
+}
+
+var foodGroups = map[string]foodGroup{
+	"peanut":    {words: []string{"peanut"}},
+	"tree nut":  {words: []string{"almond", "walnut", "cashew", "pecan", "pistachio", "hazelnut", "macadamia", "nut"}},
+	"dairy":     {words: []string{"milk", "butter", "buttermilk", "cheese", "cream", "yogurt", "yoghurt", "parmesan", "mozzarella", "cheddar", "ricotta", "feta", "mascarpone", "ghee", "whey", "kefir"}, substitutes: []string{"vegan", "plant based", "non dairy", "almond", "oat", "soy", "coconut", "rice", "cashew", "peanut"}},
+	"egg":       {words: []string{"egg", "mayonnaise", "mayo", "meringue"}, substitutes: []string{"vegan", "plant based"}},
+	"gluten":    {words: []string{"wheat", "flour", "bread", "breadcrumb", "panko", "pasta", "spaghetti", "penne", "noodle", "couscous", "barley", "rye", "semolina", "bulgur", "farro", "seitan", "cracker", "soy sauce", "beer"}, substitutes: []string{"rice flour", "rice noodle", "corn tortilla", "tamari"}},
+	"soy":       {words: []string{"soy", "soya", "tofu", "tempeh", "edamame", "miso", "tamari"}},
+	"fish":      {words: []string{"fish", "salmon", "tuna", "cod", "anchovy", "sardine", "tilapia", "trout", "halibut", "mackerel", "haddock"}},
+	"shellfish": {words: []string{"shrimp", "prawn", "crab", "lobster", "clam", "mussel", "oyster", "scallop", "crawfish", "squid"}},
+	"sesame":    {words: []string{"sesame", "tahini"}},
+	"meat":      {words: []string{"beef", "pork", "lamb", "veal", "mutton", "goat", "venison", "steak", "bacon", "ham", "sausage", "prosciutto", "pancetta", "salami", "chorizo", "pepperoni", "lard", "gelatin"}, substitutes: []string{"vegan", "plant based", "meatless"}},
+	"poultry":   {words: []string{"chicken", "turkey", "duck", "goose"}, substitutes: []string{"vegan", "plant based", "meatless"}},
+	"pork":      {words: []string{"pork", "bacon", "ham", "prosciutto", "pancetta", "salami", "chorizo", "pepperoni", "lard", "gelatin"}, substitutes: []string{"turkey", "vegan"}},
+	"alcohol":   {words: []string{"wine", "beer", "rum", "vodka", "brandy", "sake", "mirin", "bourbon", "whiskey", "sherry", "liqueur"}, substitutes: []string{"non alcoholic"}},
+	"honey":     {words: []string{"honey"}},
+}
+
+// diets are the food groups each diet rules out.
+var diets = map[string][]string{
+	"vegetarian":  {"meat", "poultry", "fish", "shellfish"},
+	"vegan":       {"meat", "poultry", "fish", "shellfish", "dairy", "egg", "honey"},
+	"pescatarian": {"meat", "poultry"},
+	"halal":       {"pork", "alcohol"},
+	"gluten-free": {"gluten"},
+	"dairy-free":  {"dairy"},
+}
+
+// equipmentWords are words in recipe steps that need a piece of equipment.
+var equipmentWords = map[string][]string{
+	"oven":           {"oven", "bake", "baked", "baking", "broil", "broiler", "sheet pan"},
+	"stovetop":       {"stovetop", "stove", "hob", "skillet", "saucepan", "frying pan", "wok", "simmer", "sauté", "saute"},
+	"grill":          {"grill", "grilled", "grilling"},
+	"microwave":      {"microwave"},
+	"blender":        {"blender", "blend"},
+	"food processor": {"food processor"},
+	"slow cooker":    {"slow cooker", "crockpot"},
+	"air fryer":      {"air fryer", "air fry"},
+}
+
+var equipmentAliases = map[string]string{
+	"stove": "stovetop", "hob": "stovetop", "cooktop": "stovetop", "range": "stovetop",
+	"crockpot": "slow cooker", "broiler": "oven",
+}
+
+// violation is a way a recipe breaks the constraints.
+type violation struct {
+	// ingredient is the offending ingredient, if any.
+	ingredient string
+	message    string
+}
+
+// currency returns the currency of the budget.
+func (c *Constraints) currency() string {
+	if c.Currency == "" {
+		return "USD"
+	}
+	return c.Currency
+}
+
+// prompt describes the constraints to the model, with amounts formatted for
+// l, or returns "" if there are none.
+func (c *Constraints) prompt(l pricing.Locale) string {
+	var lines []string
+	if len(c.Allergies) > 0 {
+		lines = append(lines, fmt.Sprintf("The user is allergic to %s. Do not use any ingredient that contains them, even in small amounts.", strings.Join(c.Allergies, ", ")))
+	}
+	if len(c.Diets) > 0 {
+		lines = append(lines, fmt.Sprintf("The recipe must be %s.", strings.Join(c.Diets, " and ")))
+	}
+	if c.MaxBudget > 0 {
+		lines = append(lines, fmt.Sprintf("The ingredients to buy must cost at most %s in total at today's sale prices.", l.Money(c.MaxBudget, c.currency())))
+	}
+	if c.Servings > 0 {
+		lines = append(lines, fmt.Sprintf("Make exactly %d servings.", c.Servings))
+	}
+	if len(c.Equipment) > 0 {
+		lines = append(lines, fmt.Sprintf("The only kitchen equipment available is: %s.", strings.Join(c.Equipment, ", ")))
+	}
+	if len(c.Pantry) > 0 {
+		lines = append(lines, fmt.Sprintf("The user already has %s, which do not need to be bought. Use them where they fit.", strings.Join(c.Pantry, ", ")))
+	}
+	if len(lines) == 0 {
+		return ""
+	}
+	return "\n\nThe recipe must respect these constraints:\n- " + strings.Join(lines, "\n- ")
+}
+
+// inPantry reports whether an ingredient is already on hand.
+func (c *Constraints) inPantry(name string) bool {
+	return slices.ContainsFunc(c.Pantry, func(p string) bool { return pricing.SameIngredient(name, p) })
+}
+
+// scale scales r to the number of servings asked for, if any, and returns a
+// note saying so if it had to.
+func (c *Constraints) scale(r *Recipe) string {
+	if c.Servings <= 0 || r.Servings == c.Servings {
+		return ""
+	}
+	if r.Servings <= 0 {
+		r.Servings = c.Servings
+		return ""
+	}
+	factor := float64(c.Servings) / float64(r.Servings)
+	r.Ingredients = slices.Clone(r.Ingredients)
+	for i := range r.Ingredients {
+		ing := &r.Ingredients[i]
+		ing.Amount = math.Round(ing.Amount*factor*100) / 100
+		ing.Quantity = strconv.FormatFloat(ing.Amount, 'f', -1, 64) + " " + ing.Unit
+	}
+	note := fmt.Sprintf("Scaled from %d to %d servings.", r.Servings, c.Servings)
+	r.Servings = c.Servings
+	return note
+}
+
+// check returns the ways r breaks the constraints, with amounts formatted
+// for l. Only the ingredients and cost are checked against allergies, diets
+// and the budget; steps are checked against the equipment.
+func (c *Constraints) check(r *PricedRecipe, l pricing.Locale) []violation {
+	var violations []violation
+	for _, ing := range r.Ingredients {
+		text := words(ing.Name)
+		for _, allergen := range c.Allergies {
+			if containsGroup(text, allergen) {
+				violations = append(violations, violation{ing.Name, fmt.Sprintf("%s contains %s, which the user is allergic to", ing.Name, allergen)})
+			}
+		}
+		for _, diet := range c.Diets {
+			for _, group := range diets[diet] {
+				if containsGroup(text, group) {
+					violations = append(violations, violation{ing.Name, fmt.Sprintf("%s is not %s", ing.Name, diet)})
+					break
+				}
+			}
+		}
+	}
+
+	if c.MaxBudget > 0 {
+		for _, t := range r.ShoppingList.Totals {
+			if t.Currency == c.currency() && t.Cost > c.MaxBudget {
+				violations = append(violations, violation{message: fmt.Sprintf("the ingredients to buy cost %s, over the budget of %s", t.CostDisplay, l.Money(c.MaxBudget, t.Currency))})
+			}
+		}
+	}
+
+	if len(c.Equipment) > 0 {
+		have := map[string]bool{}
+		for _, e := range c.Equipment {
+			e = strings.ToLower(strings.TrimSpace(e))
+			if alias, ok := equipmentAliases[e]; ok {
+				e = alias
+			}
+			have[e] = true
+		}
+		steps := words(strings.Join(r.Steps, " "))
+		for _, e := range sortedKeys(equipmentWords) {
+			if !have[e] && containsAny(steps, equipmentWords[e]) {
+				violations = append(violations, violation{message: fmt.Sprintf("the steps need the %s, which the user does not have", e)})
+			}
+		}
+	}
+	return violations
+}
+
+// words lowercases s and splits it into words, returned space-separated with
+// a space at each end so that whole words can be found with strings.Contains.
+func words(s string) string {
+	fields := strings.FieldsFunc(strings.ToLower(s), func(r rune) bool { return !unicode.IsLetter(r) })
+	return " " + strings.Join(fields, " ") + " "
+}
+
+// containsGroup reports whether the words of an ingredient name identify an
+// ingredient of the food group that is not a substitute free of it. A name
+// that is not one of foodGroups, such as an allergy to "kiwi", is a group of
+// its own words.
+func containsGroup(text, name string) bool {
+	name = strings.TrimSpace(words(name))
+	if name == "" {
+		return false
+	}
+	group, ok := foodGroups[name]
+	if !ok {
+		group = foodGroup{words: []string{name}}
+	}
+	if strings.Contains(text, " "+name+" free ") || containsAny(text, group.substitutes) {
+		return false
+	}
+	return containsAny(text, group.words)
+}
+
+// containsAny reports whether text, as returned by words, contains any of
+// phrases as whole words, allowing for plurals.
+func containsAny(text string, phrases []string) bool {
+	for _, p := range phrases {
+		for _, suffix := range []string{"", "s", "es"} {
+			if strings.Contains(text, " "+p+suffix+" ") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
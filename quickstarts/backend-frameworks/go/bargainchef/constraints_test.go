@@ -0,0 +1,147 @@
+package bargainchef
+
+import (
+	"reflect"
+	"testing"
+
+	"example/bargainchef/pricing"
+)
+
+func TestContainsGroup(t *testing.T) {
+	tests := []struct {
+		ingredient string
+		group      string
+		want       bool
+	}{
+		{"whole milk", "dairy", true},
+		{"peanut butter", "dairy", false},
+		{"almond milk", "dairy", false},
+		{"dairy-free cheese", "dairy", false},
+		{"soy sauce", "gluten", true},
+		{"gluten-free pasta", "gluten", false},
+		{"tamari", "gluten", false},
+		{"turkey bacon", "pork", false},
+		{"smoked bacon", "pork", true},
+		{"eggs", "egg", true},
+		{"walnuts", "tree nut", true},
+		{"sliced kiwis", "kiwi", true},
+		{"sliced kiwis", "Kiwi ", true},
+		{"mango", "kiwi", false},
+		{"mango", "", false},
+	}
+	for _, tt := range tests {
+		if got := containsGroup(words(tt.ingredient), tt.group); got != tt.want {
+			t.Errorf("containsGroup(%q, %q) = %v, want %v", tt.ingredient, tt.group, got, tt.want)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	l, err := pricing.ParseLocale("en-US")
+	if err != nil {
+		t.Fatal(err)
+	}
+	recipe := func(ingredients ...string) *PricedRecipe {
+		r := &PricedRecipe{}
+		for _, name := range ingredients {
+			r.Ingredients = append(r.Ingredients, RecipeIngredient{Name: name})
+		}
+		return r
+	}
+
+	tests := []struct {
+		name        string
+		constraints Constraints
+		recipe      *PricedRecipe
+		want        []string
+	}{
+		{
+			name:        "dairy substitutes",
+			constraints: Constraints{Allergies: []string{"dairy"}},
+			recipe:      recipe("peanut butter", "almond milk", "butter"),
+			want:        []string{"butter"},
+		},
+		{
+			name:        "gluten-free",
+			constraints: Constraints{Diets: []string{"gluten-free"}},
+			recipe:      recipe("soy sauce", "gluten-free pasta", "rice"),
+			want:        []string{"soy sauce"},
+		},
+		{
+			name:        "halal",
+			constraints: Constraints{Diets: []string{"halal"}},
+			recipe:      recipe("turkey bacon", "white wine"),
+			want:        []string{"white wine"},
+		},
+		{
+			name:        "unknown allergy",
+			constraints: Constraints{Allergies: []string{"kiwi"}},
+			recipe:      recipe("kiwis", "banana"),
+			want:        []string{"kiwis"},
+		},
+		{
+			name:        "budget",
+			constraints: Constraints{MaxBudget: 1000},
+			recipe: &PricedRecipe{ShoppingList: pricing.ShoppingList{Totals: []pricing.Total{
+				{Currency: "USD", Cost: 1250, CostDisplay: "$12.50"},
+				{Currency: "EUR", Cost: 5000, CostDisplay: "€50.00"},
+			}}},
+			want: []string{"the ingredients to buy cost $12.50, over the budget of $10.00"},
+		},
+		{
+			name:        "equipment",
+			constraints: Constraints{Equipment: []string{"stove"}},
+			recipe:      &PricedRecipe{Recipe: Recipe{Steps: []string{"Simmer the sauce.", "Bake for 20 minutes."}}},
+			want:        []string{"the steps need the oven, which the user does not have"},
+		},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, v := range tt.constraints.check(tt.recipe, l) {
+			if v.ingredient != "" {
+				got = append(got, v.ingredient)
+			} else {
+				got = append(got, v.message)
+			}
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: check() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestScale(t *testing.T) {
+	r := &Recipe{Servings: 2, Ingredients: []RecipeIngredient{
+		{Name: "rice", Amount: 1.5, Unit: "cup", Quantity: "1.5 cup"},
+		{Name: "eggs", Amount: 3, Unit: "each", Quantity: "3 each"},
+	}}
+	original := r.Ingredients
+	c := Constraints{Servings: 3}
+	if note := c.scale(r); note != "Scaled from 2 to 3 servings." {
+		t.Errorf("scale() note = %q", note)
+	}
+	want := []RecipeIngredient{
+		{Name: "rice", Amount: 2.25, Unit: "cup", Quantity: "2.25 cup"},
+		{Name: "eggs", Amount: 4.5, Unit: "each", Quantity: "4.5 each"},
+	}
+	if r.Servings != 3 || !reflect.DeepEqual(r.Ingredients, want) {
+		t.Errorf("scaled recipe = %d servings of %+v, want 3 of %+v", r.Servings, r.Ingredients, want)
+	}
+	if original[0].Amount != 1.5 {
+		t.Error("scale() changed the ingredients of the original recipe")
+	}
+
+	for _, tt := range []struct {
+		servings, recipe, want int
+	}{
+		{0, 4, 4},
+		{3, 3, 3},
+		{3, 0, 3},
+	} {
+		r := &Recipe{Servings: tt.recipe}
+		c := Constraints{Servings: tt.servings}
+		if note := c.scale(r); note != "" || r.Servings != tt.want {
+			t.Errorf("scaling %d servings to %d: %d servings, note %q; want %d and no note", tt.recipe, tt.servings, r.Servings, note, tt.want)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package bargainchef
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/genkit"
+)
+
+const fakeModelName = "bargainchef/fake"
+
+// defineFakeModel defines a model that behaves like a well-behaved Gemini for
+// bargainChefFlow without calling any API: it first asks for the sale
+// ingredients, then answers with a recipe that
+// uses one unit of each of them and a pinch of salt, streamed in two chunks.
+// It ignores the constraints in the prompt, but leaves out the ingredients a
+// repair request tells it not to use. Its output only depends on its input,
+// which makes it suitable for comparing servers.
+func defineFakeModel(g *genkit.Genkit) {
+	genkit.DefineModel(g, fakeModelName, &ai.ModelOptions{
+		Label:    "Offline bargainChef model",
+		Supports: &ai.ModelSupports{Multiturn: true, Tools: true, SystemRole: true},
+	}, func(ctx context.Context, req *ai.ModelRequest, cb ai.ModelStreamCallback) (*ai.ModelResponse, error) {
+		var prompt string
+		var sales SalesOnDay
+		found := false
+		avoid := map[string]bool{}
+		for _, m := range req.Messages {
+			for _, p := range m.Content {
+				switch {
+				case p.IsText() && m.Role == ai.RoleUser:
+					if prompt == "" {
+						prompt = p.Text
+					}
+					if _, rest, ok := strings.Cut(p.Text, "Do not use: "); ok {
+						list, _, _ := strings.Cut(rest, ".\n")
+						for _, name := range strings.Split(list, ", ") {
+							avoid[name] = true
+						}
+					}
+				case p.IsToolResponse() && p.ToolResponse.Name == "getIngredientsOnSale":
+					// The output may not have been through JSON yet.
+					data, err := json.Marshal(p.ToolResponse.Output)
+					if err != nil {
+						return nil, err
+					}
+					if err := json.Unmarshal(data, &sales); err != nil {
+						return nil, fmt.Errorf("unexpected getIngredientsOnSale output: %w", err)
+					}
+					found = true
+				}
+			}
+		}
+
+		if !found {
+			return &ai.ModelResponse{
+				Request:      req,
+				FinishReason: ai.FinishReasonStop,
+				Message: ai.NewModelMessage(ai.NewToolRequestPart(&ai.ToolRequest{
+					Name:  "getIngredientsOnSale",
+					Input: map[string]any{},
+				})),
+			}, nil
+		}
+
+		craving := "something tasty"
+		if _, rest, ok := strings.Cut(prompt, "The user is craving: "); ok {
+			craving, _, _ = strings.Cut(rest, ".\n")
+		}
+		recipe := Recipe{
+			Title:       "Bargain " + craving,
+			Description: fmt.Sprintf("A quick dish for %q built around today's deals.", craving),
+			Servings:    2,
+			Ingredients: []RecipeIngredient{},
+			Steps:       []string{},
+		}
+		for _, s := range sales.Sales {
+			if avoid[s.Name] {
+				continue
+			}
+			recipe.Ingredients = append(recipe.Ingredients, RecipeIngredient{
+				Name:     s.Name,
+				Quantity: "1 " + s.Price.Unit,
+				Amount:   1,
+				Unit:     s.Price.Unit,
+				OnSale:   true,
+			})
+			recipe.Steps = append(recipe.Steps, "Prepare the "+s.Name+".")
+		}
+		recipe.Ingredients = append(recipe.Ingredients, RecipeIngredient{Name: "salt", Quantity: "1 tsp", Amount: 1, Unit: "tsp"})
+		recipe.Steps = append(recipe.Steps, "Combine everything and serve.")
+		data, err := json.Marshal(recipe)
+		if err != nil {
+			return nil, err
+		}
+
+		text := string(data)
+		if cb != nil {
+			half := len(text) / 2
+			for _, chunk := range []string{text[:half], text[half:]} {
+				if err := cb(ctx, &ai.ModelResponseChunk{Content: []*ai.Part{ai.NewTextPart(chunk)}}); err != nil {
+					return nil, err
+				}
+			}
+		}
+		return &ai.ModelResponse{
+			Request:      req,
+			FinishReason: ai.FinishReasonStop,
+			Message:      ai.NewModelTextMessage(text),
+		}, nil
+	})
+}
@@ -0,0 +1,181 @@
+package bargainchef
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"example/bargainchef/pricing"
+
+	"github.com/firebase/genkit/go/core"
+	"github.com/firebase/genkit/go/genkit"
+)
+
+// planDays is the length of a meal plan.
+const planDays = 7
+
+type MealPlanInput struct {
+	Preferences string `json:"preferences,omitempty" jsonschema:"description=What the user would like to eat this week"`
+	StartDate   string `json:"startDate,omitempty" jsonschema:"description=The first day of the plan (YYYY-MM-DD); defaults to today in the user's time zone" validate:"omitempty,datetime=2006-01-02"`
+	Localization
+	// Constraints apply to every day, except MaxBudget, which is for the
+	// whole week.
+	Constraints
+}
+
+// PlannedDay is the dinner of one day of a meal plan.
+type PlannedDay struct {
+	Date    string `json:"date"`
+	Weekday string `json:"weekday"`
+	// DayType is "weekday" or "weekend", which decides the day's sales.
+	DayType string `json:"dayType"`
+	// Recipe has OnSale set from the sales of its day.
+	Recipe  Recipe   `json:"recipe"`
+	Repairs []string `json:"repairs,omitempty"`
+}
+
+// MealPlan is a week of dinners and what to buy for them.
+type MealPlan struct {
+	StartDate string       `json:"startDate"`
+	Days      []PlannedDay `json:"days"`
+	// ShoppingList has one item per ingredient across the week, each priced
+	// at the best sale on the days up to the first one it is used.
+	ShoppingList pricing.ShoppingList `json:"shoppingList"`
+}
+
+// DefineMealPlanFlow defines the streaming mealPlanFlow, which plans a week
+// of dinners one day at a time, streaming each day as it is planned. Each day
+// is planned with that day's sales, so a plan spans the weekday and weekend
+// sale windows, and with the leftovers of the days before it, so that
+// ingredients are used up rather than wasted. A day is priced and checked
+// against the budget for what it needs beyond those leftovers. The budget is
+// spread over the days left: each day may spend what is left of it divided
+// by the number of days to go.
+func DefineMealPlanFlow(g *genkit.Genkit, catalog *pricing.Catalog) *core.Flow[MealPlanInput, *MealPlan, *PlannedDay] {
+	getIngredientsOnSale := defineTool(g, catalog)
+
+	return genkit.DefineStreamingFlow(g, "mealPlanFlow",
+		func(ctx context.Context, input MealPlanInput, sendChunk func(context.Context, *PlannedDay) error) (*MealPlan, error) {
+			loc, locale, err := input.resolve()
+			if err != nil {
+				return nil, err
+			}
+			start := time.Now().In(loc)
+			if input.StartDate != "" {
+				if start, err = time.ParseInLocation(pricing.DateLayout, input.StartDate, loc); err != nil {
+					return nil, core.NewError(core.INVALID_ARGUMENT, "startDate must be a YYYY-MM-DD date, got %q", input.StartDate)
+				}
+			}
+			preferences := input.Preferences
+			if preferences == "" {
+				preferences = "a varied week of dinners"
+			}
+
+			plan := &MealPlan{StartDate: start.Format(pricing.DateLayout), Days: []PlannedDay{}}
+			// needs are the ingredients of the days planned so far, and
+			// firstUse the index of the day each is first used on.
+			var needs []pricing.Need
+			firstUse := map[string]int{}
+			daySales := make([][]pricing.Sale, planDays)
+			for i := range planDays {
+				date := start.AddDate(0, 0, i)
+				daySales[i] = catalog.OnSale("", date)
+
+				shopping := shopPlan(needs, firstUse, daySales)
+				c := input.Constraints
+				c.leftovers = leftovers(shopping)
+				if c.MaxBudget > 0 {
+					var spent int64
+					for _, t := range shopping.Totals {
+						if t.Currency == c.currency() {
+							spent = t.Cost
+						}
+					}
+					if c.MaxBudget -= spent; c.MaxBudget <= 0 {
+						return nil, core.NewError(core.FAILED_PRECONDITION, "the budget of %s is spent after %d days", locale.Money(input.MaxBudget, c.currency()), i)
+					}
+					c.MaxBudget /= int64(planDays - i)
+				}
+
+				prompt := fmt.Sprintf(`Plan dinner for %s (%s), day %d of a %d-day meal plan. The user is craving: %s.
+
+Call the getIngredientsOnSale tool to get that day's sales. %s Prefer ingredients left over from earlier days to buying new ones, and do not repeat an earlier dinner.`,
+					date.Format("Monday, "+pricing.DateLayout), pricing.DayType(date), i+1, planDays, preferences, recipeInstructions(locale)) +
+					planSoFar(plan.Days, shopping) + c.prompt(locale)
+
+				priced, err := cook(ctx, g, getIngredientsOnSale, catalog, saleDay{date: date, locale: locale}, prompt, &c, nil)
+				if err != nil {
+					return nil, fmt.Errorf("%s: %w", date.Format(pricing.DateLayout), err)
+				}
+				day := PlannedDay{
+					Date:    date.Format(pricing.DateLayout),
+					Weekday: date.Weekday().String(),
+					DayType: pricing.DayType(date),
+					Recipe:  priced.Recipe,
+					Repairs: priced.Repairs,
+				}
+				plan.Days = append(plan.Days, day)
+				for _, ing := range priced.Ingredients {
+					need := pricing.Need{Name: ing.Name, Quantity: pricing.Quantity{Amount: ing.Amount, Unit: ing.Unit}, OnHand: c.inPantry(ing.Name)}
+					needs = append(needs, need)
+					if _, ok := firstUse[ing.Name]; !ok {
+						firstUse[ing.Name] = i
+					}
+				}
+				if err := sendChunk(ctx, &day); err != nil {
+					return nil, err
+				}
+			}
+
+			plan.ShoppingList = locale.ShoppingList(shopPlan(needs, firstUse, daySales))
+			return plan, nil
+		},
+	)
+}
+
+// shopPlan merges the needs of a meal plan into one shopping list. Each is
+// priced at the best of the sales of daySales up to the day it is first used,
+// since it can be bought on any of them.
+func shopPlan(needs []pricing.Need, firstUse map[string]int, daySales [][]pricing.Sale) pricing.ShoppingList {
+	return pricing.ShopFrom(pricing.Merge(needs), func(n pricing.Need) []pricing.Sale {
+		var sales []pricing.Sale
+		for _, s := range daySales[:firstUse[n.Name]+1] {
+			sales = append(sales, s...)
+		}
+		return sales
+	})
+}
+
+// planSoFar describes the dinners already planned and the ingredients left
+// over from them to the model, or returns "" on the first day.
+func planSoFar(days []PlannedDay, shopping pricing.ShoppingList) string {
+	if len(days) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString("\n\nDinners planned so far:\n")
+	for _, d := range days {
+		fmt.Fprintf(&b, "- %s: %s\n", d.Weekday, d.Recipe.Title)
+	}
+	var lines []string
+	for _, n := range leftovers(shopping) {
+		lines = append(lines, fmt.Sprintf("- %s: %s %s", n.Name, strconv.FormatFloat(n.Quantity.Amount, 'f', -1, 64), n.Quantity.Unit))
+	}
+	if len(lines) > 0 {
+		b.WriteString("Already bought and left over:\n" + strings.Join(lines, "\n") + "\n")
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
+
+// leftovers returns what is bought for the items of shopping but not needed.
+func leftovers(shopping pricing.ShoppingList) []pricing.Need {
+	var left []pricing.Need
+	for _, item := range shopping.Items {
+		if item.Leftover != nil {
+			left = append(left, pricing.Need{Name: item.Name, Quantity: *item.Leftover})
+		}
+	}
+	return left
+}
@@ -0,0 +1,51 @@
+package bargainchef
+
+import (
+	"testing"
+
+	"example/bargainchef/pricing"
+)
+
+func TestPriceRecipeUsesLeftovers(t *testing.T) {
+	sales := [][]pricing.Sale{{
+		{Name: "eggs", Price: pricing.Price{Amount: 349, Currency: "USD", Unit: "dozen"}},
+		{Name: "spinach", Price: pricing.Price{Amount: 199, Currency: "USD", Unit: "bunch"}},
+	}}
+	days := []*Recipe{
+		{Ingredients: []RecipeIngredient{{Name: "eggs", Amount: 4, Unit: "each"}, {Name: "spinach", Amount: 1, Unit: "bunch"}}},
+		{Ingredients: []RecipeIngredient{{Name: "large eggs", Amount: 6, Unit: "each"}}},
+		{Ingredients: []RecipeIngredient{{Name: "eggs", Amount: 10, Unit: "each"}}},
+	}
+	// The first day buys a dozen eggs and leaves 8, which cover the second
+	// day. The third day has the 2 left and buys another dozen.
+	wantCost := []int64{548, 0, 349}
+	wantOnSale := []bool{true, false, true}
+
+	var needs []pricing.Need
+	firstUse := map[string]int{}
+	for i, r := range days {
+		c := Constraints{MaxBudget: 400}
+		c.leftovers = leftovers(shopPlan(needs, firstUse, sales))
+		priced := priceRecipe(r, sales[0], &c)
+		var cost int64
+		for _, total := range priced.ShoppingList.Totals {
+			cost += total.Cost
+		}
+		if cost != wantCost[i] {
+			t.Errorf("day %d costs %d, want %d", i+1, cost, wantCost[i])
+		}
+		if got := priced.Ingredients[0].OnSale; got != wantOnSale[i] {
+			t.Errorf("day %d: eggs on sale = %v, want %v", i+1, got, wantOnSale[i])
+		}
+		// Only the first day, which buys the spinach too, is over budget.
+		if v := c.check(priced, pricing.Locale{}); (len(v) > 0) != (i == 0) {
+			t.Errorf("day %d: budget violations %v", i+1, v)
+		}
+		for _, ing := range r.Ingredients {
+			needs = append(needs, pricing.Need{Name: ing.Name, Quantity: pricing.Quantity{Amount: ing.Amount, Unit: ing.Unit}})
+			if _, ok := firstUse[ing.Name]; !ok {
+				firstUse[ing.Name] = 0
+			}
+		}
+	}
+}
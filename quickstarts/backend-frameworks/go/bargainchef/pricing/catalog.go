@@ -0,0 +1,235 @@
+package pricing
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNotFound is returned when a flyer does not exist.
+var ErrNotFound = errors.New("flyer not found")
+
+//go:embed default_catalog.json
+var defaultCatalog []byte
+
+// Catalog is a set of flyers, optionally persisted to a JSON file. It is safe
+// for concurrent use.
+type Catalog struct {
+	mu     sync.RWMutex
+	path   string
+	flyers []Flyer
+}
+
+// catalogFile is the format of catalog files.
+type catalogFile struct {
+	Flyers []Flyer `json:"flyers"`
+}
+
+// Open loads the catalog in the JSON file at path. If the file does not exist
+// yet, the catalog starts with the built-in sample flyers and the file is
+// created on the first change. If path is empty, the catalog is only kept in
+// memory.
+func Open(path string) (*Catalog, error) {
+	data := defaultCatalog
+	if path != "" {
+		d, err := os.ReadFile(path)
+		if err == nil {
+			data = d
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	}
+	var file catalogFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("invalid catalog %s: %w", path, err)
+	}
+	c := &Catalog{path: path}
+	for _, f := range file.Flyers {
+		if err := f.normalize(); err != nil {
+			return nil, fmt.Errorf("invalid flyer %q in catalog %s: %w", f.ID, path, err)
+		}
+		if f.ID == "" {
+			f.ID = c.newID(f)
+		}
+		c.flyers = append(c.flyers, f)
+	}
+	return c, nil
+}
+
+// Flyers returns every flyer in the catalog.
+func (c *Catalog) Flyers() []Flyer {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return append([]Flyer{}, c.flyers...)
+}
+
+// Add validates f, assigns it an ID if it has none, and adds it to the
+// catalog, replacing the flyer with the same ID if there is one.
+func (c *Catalog) Add(f Flyer) (Flyer, error) {
+	if err := f.normalize(); err != nil {
+		return Flyer{}, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if f.ID == "" {
+		f.ID = c.newID(f)
+	} else if !idPattern.MatchString(f.ID) {
+		return Flyer{}, fmt.Errorf("invalid flyer ID %q", f.ID)
+	}
+	flyers := append([]Flyer{}, c.flyers...)
+	replaced := false
+	for i := range flyers {
+		if flyers[i].ID == f.ID {
+			flyers[i] = f
+			replaced = true
+		}
+	}
+	if !replaced {
+		flyers = append(flyers, f)
+	}
+	if err := c.save(flyers); err != nil {
+		return Flyer{}, err
+	}
+	c.flyers = flyers
+	return f, nil
+}
+
+// Delete removes the flyer with the given ID.
+func (c *Catalog) Delete(id string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var flyers []Flyer
+	for _, f := range c.flyers {
+		if f.ID != id {
+			flyers = append(flyers, f)
+		}
+	}
+	if len(flyers) == len(c.flyers) {
+		return ErrNotFound
+	}
+	if err := c.save(flyers); err != nil {
+		return err
+	}
+	c.flyers = flyers
+	return nil
+}
+
+// OnSale returns the ingredients on sale on date, sorted by store and name,
+// at the given store or at every store if store is empty. Only the date of
+// date is used, in its own location. If several flyers of a store have the
+// same ingredient, the lowest price wins.
+func (c *Catalog) OnSale(store string, date time.Time) []Sale {
+	day := date.Format(DateLayout)
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	type key struct{ store, name string }
+	best := map[key]Sale{}
+	for _, f := range c.flyers {
+		if store != "" && !strings.EqualFold(f.Store, store) {
+			continue
+		}
+		if !f.validOn(day, date.Weekday()) {
+			continue
+		}
+		for _, item := range f.Items {
+			k := key{f.Store, item.Name}
+			if s, ok := best[k]; ok && s.Price.Currency == item.Price.Currency && s.Price.Amount <= item.Price.Amount {
+				continue
+			}
+			best[k] = Sale{
+				Name:         item.Name,
+				Store:        f.Store,
+				Price:        item.Price,
+				RegularPrice: item.RegularPrice,
+				Display:      item.Price.String(),
+				ValidTo:      f.ValidTo,
+			}
+		}
+	}
+
+	sales := make([]Sale, 0, len(best))
+	for _, s := range best {
+		sales = append(sales, s)
+	}
+	sort.Slice(sales, func(i, j int) bool {
+		if sales[i].Store != sales[j].Store {
+			return sales[i].Store < sales[j].Store
+		}
+		return sales[i].Name < sales[j].Name
+	})
+	return sales
+}
+
+var (
+	idPattern  = regexp.MustCompile(`^[a-z0-9-]+$`)
+	nonIDChars = regexp.MustCompile(`[^a-z0-9]+`)
+)
+
+// newID derives a readable ID from the flyer's store and start date, such
+// as "corner-market-2025-06-02", adding a number if it is taken.
+func (c *Catalog) newID(f Flyer) string {
+	base := strings.Trim(nonIDChars.ReplaceAllString(strings.ToLower(f.Store), "-"), "-")
+	if base == "" {
+		base = "flyer"
+	}
+	if f.ValidFrom != "" {
+		base += "-" + f.ValidFrom
+	}
+	id := base
+	for n := 2; c.hasID(id); n++ {
+		id = fmt.Sprintf("%s-%d", base, n)
+	}
+	return id
+}
+
+func (c *Catalog) hasID(id string) bool {
+	for _, f := range c.flyers {
+		if f.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// save writes flyers to the catalog file, if there is one.
+func (c *Catalog) save(flyers []Flyer) error {
+	if c.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(catalogFile{Flyers: flyers}, "", "  ")
+	if err != nil {
+		return err
+	}
+	// Write to a temporary file and rename it so that the catalog is never
+	// left half written.
+	tmp, err := os.CreateTemp(filepath.Dir(c.path), ".catalog-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := tmp.Chmod(0o644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), c.path)
+}
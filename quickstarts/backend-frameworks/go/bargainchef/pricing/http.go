@@ -0,0 +1,87 @@
+package pricing
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// AdminHandler returns the catalog's admin API. Every request must carry the
+// header "Authorization: Bearer <token>"; if token is empty, the API is
+// disabled. It serves:
+//
+//	GET    /admin/flyers       lists the flyers
+//	POST   /admin/flyers       adds a flyer, or replaces the flyer with its ID
+//	DELETE /admin/flyers/{id}  deletes a flyer
+//	GET    /admin/sales        lists the sales on ?date= (default today) at ?store=
+func (c *Catalog) AdminHandler(token string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /admin/flyers", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, c.Flyers())
+	})
+	mux.HandleFunc("POST /admin/flyers", func(w http.ResponseWriter, r *http.Request) {
+		var f Flyer
+		dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&f); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid flyer: "+err.Error())
+			return
+		}
+		f, err := c.Add(f)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusCreated, f)
+	})
+	mux.HandleFunc("DELETE /admin/flyers/{id}", func(w http.ResponseWriter, r *http.Request) {
+		err := c.Delete(r.PathValue("id"))
+		if errors.Is(err, ErrNotFound) {
+			writeError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("GET /admin/sales", func(w http.ResponseWriter, r *http.Request) {
+		date := time.Now()
+		if d := r.URL.Query().Get("date"); d != "" {
+			var err error
+			if date, err = time.Parse(DateLayout, d); err != nil {
+				writeError(w, http.StatusBadRequest, "date must be a YYYY-MM-DD date")
+				return
+			}
+		}
+		writeJSON(w, http.StatusOK, c.OnSale(r.URL.Query().Get("store"), date))
+	})
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token == "" {
+			writeError(w, http.StatusForbidden, "the admin API is disabled")
+			return
+		}
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, http.StatusUnauthorized, "invalid or missing admin token")
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
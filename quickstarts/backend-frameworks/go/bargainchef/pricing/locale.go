@@ -0,0 +1,236 @@
+package pricing
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Locale formats money and quantities for a language and region, e.g.
+// "$1,299.00" and "1.5 lb" for en-US, or "1.299,00 €" and "680 g" for de-DE.
+// It knows the conventions of English, French, German, Spanish, Italian and
+// Japanese; other languages are formatted like English.
+type Locale struct {
+	// Tag is the BCP 47 language tag, e.g. "en-US".
+	Tag string
+	// decimal and group separate the fraction and the thousands.
+	decimal, group string
+	// symbolAfter puts the currency symbol after the amount.
+	symbolAfter bool
+	// home is the currency of the region, which gets the short symbol, e.g.
+	// "$" rather than "CA$" in Canada.
+	home string
+	// imperial shows weights in oz and lb and volumes in tsp, tbsp and cups.
+	imperial bool
+}
+
+// DefaultLocale is the locale used when the user's is not known.
+var DefaultLocale, _ = ParseLocale("en-US")
+
+// languageFormats are the number formats of each language: decimal
+// separator, group separator and whether the currency symbol goes after.
+var languageFormats = map[string]struct {
+	decimal, group string
+	symbolAfter    bool
+}{
+	"en": {".", ",", false},
+	"ja": {".", ",", false},
+	"fr": {",", "\u00a0", true},
+	"de": {",", ".", true},
+	"es": {",", ".", true},
+	"it": {",", ".", true},
+}
+
+// defaultRegions are the regions assumed for a language without one.
+var defaultRegions = map[string]string{"en": "US", "ja": "JP", "fr": "FR", "de": "DE", "es": "ES", "it": "IT"}
+
+// regionCurrencies are the currencies of the regions that use a currency
+// with a symbol.
+var regionCurrencies = map[string]string{
+	"US": "USD", "CA": "CAD", "AU": "AUD", "NZ": "NZD", "MX": "MXN", "GB": "GBP", "JP": "JPY",
+	"FR": "EUR", "DE": "EUR", "AT": "EUR", "BE": "EUR", "ES": "EUR", "IT": "EUR", "IE": "EUR", "NL": "EUR", "PT": "EUR", "FI": "EUR", "LU": "EUR",
+}
+
+// foreignSymbols are the symbols of dollar currencies outside their region.
+var foreignSymbols = map[string]string{"USD": "US$", "CAD": "CA$", "AUD": "A$", "NZD": "NZ$", "MXN": "MX$"}
+
+// imperialRegions use US customary units in the kitchen.
+var imperialRegions = map[string]bool{"US": true, "LR": true, "MM": true}
+
+var localePattern = regexp.MustCompile(`^([a-zA-Z]{2,3})(?:[-_][a-zA-Z]{4})?(?:[-_]([a-zA-Z]{2}|[0-9]{3}))?$`)
+
+// ParseLocale returns the locale of a BCP 47 language tag such as "fr-CA" or
+// "de". Without a region, the language's main one is assumed.
+func ParseLocale(tag string) (Locale, error) {
+	m := localePattern.FindStringSubmatch(strings.TrimSpace(tag))
+	if m == nil {
+		return Locale{}, fmt.Errorf("locale must be a BCP 47 language tag such as en-US, got %q", tag)
+	}
+	lang, region := strings.ToLower(m[1]), strings.ToUpper(m[2])
+	if region == "" {
+		region = defaultRegions[lang]
+	}
+	format, ok := languageFormats[lang]
+	if !ok {
+		format = languageFormats["en"]
+	}
+	l := Locale{
+		Tag:         lang,
+		decimal:     format.decimal,
+		group:       format.group,
+		symbolAfter: format.symbolAfter,
+		home:        regionCurrencies[region],
+		imperial:    imperialRegions[region],
+	}
+	if region != "" {
+		l.Tag += "-" + region
+	}
+	return l, nil
+}
+
+// Imperial reports whether the locale uses US customary units in the
+// kitchen rather than metric ones.
+func (l Locale) Imperial() bool {
+	return l.imperial
+}
+
+// Money formats an amount in the minor unit of currency, e.g. "$2.99".
+func (l Locale) Money(amount int64, currency string) string {
+	sign := ""
+	if amount < 0 {
+		sign, amount = "-", -amount
+	}
+	var number string
+	if zeroDecimalCurrencies[currency] {
+		number = l.groupDigits(amount)
+	} else {
+		number = fmt.Sprintf("%s%s%02d", l.groupDigits(amount/100), l.decimal, amount%100)
+	}
+
+	symbol, ok := currencySymbols[currency]
+	switch {
+	case currency != l.home && foreignSymbols[currency] != "":
+		symbol = foreignSymbols[currency]
+	case !ok:
+		// Codes are separated from the number, e.g. "CHF 2.99". Separators
+		// are no-break spaces, so that amounts are not split across lines.
+		symbol = currency
+		if !l.symbolAfter {
+			return sign + symbol + "\u00a0" + number
+		}
+	}
+	if l.symbolAfter {
+		return sign + number + "\u00a0" + symbol
+	}
+	return sign + symbol + number
+}
+
+func (l Locale) groupDigits(n int64) string {
+	s := strconv.FormatInt(n, 10)
+	for i := len(s) - 3; i > 0; i -= 3 {
+		s = s[:i] + l.group + s[i:]
+	}
+	return s
+}
+
+// Price formats a price, e.g. "$2.99/lb". Weight prices are shown per kg in
+// metric locales and per lb in imperial ones.
+func (l Locale) Price(p Price) string {
+	switch {
+	case p.Unit == "lb" && !l.imperial:
+		p.Amount = int64(math.Round(float64(p.Amount) * 1000 / 453.59237))
+		p.Unit = "kg"
+	case (p.Unit == "kg" || p.Unit == "100g") && l.imperial:
+		p.Amount = int64(math.Round(float64(p.Amount) * 453.59237 / (units[p.Unit].factor)))
+		p.Unit = "lb"
+	}
+	amount := l.Money(p.Amount, p.Currency)
+	if p.Unit == "each" {
+		return amount + " each"
+	}
+	return amount + "/" + p.Unit
+}
+
+// Quantity formats a quantity in the locale's kitchen units, e.g. "1.5 lb"
+// or "680 g".
+func (l Locale) Quantity(q Quantity) string {
+	q = Normalize(q)
+	switch {
+	case q.Unit == "g" && l.imperial:
+		if oz := q.Amount / units["oz"].factor; oz < 16 {
+			return l.number(oz, 1) + " oz"
+		}
+		return l.number(q.Amount/units["lb"].factor, 2) + " lb"
+	case q.Unit == "g":
+		if q.Amount >= 1000 {
+			return l.number(q.Amount/1000, 2) + " kg"
+		}
+		return l.number(q.Amount, 0) + " g"
+	case q.Unit == "ml" && l.imperial:
+		switch {
+		case q.Amount < units["tbsp"].factor:
+			return l.number(q.Amount/units["tsp"].factor, 1) + " tsp"
+		case q.Amount < units["cup"].factor/4:
+			return l.number(q.Amount/units["tbsp"].factor, 1) + " tbsp"
+		}
+		return l.number(q.Amount/units["cup"].factor, 2) + " cup"
+	case q.Unit == "ml":
+		if q.Amount >= 1000 {
+			return l.number(q.Amount/1000, 2) + " l"
+		}
+		return l.number(q.Amount, 0) + " ml"
+	case q.Unit == "each":
+		return l.number(q.Amount, 2)
+	}
+	return l.number(q.Amount, 2) + " " + q.Unit
+}
+
+// number formats x with at most the given number of decimals.
+func (l Locale) number(x float64, decimals int) string {
+	s := strconv.FormatFloat(round(x, decimals), 'f', -1, 64)
+	return strings.Replace(s, ".", l.decimal, 1)
+}
+
+// Sales returns sales with their display prices formatted for the locale.
+func (l Locale) Sales(sales []Sale) []Sale {
+	localized := make([]Sale, len(sales))
+	for i, s := range sales {
+		s.Display = l.Price(s.Price)
+		localized[i] = s
+	}
+	return localized
+}
+
+// ShoppingList returns list with its display strings formatted for the
+// locale and the quantities needed shown in its kitchen units.
+func (l Locale) ShoppingList(list ShoppingList) ShoppingList {
+	localized := ShoppingList{Items: make([]ShoppingItem, len(list.Items)), Totals: make([]Total, len(list.Totals))}
+	for i, item := range list.Items {
+		item.NeedDisplay = l.Quantity(item.Need)
+		if item.Sale != nil {
+			sale := *item.Sale
+			sale.Display = l.Price(sale.Price)
+			item.Sale = &sale
+			item.CostDisplay = l.Money(item.Cost, sale.Price.Currency)
+		}
+		localized.Items[i] = item
+	}
+	for i, t := range list.Totals {
+		t.CostDisplay = l.Money(t.Cost, t.Currency)
+		t.SavingsDisplay = l.Money(t.Savings, t.Currency)
+		localized.Totals[i] = t
+	}
+	return localized
+}
+
+// DayType returns "weekend" for Saturdays and Sundays and "weekday" for
+// other days, in the location of t.
+func DayType(t time.Time) string {
+	if d := t.Weekday(); d == time.Saturday || d == time.Sunday {
+		return "weekend"
+	}
+	return "weekday"
+}
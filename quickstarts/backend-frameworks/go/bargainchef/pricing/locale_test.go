@@ -0,0 +1,127 @@
+package pricing
+
+import "testing"
+
+func TestParseLocale(t *testing.T) {
+	tests := []struct {
+		tag, want string
+		imperial  bool
+	}{
+		{"en-US", "en-US", true},
+		{"en_gb", "en-GB", false},
+		{"de", "de-DE", false},
+		{"zh-Hant-TW", "zh-TW", false},
+		{"es-419", "es-419", false},
+		{"xx", "xx", false},
+	}
+	for _, tt := range tests {
+		l, err := ParseLocale(tt.tag)
+		if err != nil {
+			t.Errorf("ParseLocale(%q): %v", tt.tag, err)
+			continue
+		}
+		if l.Tag != tt.want || l.Imperial() != tt.imperial {
+			t.Errorf("ParseLocale(%q) = %s, imperial %v; want %s, imperial %v", tt.tag, l.Tag, l.Imperial(), tt.want, tt.imperial)
+		}
+	}
+	for _, tag := range []string{"", "english", "en-USA-x"} {
+		if _, err := ParseLocale(tag); err == nil {
+			t.Errorf("ParseLocale(%q) succeeded", tag)
+		}
+	}
+}
+
+func TestMoney(t *testing.T) {
+	tests := []struct {
+		tag      string
+		amount   int64
+		currency string
+		want     string
+	}{
+		{"en-US", 129900, "USD", "$1,299.00"},
+		{"en-US", -250, "USD", "-$2.50"},
+		{"de-DE", 129900, "EUR", "1.299,00\u00a0€"},
+		{"fr-FR", 129900, "EUR", "1\u00a0299,00\u00a0€"},
+		{"en-US", 299, "CAD", "CA$2.99"},
+		{"en-CA", 299, "CAD", "$2.99"},
+		{"fr-CA", 299, "CAD", "2,99\u00a0$"},
+		{"en-CA", 299, "USD", "US$2.99"},
+		{"en-NZ", 299, "NZD", "$2.99"},
+		{"en-AU", 299, "NZD", "NZ$2.99"},
+		{"ja-JP", 1299, "JPY", "¥1,299"},
+		{"en-US", 1299, "JPY", "¥1,299"},
+		{"de-DE", 1299, "JPY", "1.299\u00a0¥"},
+		{"en-US", 299, "CHF", "CHF\u00a02.99"},
+		{"de-DE", 299, "CHF", "2,99\u00a0CHF"},
+	}
+	for _, tt := range tests {
+		l, err := ParseLocale(tt.tag)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := l.Money(tt.amount, tt.currency); got != tt.want {
+			t.Errorf("%s Money(%d, %s) = %q, want %q", tt.tag, tt.amount, tt.currency, got, tt.want)
+		}
+	}
+}
+
+func TestRegionCurrenciesHaveSymbols(t *testing.T) {
+	for region, currency := range regionCurrencies {
+		if currencySymbols[currency] == "" {
+			t.Errorf("%s uses %s, which has no symbol", region, currency)
+		}
+	}
+}
+
+func TestPrice(t *testing.T) {
+	tests := []struct {
+		tag   string
+		price Price
+		want  string
+	}{
+		{"en-US", Price{299, "USD", "lb"}, "$2.99/lb"},
+		{"de-DE", Price{299, "EUR", "lb"}, "6,59\u00a0€/kg"},
+		{"en-US", Price{659, "USD", "kg"}, "$2.99/lb"},
+		{"en-US", Price{100, "USD", "100g"}, "$4.54/lb"},
+		{"en-GB", Price{50, "GBP", "each"}, "£0.50 each"},
+		{"ja-JP", Price{198, "JPY", "pack"}, "¥198/pack"},
+	}
+	for _, tt := range tests {
+		l, err := ParseLocale(tt.tag)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := l.Price(tt.price); got != tt.want {
+			t.Errorf("%s Price(%+v) = %q, want %q", tt.tag, tt.price, got, tt.want)
+		}
+	}
+}
+
+func TestQuantity(t *testing.T) {
+	tests := []struct {
+		tag  string
+		q    Quantity
+		want string
+	}{
+		{"en-US", Quantity{1.5, "lb"}, "1.5 lb"},
+		{"de-DE", Quantity{1.5, "lb"}, "680 g"},
+		{"de-DE", Quantity{3, "lb"}, "1,36 kg"},
+		{"en-US", Quantity{200, "g"}, "7.1 oz"},
+		{"en-US", Quantity{2, "tsp"}, "2 tsp"},
+		{"en-US", Quantity{3, "tbsp"}, "3 tbsp"},
+		{"en-US", Quantity{500, "ml"}, "2.11 cup"},
+		{"fr-FR", Quantity{1.5, "cup"}, "355 ml"},
+		{"it-IT", Quantity{1500, "ml"}, "1,5 l"},
+		{"en-US", Quantity{1, "dozen"}, "12"},
+		{"en-US", Quantity{2, "cans"}, "2 can"},
+	}
+	for _, tt := range tests {
+		l, err := ParseLocale(tt.tag)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := l.Quantity(tt.q); got != tt.want {
+			t.Errorf("%s Quantity(%+v) = %q, want %q", tt.tag, tt.q, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+// Package pricing is a small grocery pricing store: a catalog of weekly sale
+// flyers, each valid at one store over a range of dates, that can be queried
+// for the ingredients on sale on a given day.
+package pricing
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// DateLayout is the format of dates in flyers and queries.
+const DateLayout = "2006-01-02"
+
+// Units are the units prices can be given in.
+var Units = []string{"each", "lb", "kg", "100g", "dozen", "head", "bunch", "pack", "can", "bottle"}
+
+// Price is an amount of money per unit.
+type Price struct {
+	// Amount is in the minor unit of the currency, e.g. cents.
+	Amount int64 `json:"amount" jsonschema:"description=The price in the minor unit of the currency, e.g. cents"`
+	// Currency is an ISO 4217 code such as USD.
+	Currency string `json:"currency" jsonschema:"description=The ISO 4217 currency code"`
+	Unit     string `json:"unit" jsonschema:"description=What the price is for, e.g. lb or each"`
+}
+
+// currencySymbols are the short symbols of currencies. Every currency of
+// regionCurrencies has one.
+var currencySymbols = map[string]string{"USD": "$", "CAD": "$", "AUD": "$", "NZD": "$", "MXN": "$", "EUR": "€", "GBP": "£", "JPY": "¥"}
+
+// zeroDecimalCurrencies have no minor unit.
+var zeroDecimalCurrencies = map[string]bool{"JPY": true, "KRW": true}
+
+// String formats p for people, e.g. "$2.99/lb" or "$0.50 each".
+func (p Price) String() string {
+	amount := p.amount()
+	if p.Unit == "each" {
+		return amount + " each"
+	}
+	return amount + "/" + p.Unit
+}
+
+// amount formats the amount of p without its unit, e.g. "$2.99".
+func (p Price) amount() string {
+	var amount string
+	if zeroDecimalCurrencies[p.Currency] {
+		amount = fmt.Sprintf("%d", p.Amount)
+	} else {
+		amount = fmt.Sprintf("%d.%02d", p.Amount/100, p.Amount%100)
+	}
+	if symbol, ok := currencySymbols[p.Currency]; ok {
+		return symbol + amount
+	}
+	return p.Currency + " " + amount
+}
+
+// Flyer is a store's list of sale prices.
+type Flyer struct {
+	// ID is assigned when the flyer is added to a catalog, if it is empty.
+	ID    string `json:"id"`
+	Store string `json:"store"`
+	// ValidFrom and ValidTo are the first and last days of the sale. Either
+	// may be empty for an open-ended sale.
+	ValidFrom string `json:"validFrom,omitempty"`
+	ValidTo   string `json:"validTo,omitempty"`
+	// Days limits the sale to some days of the week, e.g. "saturday". The
+	// sale is valid every day if it is empty.
+	Days []string `json:"days,omitempty"`
+	// Currency is the currency of the item prices that do not have one.
+	Currency string `json:"currency,omitempty"`
+	Items    []Item `json:"items"`
+}
+
+// Item is an ingredient on sale.
+type Item struct {
+	Name  string `json:"name"`
+	Price Price  `json:"price"`
+	// RegularPrice is the price outside of the sale, if known.
+	RegularPrice *Price `json:"regularPrice,omitempty"`
+}
+
+// Sale is an ingredient on sale at a store on some day.
+type Sale struct {
+	Name         string `json:"name" jsonschema:"description=The ingredient name"`
+	Store        string `json:"store" jsonschema:"description=The store with the sale"`
+	Price        Price  `json:"price" jsonschema:"description=The sale price"`
+	RegularPrice *Price `json:"regularPrice,omitempty" jsonschema:"description=The price outside of the sale, if known"`
+	// Display is the sale price formatted for people, e.g. "$2.99/lb".
+	Display string `json:"display" jsonschema:"description=The sale price formatted for people"`
+	// ValidTo is the last day of the sale, if it ends.
+	ValidTo string `json:"validTo,omitempty" jsonschema:"description=The last day of the sale (YYYY-MM-DD), if it ends"`
+}
+
+var (
+	currencyPattern = regexp.MustCompile(`^[A-Z]{3}$`)
+	weekdays        = map[string]time.Weekday{}
+)
+
+func init() {
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		weekdays[strings.ToLower(d.String())] = d
+	}
+}
+
+// normalize checks that f is valid, fills in item currencies from the
+// flyer's and lowercases names and days.
+func (f *Flyer) normalize() error {
+	f.Store = strings.TrimSpace(f.Store)
+	if f.Store == "" {
+		return errors.New("store is required")
+	}
+	var from, to time.Time
+	var err error
+	if f.ValidFrom != "" {
+		if from, err = time.Parse(DateLayout, f.ValidFrom); err != nil {
+			return fmt.Errorf("validFrom must be a YYYY-MM-DD date, got %q", f.ValidFrom)
+		}
+	}
+	if f.ValidTo != "" {
+		if to, err = time.Parse(DateLayout, f.ValidTo); err != nil {
+			return fmt.Errorf("validTo must be a YYYY-MM-DD date, got %q", f.ValidTo)
+		}
+	}
+	if f.ValidFrom != "" && f.ValidTo != "" && to.Before(from) {
+		return fmt.Errorf("validTo %s is before validFrom %s", f.ValidTo, f.ValidFrom)
+	}
+	for i, d := range f.Days {
+		f.Days[i] = strings.ToLower(strings.TrimSpace(d))
+		if _, ok := weekdays[f.Days[i]]; !ok {
+			return fmt.Errorf("unknown day %q", d)
+		}
+	}
+	if len(f.Items) == 0 {
+		return errors.New("a flyer needs at least one item")
+	}
+	for i := range f.Items {
+		item := &f.Items[i]
+		item.Name = strings.ToLower(strings.TrimSpace(item.Name))
+		if item.Name == "" {
+			return fmt.Errorf("item %d has no name", i+1)
+		}
+		if err := f.normalizePrice(&item.Price); err != nil {
+			return fmt.Errorf("%s: %w", item.Name, err)
+		}
+		if item.RegularPrice != nil {
+			if err := f.normalizePrice(item.RegularPrice); err != nil {
+				return fmt.Errorf("%s: regular price: %w", item.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (f *Flyer) normalizePrice(p *Price) error {
+	if p.Currency == "" {
+		p.Currency = f.Currency
+	}
+	if !currencyPattern.MatchString(p.Currency) {
+		return fmt.Errorf("currency must be an ISO 4217 code such as USD, got %q", p.Currency)
+	}
+	if p.Amount <= 0 {
+		return errors.New("price must be positive")
+	}
+	if !slices.Contains(Units, p.Unit) {
+		return fmt.Errorf("unit must be one of %s, got %q", strings.Join(Units, ", "), p.Unit)
+	}
+	return nil
+}
+
+// validOn reports whether the flyer's sale is on on the given date, which is
+// formatted with DateLayout.
+func (f *Flyer) validOn(date string, day time.Weekday) bool {
+	// Dates in DateLayout compare chronologically as strings.
+	if f.ValidFrom != "" && date < f.ValidFrom {
+		return false
+	}
+	if f.ValidTo != "" && date > f.ValidTo {
+		return false
+	}
+	if len(f.Days) == 0 {
+		return true
+	}
+	return slices.ContainsFunc(f.Days, func(d string) bool { return weekdays[d] == day })
+}
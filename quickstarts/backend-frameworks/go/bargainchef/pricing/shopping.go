@@ -0,0 +1,405 @@
+package pricing
+
+import (
+	"math"
+	"slices"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Quantity is an amount of an ingredient.
+type Quantity struct {
+	Amount float64 `json:"amount"`
+	Unit   string  `json:"unit"`
+}
+
+// Need is an ingredient a recipe calls for.
+type Need struct {
+	Name     string
+	Quantity Quantity
+	// OnHand is set if the ingredient is already in the pantry, in which case
+	// it is not bought.
+	OnHand bool
+}
+
+// ShoppingList is what to buy for a recipe and what it costs.
+type ShoppingList struct {
+	Items []ShoppingItem `json:"items"`
+	// Totals are the cost of the items on sale and the savings against their
+	// regular prices, one per currency.
+	Totals []Total `json:"totals"`
+}
+
+// ShoppingItem is an ingredient to buy.
+type ShoppingItem struct {
+	Name string `json:"name"`
+	// Need is the quantity the recipe calls for, in g for weights, ml for
+	// volumes and each for counts. Other units, like cans, are kept.
+	Need Quantity `json:"need"`
+	// NeedDisplay is the quantity needed in the user's kitchen units, e.g.
+	// "1.5 lb". It is set by Locale.ShoppingList.
+	NeedDisplay string `json:"needDisplay,omitempty"`
+	// OnHand is set if the ingredient is already in the pantry.
+	OnHand bool `json:"onHand,omitempty"`
+	// Sale is the sale the ingredient was matched with, if any. The other
+	// fields are only set if there is one.
+	Sale *Sale `json:"sale,omitempty"`
+	// Buy is how much to buy, in the unit of the sale price.
+	Buy *Quantity `json:"buy,omitempty"`
+	// Estimated is set if the quantity needed could not be converted to the
+	// unit of the sale price, in which case one unit is bought.
+	Estimated bool `json:"estimated,omitempty"`
+	// Leftover is how much of what is bought is not needed, in the unit of
+	// the sale price, if it is known and any is.
+	Leftover *Quantity `json:"leftover,omitempty"`
+	// Cost and Savings are in the minor unit of the sale's currency.
+	Cost        int64  `json:"cost,omitempty"`
+	CostDisplay string `json:"costDisplay,omitempty"`
+	Savings     int64  `json:"savings,omitempty"`
+}
+
+// Total is the cost of the items on sale in one currency.
+type Total struct {
+	Currency       string `json:"currency"`
+	Cost           int64  `json:"cost"`
+	CostDisplay    string `json:"costDisplay"`
+	Savings        int64  `json:"savings"`
+	SavingsDisplay string `json:"savingsDisplay"`
+}
+
+type dimension int
+
+const (
+	weight dimension = iota
+	volume
+	count
+	// container units, like cans, only convert to themselves.
+	container
+)
+
+type unit struct {
+	dim dimension
+	// factor converts the unit to g, ml or each.
+	factor float64
+}
+
+// CookingUnits are the units recipe quantities can be given in.
+var CookingUnits = []string{"g", "kg", "100g", "oz", "lb", "ml", "l", "tsp", "tbsp", "cup", "each", "dozen", "clove", "head", "bunch", "pack", "can", "bottle"}
+
+var units = map[string]unit{
+	"g":      {weight, 1},
+	"kg":     {weight, 1000},
+	"100g":   {weight, 100},
+	"oz":     {weight, 28.349523125},
+	"lb":     {weight, 453.59237},
+	"ml":     {volume, 1},
+	"l":      {volume, 1000},
+	"tsp":    {volume, 4.92892159375},
+	"tbsp":   {volume, 14.78676478125},
+	"cup":    {volume, 236.5882365},
+	"each":   {count, 1},
+	"dozen":  {count, 12},
+	"clove":  {container, 1},
+	"head":   {container, 1},
+	"bunch":  {container, 1},
+	"pack":   {container, 1},
+	"can":    {container, 1},
+	"bottle": {container, 1},
+}
+
+var unitAliases = map[string]string{
+	"": "each", "gram": "g", "grams": "g", "kilogram": "kg", "kilograms": "kg", "ounce": "oz", "ounces": "oz",
+	"lbs": "lb", "pound": "lb", "pounds": "lb", "milliliter": "ml", "milliliters": "ml", "liter": "l", "liters": "l",
+	"teaspoon": "tsp", "teaspoons": "tsp", "tablespoon": "tbsp", "tablespoons": "tbsp", "cups": "cup",
+	"piece": "each", "pieces": "each", "whole": "each", "cloves": "clove", "heads": "head", "bunches": "bunch",
+	"packs": "pack", "package": "pack", "packages": "pack", "cans": "can", "bottles": "bottle",
+}
+
+// canonicalUnit returns the unit name in units for a unit as written, or ""
+// if it is unknown.
+func canonicalUnit(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if alias, ok := unitAliases[name]; ok {
+		name = alias
+	}
+	if _, ok := units[name]; ok {
+		return name
+	}
+	return ""
+}
+
+// Normalize converts q to g, ml or each if it is a weight, volume or count.
+// Quantities in other units are returned with the unit's canonical name.
+func Normalize(q Quantity) Quantity {
+	name := canonicalUnit(q.Unit)
+	u, ok := units[name]
+	if !ok {
+		return q
+	}
+	switch u.dim {
+	case weight:
+		return Quantity{round(q.Amount*u.factor, 1), "g"}
+	case volume:
+		return Quantity{round(q.Amount*u.factor, 1), "ml"}
+	case count:
+		return Quantity{q.Amount * u.factor, "each"}
+	default:
+		return Quantity{q.Amount, name}
+	}
+}
+
+// convert converts q to the given unit and reports whether that is possible.
+func convert(q Quantity, to string) (float64, bool) {
+	fromName, toName := canonicalUnit(q.Unit), canonicalUnit(to)
+	from, ok1 := units[fromName]
+	dest, ok2 := units[toName]
+	if !ok1 || !ok2 || from.dim != dest.dim || (from.dim == container && fromName != toName) {
+		return 0, false
+	}
+	return q.Amount * from.factor / dest.factor, true
+}
+
+// Shop matches every need that is not on hand with the best sale for it and
+// works out how much to buy and what it costs. Weights and volumes are bought
+// in fractions of the sale unit, rounded to two decimals; everything else is
+// bought whole.
+func Shop(needs []Need, sales []Sale) ShoppingList {
+	return ShopFrom(needs, func(Need) []Sale { return sales })
+}
+
+// ShopFrom is like Shop, but matches each need with the sales salesFor
+// returns for it, such as the sales on the days before it is needed.
+func ShopFrom(needs []Need, salesFor func(Need) []Sale) ShoppingList {
+	list := ShoppingList{Items: []ShoppingItem{}, Totals: []Total{}}
+	totals := map[string]*Total{}
+	for _, need := range needs {
+		item := ShoppingItem{Name: need.Name, Need: Normalize(need.Quantity), OnHand: need.OnHand}
+		var sale *Sale
+		if !need.OnHand {
+			sale = match(need.Name, salesFor(need))
+		}
+		if sale == nil {
+			list.Items = append(list.Items, item)
+			continue
+		}
+		item.Sale = sale
+
+		needed, ok := convert(need.Quantity, sale.Price.Unit)
+		if !ok || needed <= 0 {
+			needed, item.Estimated = 1, true
+		}
+		var amount float64
+		switch units[canonicalUnit(sale.Price.Unit)].dim {
+		case weight, volume:
+			amount = math.Max(round(needed, 2), 0.01)
+		default:
+			amount = math.Ceil(needed - 1e-9)
+		}
+		item.Buy = &Quantity{amount, sale.Price.Unit}
+		if left := round(amount-needed, 2); !item.Estimated && left > 0 {
+			item.Leftover = &Quantity{left, sale.Price.Unit}
+		}
+		item.Cost = int64(math.Round(amount * float64(sale.Price.Amount)))
+		item.CostDisplay = Price{Amount: item.Cost, Currency: sale.Price.Currency}.amount()
+		if r := sale.RegularPrice; r != nil && r.Currency == sale.Price.Currency && r.Unit == sale.Price.Unit && r.Amount > sale.Price.Amount {
+			item.Savings = int64(math.Round(amount * float64(r.Amount-sale.Price.Amount)))
+		}
+
+		t, ok := totals[sale.Price.Currency]
+		if !ok {
+			t = &Total{Currency: sale.Price.Currency}
+			totals[sale.Price.Currency] = t
+		}
+		t.Cost += item.Cost
+		t.Savings += item.Savings
+		list.Items = append(list.Items, item)
+	}
+
+	for _, t := range totals {
+		t.CostDisplay = Price{Amount: t.Cost, Currency: t.Currency}.amount()
+		t.SavingsDisplay = Price{Amount: t.Savings, Currency: t.Currency}.amount()
+		list.Totals = append(list.Totals, *t)
+	}
+	sort.Slice(list.Totals, func(i, j int) bool { return list.Totals[i].Currency < list.Totals[j].Currency })
+	return list
+}
+
+// match returns the sale whose name best matches an ingredient name, or nil.
+// Names match as described for matchScore. The closer the word counts, the
+// better the match; ties go to the lower price.
+func match(name string, sales []Sale) *Sale {
+	want := nameTokens(name)
+	if len(want) == 0 {
+		return nil
+	}
+	var best *Sale
+	bestScore := 0.0
+	for i := range sales {
+		score := matchScore(want, nameTokens(sales[i].Name))
+		if score == 0 {
+			continue
+		}
+		if score > bestScore || (score == bestScore && best != nil && sales[i].Price.Amount < best.Price.Amount) {
+			best, bestScore = &sales[i], score
+		}
+	}
+	if best == nil {
+		return nil
+	}
+	sale := *best
+	return &sale
+}
+
+// productForms are head nouns that make the words before them part of the
+// product, e.g. "peanut butter" is not butter and "tomato paste" is not
+// tomatoes.
+var productForms = map[string]bool{
+	"paste": true, "butter": true, "vinegar": true, "juice": true, "powder": true, "noodle": true, "sauce": true,
+}
+
+// matchScore reports how well the tokens of a sale name match those of an
+// ingredient name, or 0 if they name different products. Both must have the
+// same head noun, their last word, and every word of one must appear in the
+// other, ignoring plurals and preparation words like "chopped". If the head
+// noun is one of productForms, both must have the same words.
+func matchScore(want, have []string) float64 {
+	if len(want) == 0 || len(have) == 0 {
+		return 0
+	}
+	head := want[len(want)-1]
+	if head != have[len(have)-1] {
+		return 0
+	}
+	if productForms[head] && !(covers(want, have) && covers(have, want)) {
+		return 0
+	}
+	switch {
+	case covers(want, have):
+		return float64(len(have)) / float64(len(want))
+	case covers(have, want):
+		// The sale is more specific than the ingredient, e.g. "canned
+		// tomatoes" for "tomatoes", which is a weaker match.
+		return 0.5 * float64(len(want)) / float64(len(have))
+	}
+	return 0
+}
+
+// Merge adds up the needs for the same ingredient in compatible units, in
+// g, ml or each if possible. Names are compared like sale names, but must
+// match both ways, so "chicken stock" is not merged with "chicken". Each
+// merged need keeps the name of the first.
+func Merge(needs []Need) []Need {
+	merged := []Need{}
+	for _, n := range needs {
+		q := Normalize(n.Quantity)
+		found := false
+		for i := range merged {
+			m := &merged[i]
+			if m.Quantity.Unit == q.Unit && m.OnHand == n.OnHand && sameProduct(m.Name, n.Name) {
+				m.Quantity.Amount = round(m.Quantity.Amount+q.Amount, 2)
+				found = true
+				break
+			}
+		}
+		if !found {
+			merged = append(merged, Need{Name: n.Name, Quantity: q, OnHand: n.OnHand})
+		}
+	}
+	return merged
+}
+
+// Use takes what needs call for out of stock, ingredients that were already
+// bought, and returns what is left to buy. A need that stock covers entirely
+// is marked OnHand, and one it covers in part asks for the rest. Stock is
+// matched like SameIngredient, in units that convert, and what is used is
+// deducted from it.
+func Use(needs, stock []Need) []Need {
+	out := slices.Clone(needs)
+	for i := range out {
+		n := &out[i]
+		for j := range stock {
+			if n.OnHand || n.Quantity.Amount <= 0 {
+				break
+			}
+			s := &stock[j]
+			if s.Quantity.Amount <= 0 || !SameIngredient(n.Name, s.Name) {
+				continue
+			}
+			have, ok := convert(s.Quantity, n.Quantity.Unit)
+			if !ok || have <= 0 {
+				continue
+			}
+			used := math.Min(have, n.Quantity.Amount)
+			s.Quantity.Amount = round(s.Quantity.Amount*(have-used)/have, 2)
+			if left := round(n.Quantity.Amount-used, 2); left > 0 {
+				n.Quantity.Amount = left
+			} else {
+				n.OnHand = true
+			}
+		}
+	}
+	return out
+}
+
+func sameProduct(a, b string) bool {
+	ta, tb := nameTokens(a), nameTokens(b)
+	return len(ta) > 0 && len(tb) > 0 && covers(ta, tb) && covers(tb, ta)
+}
+
+// SameIngredient reports whether two ingredient names refer to the same
+// product, using the same rules as sale matching, e.g. "chopped garlic" and
+// "garlic" but not "garlic powder" and "garlic".
+func SameIngredient(a, b string) bool {
+	return matchScore(nameTokens(a), nameTokens(b)) > 0
+}
+
+// covers reports whether every token of sub is one of tokens.
+func covers(tokens, sub []string) bool {
+	for _, s := range sub {
+		if !slices.Contains(tokens, s) {
+			return false
+		}
+	}
+	return true
+}
+
+// descriptors are words that do not change which product to buy.
+var descriptors = map[string]bool{
+	"fresh": true, "large": true, "small": true, "medium": true, "chopped": true, "diced": true, "minced": true,
+	"sliced": true, "grated": true, "shredded": true, "boneless": true, "skinless": true, "ripe": true,
+	"extra": true, "virgin": true, "of": true, "and": true, "or": true, "to": true, "taste": true, "a": true,
+	"for": true, "finely": true, "roughly": true, "freshly": true, "ground": true, "optional": true,
+}
+
+// nameTokens splits an ingredient name into singular, lowercase words,
+// without descriptors.
+func nameTokens(name string) []string {
+	var tokens []string
+	for _, w := range strings.FieldsFunc(strings.ToLower(name), func(r rune) bool { return !unicode.IsLetter(r) }) {
+		if descriptors[w] {
+			continue
+		}
+		tokens = append(tokens, singular(w))
+	}
+	return tokens
+}
+
+func singular(w string) string {
+	switch {
+	case len(w) <= 3:
+		return w
+	case strings.HasSuffix(w, "ies"):
+		return w[:len(w)-3] + "y"
+	case strings.HasSuffix(w, "oes"), strings.HasSuffix(w, "ches"), strings.HasSuffix(w, "shes"), strings.HasSuffix(w, "sses"), strings.HasSuffix(w, "xes"):
+		return w[:len(w)-2]
+	case strings.HasSuffix(w, "s") && !strings.HasSuffix(w, "ss"):
+		return w[:len(w)-1]
+	}
+	return w
+}
+
+func round(x float64, decimals int) float64 {
+	p := math.Pow(10, float64(decimals))
+	return math.Round(x*p) / p
+}
@@ -0,0 +1,39 @@
+package pricing
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestMatchDefaultCatalog(t *testing.T) {
+	c, err := Open("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	saturday := time.Date(2030, 1, 5, 12, 0, 0, 0, time.UTC)
+	sales := append(c.OnSale("", saturday), c.OnSale("", saturday.AddDate(0, 0, 2))...)
+
+	tests := []struct {
+		ingredient string
+		notSale    string
+	}{
+		{"tomato paste", "pasta"},
+		{"peanut butter", "butter"},
+		{"rice vinegar", "rice"},
+		{"egg noodles", "eggs"},
+		{"lemon juice", "lemons"},
+		{"garlic powder", "garlic"},
+	}
+	for _, tt := range tests {
+		if !slices.ContainsFunc(sales, func(s Sale) bool { return s.Name == tt.notSale }) {
+			t.Fatalf("the default catalog has no %q on sale", tt.notSale)
+		}
+		if s := match(tt.ingredient, sales); s != nil {
+			t.Errorf("match(%q) = %q, want no sale", tt.ingredient, s.Name)
+		}
+		if SameIngredient(tt.ingredient, tt.notSale) {
+			t.Errorf("SameIngredient(%q, %q) = true, want false", tt.ingredient, tt.notSale)
+		}
+	}
+}
@@ -0,0 +1,23 @@
+package bargainchef
+
+import (
+	"net/http"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// validate checks the `validate` tags of the flow inputs. The Gin and Echo
+// quickstarts check the same tags with their frameworks' validators.
+var validate = validator.New(validator.WithRequiredStructEnabled())
+
+// Bind checks the input's validate tags. It makes *CravingInput a payload in
+// go-chi/render's Binder convention, which the chi quickstart calls once the
+// input is decoded.
+func (in *CravingInput) Bind(*http.Request) error {
+	return validate.Struct(in)
+}
+
+// Bind checks the input's validate tags, as CravingInput.Bind does.
+func (in *MealPlanInput) Bind(*http.Request) error {
+	return validate.Struct(in)
+}
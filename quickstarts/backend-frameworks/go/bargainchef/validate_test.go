@@ -0,0 +1,28 @@
+package bargainchef
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestBind(t *testing.T) {
+	tests := []struct {
+		name  string
+		input interface{ Bind(r *http.Request) error }
+		ok    bool
+	}{
+		{"craving", &CravingInput{Craving: "soup", Constraints: Constraints{Servings: 4, Currency: "EUR"}}, true},
+		{"no craving", &CravingInput{}, false},
+		{"negative budget", &CravingInput{Craving: "soup", Constraints: Constraints{MaxBudget: -1}}, false},
+		{"unknown currency", &CravingInput{Craving: "soup", Constraints: Constraints{Currency: "eur"}}, false},
+		{"too many servings", &CravingInput{Craving: "soup", Constraints: Constraints{Servings: 101}}, false},
+		{"meal plan", &MealPlanInput{StartDate: "2030-01-04"}, true},
+		{"empty meal plan", &MealPlanInput{}, true},
+		{"bad start date", &MealPlanInput{StartDate: "Jan 4"}, false},
+	}
+	for _, tt := range tests {
+		if err := tt.input.Bind(nil); (err == nil) != tt.ok {
+			t.Errorf("%s: Bind() = %v, want ok %v", tt.name, err, tt.ok)
+		}
+	}
+}
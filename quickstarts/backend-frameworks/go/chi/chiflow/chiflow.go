@@ -0,0 +1,136 @@
+// Package chiflow serves Genkit flows as handlers for chi routers. Unlike
+// genkit.Handler(flow), its handlers validate the input with the Bind method
+// of go-chi/render's Binder convention, put chi's request ID in the flow's
+// action context and log flow errors with it.
+package chiflow
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"net/http"
+	"strconv"
+
+	"flow-transports/go/transport"
+
+	"github.com/firebase/genkit/go/core"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// RequestIDKey is the key of the request ID in the action context of a flow.
+const RequestIDKey = "requestId"
+
+// Binder is implemented by inputs that check themselves once decoded, as
+// payloads of go-chi/render do.
+type Binder interface {
+	Bind(r *http.Request) error
+}
+
+// Handler returns a handler that runs flow with the "data" field of the JSON
+// request body, in the same formats as genkit.Handler: it answers
+// {"result": output}, or streams {"message": chunk} events then a result or
+// {"error": {...}} event when the request accepts text/event-stream or has
+// ?stream=true.
+//
+// If a pointer to the input is a Binder, its Bind method is called once the
+// input is decoded, and an error rejects the request. The flow runs under the request's
+// context, so it sees the values that middleware put in it, and its action
+// context (core.FromContext) has the request ID of chi's RequestID middleware.
+//
+// Invalid input is answered with 400 Bad Request, and flow errors with the
+// HTTP status of their Genkit status, as by http.Error. Errors of streamed
+// runs end the stream with an error event, since the status has been sent.
+// Flow errors are logged with the request ID.
+func Handler[In, Out, Stream any](flow *core.Flow[In, Out, Stream]) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Data In `json:"data"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := bind(&req.Data, r); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		stream := r.Header.Get("Accept") == "text/event-stream"
+		if s := r.URL.Query().Get("stream"); s != "" {
+			b, err := strconv.ParseBool(s)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			stream = stream || b
+		}
+
+		actx := core.ActionContext{}
+		maps.Copy(actx, core.FromContext(r.Context()))
+		if id := middleware.GetReqID(r.Context()); id != "" {
+			actx[RequestIDKey] = id
+		}
+		ctx := core.WithActionContext(r.Context(), actx)
+		if !stream {
+			out, err := flow.Run(ctx, req.Data)
+			if err != nil {
+				logError(r, flow.Name(), err)
+				http.Error(w, err.Error(), core.HTTPStatusCode(transport.NewFlowError(err).Status))
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]any{"result": out})
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.WriteHeader(http.StatusOK)
+		for v, err := range flow.Stream(ctx, req.Data) {
+			var event map[string]any
+			switch {
+			case err != nil:
+				logError(r, flow.Name(), err)
+				event = map[string]any{"error": transport.NewFlowError(err)}
+			case v.Done:
+				event = map[string]any{"result": v.Output}
+			default:
+				event = map[string]any{"message": v.Stream}
+			}
+			if err := writeEvent(w, event); err != nil {
+				break
+			}
+		}
+	}
+}
+
+// bind calls the Bind method of the input, if it has one.
+func bind[In any](in *In, r *http.Request) error {
+	if b, ok := any(in).(Binder); ok {
+		return b.Bind(r)
+	}
+	return nil
+}
+
+// logError logs the error of a flow run with the request ID.
+func logError(r *http.Request, flow string, err error) {
+	log.Printf("[%s] %s failed: %v", middleware.GetReqID(r.Context()), flow, err)
+}
+
+// writeEvent writes a server-sent event with payload as its data.
+func writeEvent(w http.ResponseWriter, payload map[string]any) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "data: %s\n\n", data); err != nil {
+		return err
+	}
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return nil
+}
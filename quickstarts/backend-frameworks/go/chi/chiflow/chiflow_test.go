@@ -0,0 +1,105 @@
+package chiflow
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/firebase/genkit/go/core"
+	"github.com/firebase/genkit/go/genkit"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+type greetInput struct {
+	Name string `json:"name"`
+}
+
+func (in *greetInput) Bind(*http.Request) error {
+	if in.Name == "" {
+		return errors.New("name is required")
+	}
+	return nil
+}
+
+type greeting struct {
+	Text      string `json:"text"`
+	User      any    `json:"user"`
+	RequestID any    `json:"requestId"`
+}
+
+// greetFlow greets the user, with the user and request ID of its action
+// context, and fails for the names "nobody" and "crash".
+func greetFlow() *core.Flow[greetInput, greeting, struct{}] {
+	g := genkit.Init(context.Background())
+	return genkit.DefineFlow(g, "greet", func(ctx context.Context, in greetInput) (greeting, error) {
+		switch in.Name {
+		case "nobody":
+			return greeting{}, core.NewError(core.NOT_FOUND, "no one to greet")
+		case "crash":
+			return greeting{}, errors.New("crashed")
+		}
+		actx := core.FromContext(ctx)
+		return greeting{Text: "Hello, " + in.Name, User: actx["user"], RequestID: actx[RequestIDKey]}, nil
+	})
+}
+
+func TestHandler(t *testing.T) {
+	r := chi.NewRouter()
+	r.Use(middleware.RequestID)
+	r.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := core.WithActionContext(r.Context(), core.ActionContext{"user": "ada"})
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	})
+	r.Post("/greet", Handler(greetFlow()))
+
+	tests := []struct {
+		name, body string
+		status     int
+		// want is the JSON of the result, or the text the error must have.
+		want string
+	}{
+		{"ok", `{"data":{"name":"Bob"}}`, http.StatusOK, `{"text":"Hello, Bob","user":"ada","requestId":"req-1"}`},
+		{"missing name", `{"data":{}}`, http.StatusBadRequest, "name is required"},
+		{"empty body", ``, http.StatusBadRequest, "name is required"},
+		{"malformed", `{"data":`, http.StatusBadRequest, "unexpected EOF"},
+		{"not found", `{"data":{"name":"nobody"}}`, http.StatusNotFound, "no one to greet"},
+		{"internal", `{"data":{"name":"crash"}}`, http.StatusInternalServerError, "crashed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/greet", strings.NewReader(tt.body))
+			req.Header.Set(middleware.RequestIDHeader, "req-1")
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if tt.status != http.StatusOK {
+				// Errors are plain text, as written by http.Error.
+				if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+					t.Errorf("Content-Type = %q, want text/plain", ct)
+				}
+				if !strings.Contains(w.Body.String(), tt.want) {
+					t.Errorf("body = %q, want it to contain %q", w.Body, tt.want)
+				}
+				return
+			}
+			var body struct {
+				Result json.RawMessage `json:"result"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatalf("body %q: %v", w.Body, err)
+			}
+			if string(body.Result) != tt.want {
+				t.Errorf("result = %s, want %s", body.Result, tt.want)
+			}
+		})
+	}
+}
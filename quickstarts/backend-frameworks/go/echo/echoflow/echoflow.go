@@ -0,0 +1,135 @@
+// Package echoflow serves Genkit flows as Echo handlers. Unlike
+// echo.WrapHandler(genkit.Handler(flow)), its handlers bind and validate the
+// input with Echo, pass values of the echo.Context on to the flow and return
+// errors as *echo.HTTPError, which Echo's HTTPErrorHandler answers and its
+// logger records.
+package echoflow
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"strconv"
+
+	"flow-transports/go/transport"
+
+	"github.com/firebase/genkit/go/core"
+	"github.com/labstack/echo/v4"
+)
+
+// RequestIDKey is the key of the request ID in the action context of a flow.
+const RequestIDKey = "requestId"
+
+// Handler returns a handler that runs flow with the "data" field of the JSON
+// request body, in the same formats as genkit.Handler: it answers
+// {"result": output}, or streams {"message": chunk} events then a result or
+// {"error": {...}} event when the request accepts text/event-stream or has
+// ?stream=true.
+//
+// The input is bound with the Echo instance's Binder and, if it has a
+// Validator, validated with it. The flow's action context (core.FromContext)
+// has the values of the echo.Context with the given keys, such as a user set
+// by an authentication middleware, and the request ID set by the RequestID
+// middleware or sent by the client in the X-Request-ID header.
+//
+// Invalid input is a 400 Bad Request error, and flow errors have the HTTP
+// status of their Genkit status. Errors of streamed runs end the stream with
+// an error event, since the status has been sent, and are still returned so
+// that the logger records them.
+func Handler[In, Out, Stream any](flow *core.Flow[In, Out, Stream], keys ...string) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var req struct {
+			Data In `json:"data"`
+		}
+		if err := c.Bind(&req); err != nil {
+			return err
+		}
+		if c.Echo().Validator != nil {
+			// The request is validated rather than the input, which need not
+			// be a struct.
+			if err := c.Validate(&req); err != nil {
+				var he *echo.HTTPError
+				if errors.As(err, &he) {
+					return err
+				}
+				return echo.NewHTTPError(http.StatusBadRequest, err.Error()).SetInternal(err)
+			}
+		}
+		stream := c.Request().Header.Get(echo.HeaderAccept) == "text/event-stream"
+		if s := c.QueryParam("stream"); s != "" {
+			b, err := strconv.ParseBool(s)
+			if err != nil {
+				return echo.NewHTTPError(http.StatusBadRequest, err.Error()).SetInternal(err)
+			}
+			stream = stream || b
+		}
+
+		ctx := core.WithActionContext(c.Request().Context(), actionContext(c, keys))
+		if !stream {
+			out, err := flow.Run(ctx, req.Data)
+			if err != nil {
+				return echo.NewHTTPError(core.HTTPStatusCode(transport.NewFlowError(err).Status), err.Error()).SetInternal(err)
+			}
+			return c.JSON(http.StatusOK, map[string]any{"result": out})
+		}
+
+		resp := c.Response()
+		resp.Header().Set(echo.HeaderContentType, "text/event-stream")
+		resp.Header().Set(echo.HeaderCacheControl, "no-cache")
+		resp.Header().Set(echo.HeaderConnection, "keep-alive")
+		resp.WriteHeader(http.StatusOK)
+		var flowErr error
+		for v, err := range flow.Stream(ctx, req.Data) {
+			var event map[string]any
+			switch {
+			case err != nil:
+				flowErr = err
+				event = map[string]any{"error": transport.NewFlowError(err)}
+			case v.Done:
+				event = map[string]any{"result": v.Output}
+			default:
+				event = map[string]any{"message": v.Stream}
+			}
+			if err := writeEvent(resp, event); err != nil {
+				return err
+			}
+		}
+		return flowErr
+	}
+}
+
+// actionContext returns the action context of a flow run for c: that of the
+// request, if any, with the values of c with the given keys and the request
+// ID.
+func actionContext(c echo.Context, keys []string) core.ActionContext {
+	actx := core.ActionContext{}
+	maps.Copy(actx, core.FromContext(c.Request().Context()))
+	for _, k := range keys {
+		if v := c.Get(k); v != nil {
+			actx[k] = v
+		}
+	}
+	id := c.Response().Header().Get(echo.HeaderXRequestID)
+	if id == "" {
+		id = c.Request().Header.Get(echo.HeaderXRequestID)
+	}
+	if id != "" {
+		actx[RequestIDKey] = id
+	}
+	return actx
+}
+
+// writeEvent writes a server-sent event with payload as its data.
+func writeEvent(resp *echo.Response, payload map[string]any) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(resp, "data: %s\n\n", data); err != nil {
+		return err
+	}
+	resp.Flush()
+	return nil
+}
@@ -0,0 +1,103 @@
+package echoflow
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/firebase/genkit/go/core"
+	"github.com/firebase/genkit/go/genkit"
+	"github.com/go-playground/validator/v10"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+)
+
+type greetInput struct {
+	Name string `json:"name" validate:"required"`
+}
+
+type greeting struct {
+	Text      string `json:"text"`
+	User      any    `json:"user"`
+	RequestID any    `json:"requestId"`
+}
+
+// greetFlow greets the user, with the user and request ID of its action
+// context, and fails for the names "nobody" and "crash".
+func greetFlow() *core.Flow[greetInput, greeting, struct{}] {
+	g := genkit.Init(context.Background())
+	return genkit.DefineFlow(g, "greet", func(ctx context.Context, in greetInput) (greeting, error) {
+		switch in.Name {
+		case "nobody":
+			return greeting{}, core.NewError(core.NOT_FOUND, "no one to greet")
+		case "crash":
+			return greeting{}, errors.New("crashed")
+		}
+		actx := core.FromContext(ctx)
+		return greeting{Text: "Hello, " + in.Name, User: actx["user"], RequestID: actx[RequestIDKey]}, nil
+	})
+}
+
+type structValidator struct {
+	v *validator.Validate
+}
+
+func (sv *structValidator) Validate(i any) error {
+	return sv.v.Struct(i)
+}
+
+func TestHandler(t *testing.T) {
+	e := echo.New()
+	e.Validator = &structValidator{validator.New()}
+	e.Use(middleware.RequestID())
+	e.Use(func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			c.Set("user", "ada")
+			return next(c)
+		}
+	})
+	e.POST("/greet", Handler(greetFlow(), "user"))
+
+	tests := []struct {
+		name, body string
+		status     int
+		// want is the JSON of the result, or the text the error must have.
+		want string
+	}{
+		{"ok", `{"data":{"name":"Bob"}}`, http.StatusOK, `{"text":"Hello, Bob","user":"ada","requestId":"req-1"}`},
+		{"missing name", `{"data":{}}`, http.StatusBadRequest, "'required' tag"},
+		{"malformed", `{"data":`, http.StatusBadRequest, "unexpected EOF"},
+		{"not found", `{"data":{"name":"nobody"}}`, http.StatusNotFound, "no one to greet"},
+		{"internal", `{"data":{"name":"crash"}}`, http.StatusInternalServerError, "crashed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/greet", strings.NewReader(tt.body))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			req.Header.Set(echo.HeaderXRequestID, "req-1")
+			w := httptest.NewRecorder()
+			e.ServeHTTP(w, req)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			var body struct {
+				Result  json.RawMessage `json:"result"`
+				Message string          `json:"message"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatalf("body %q: %v", w.Body, err)
+			}
+			if tt.status == http.StatusOK {
+				if string(body.Result) != tt.want {
+					t.Errorf("result = %s, want %s", body.Result, tt.want)
+				}
+			} else if !strings.Contains(body.Message, tt.want) {
+				t.Errorf("message = %q, want it to contain %q", body.Message, tt.want)
+			}
+		})
+	}
+}
@@ -2,124 +2,64 @@ package main
 
 import (
 	"context"
-	"errors"
-	"fmt"
 	"log"
-	"time"
 
-	"github.com/firebase/genkit/go/ai"
-	"github.com/firebase/genkit/go/genkit"
-	"github.com/firebase/genkit/go/plugins/googlegenai"
+	"example/bargainchef"
+	"example/quickstart-echo/echoflow"
+	"flow-transports/go/transport"
+
+	"github.com/go-playground/validator/v10"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
-	"google.golang.org/genai"
 )
 
-type BargainChefInput struct {
-	Craving string `json:"craving" jsonschema:"description=What the user feels like eating right now."`
-}
-
-type RecipeIngredient struct {
-	Name     string `json:"name"`
-	Quantity string `json:"quantity"`
-	OnSale   bool   `json:"onSale"`
-}
-
-type Recipe struct {
-	Title       string             `json:"title"`
-	Description string             `json:"description"`
-	Servings    int                `json:"servings"`
-	Ingredients []RecipeIngredient `json:"ingredients"`
-	Steps       []string           `json:"steps"`
+// inputValidator checks the validate tags of the flow inputs.
+type inputValidator struct {
+	v *validator.Validate
 }
 
-type SaleIngredient struct {
-	Name  string `json:"name"`
-	Price string `json:"price"`
-}
-
-type SaleInput struct {
-	DayType string `json:"dayType" jsonschema:"enum=weekday,enum=weekend,description=Whether to fetch weekday or weekend sale prices."`
+func (iv *inputValidator) Validate(i any) error {
+	return iv.v.Struct(i)
 }
 
 func main() {
 	ctx := context.Background()
 
-	g := genkit.Init(ctx,
-		genkit.WithPlugins(&googlegenai.GoogleAI{}),
-	)
-
-	getIngredientsOnSale := genkit.DefineTool(g, "getIngredientsOnSale",
-		"Returns the ingredients on sale at the local grocery store, with prices. The sale set differs between weekdays and weekends.",
-		func(ctx *ai.ToolContext, input SaleInput) ([]SaleIngredient, error) {
-			if input.DayType == "weekend" {
-				return []SaleIngredient{
-					{Name: "chicken breast", Price: "$2.99/lb"},
-					{Name: "pasta", Price: "$0.79"},
-					{Name: "canned tomatoes", Price: "$0.99"},
-					{Name: "garlic", Price: "$0.50 / head"},
-					{Name: "olive oil", Price: "$6.99"},
-				}, nil
-			}
-			return []SaleIngredient{
-				{Name: "eggs", Price: "$3.49 / dozen"},
-				{Name: "spinach", Price: "$1.99"},
-				{Name: "parmesan", Price: "$4.99"},
-				{Name: "lemons", Price: "$0.50 each"},
-				{Name: "rice", Price: "$2.49"},
-				{Name: "butter", Price: "$3.99"},
-			}, nil
-		},
-	)
-
-	bargainChefFlow := genkit.DefineStreamingFlow(g, "bargainChefFlow",
-		func(ctx context.Context, input BargainChefInput, sendChunk func(context.Context, *Recipe) error) (*Recipe, error) {
-			today := time.Now().Weekday().String()
-
-			prompt := fmt.Sprintf(`Today is %s. The user is craving: %s.
-
-Call the getIngredientsOnSale tool with the dayType that matches today. Saturday and Sunday are weekends; all other days are weekdays. Then propose ONE recipe that takes advantage of those deals. For each ingredient, set onSale=true if it appears in the tool's response, false otherwise.`, today, input.Craving)
-
-			var final *Recipe
-			stream := genkit.GenerateDataStream[*Recipe](ctx, g,
-				ai.WithModelName("googleai/gemini-flash-latest"),
-				ai.WithConfig(&genai.GenerateContentConfig{
-					ThinkingConfig: &genai.ThinkingConfig{
-						ThinkingLevel: genai.ThinkingLevelMinimal,
-					},
-				}),
-				ai.WithPrompt(prompt),
-				ai.WithTools(getIngredientsOnSale),
-			)
-			for result, err := range stream {
-				if err != nil {
-					return nil, fmt.Errorf("failed to generate recipe: %w", err)
-				}
-				if result.Done {
-					final = result.Output
-					break
-				}
-				if result.Chunk != nil {
-					if err := sendChunk(ctx, result.Chunk); err != nil {
-						return nil, err
-					}
-				}
-			}
-
-			if final == nil {
-				return nil, errors.New("failed to generate recipe")
-			}
-			return final, nil
-		},
-	)
+	g := bargainchef.Init(ctx)
+	catalog, err := bargainchef.LoadCatalog()
+	if err != nil {
+		log.Fatalf("failed to load catalog: %v", err)
+	}
+	bargainChefFlow := bargainchef.DefineFlow(g, catalog)
+	mealPlanFlow := bargainchef.DefineMealPlanFlow(g, catalog)
+	jobs, err := bargainchef.Jobs(bargainChefFlow, mealPlanFlow)
+	if err != nil {
+		log.Fatalf("failed to load jobs: %v", err)
+	}
 
 	e := echo.New()
+	e.Validator = &inputValidator{validator.New(validator.WithRequiredStructEnabled())}
+	e.Use(middleware.RequestID())
 	e.Use(middleware.Logger())
 	e.Use(middleware.CORS())
-	e.POST("/bargainChefFlow", echo.WrapHandler(genkit.Handler(bargainChefFlow)))
-
-	log.Println("Echo server listening on http://localhost:8080")
-	if err := e.Start(":8080"); err != nil {
+	e.POST("/bargainChefFlow", echoflow.Handler(bargainChefFlow))
+	e.POST("/mealPlanFlow", echoflow.Handler(mealPlanFlow))
+	flows := []transport.Flow{bargainChefFlow, mealPlanFlow}
+	for _, flow := range flows {
+		sse := echo.WrapHandler(transport.SSEHandler(flow))
+		e.GET("/sse/"+flow.Name(), sse)
+		e.POST("/sse/"+flow.Name(), sse)
+	}
+	// Any origin may connect, like the CORS settings above.
+	e.GET("/ws", echo.WrapHandler(transport.WebSocketHandler(flows, transport.WithAllowedOrigins("*"))))
+	e.POST("/runs/:id/cancel", echo.WrapHandler(transport.CancelHandler()))
+	e.POST("/jobs/:flow", echo.WrapHandler(jobs))
+	e.GET("/jobs/:id", echo.WrapHandler(jobs))
+	e.Any("/admin/*", echo.WrapHandler(bargainchef.AdminHandler(catalog)))
+
+	addr := bargainchef.Addr()
+	log.Printf("Echo server listening on http://localhost%s", addr)
+	if err := e.Start(addr); err != nil {
 		log.Fatalf("server error: %v", err)
 	}
 }
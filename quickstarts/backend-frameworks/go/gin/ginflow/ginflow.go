@@ -0,0 +1,131 @@
+// Package ginflow serves Genkit flows as Gin handlers. Unlike
+// gin.WrapH(genkit.Handler(flow)), its handlers bind and validate the input
+// with Gin, pass the values of the gin.Context on to the flow and report
+// errors on the gin.Context, where Gin's logger and middleware see them.
+package ginflow
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"strconv"
+
+	"flow-transports/go/transport"
+
+	"github.com/firebase/genkit/go/core"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+// RequestIDKey is the key of the request ID in the action context of a flow.
+const RequestIDKey = "requestId"
+
+// Handler returns a handler that runs flow with the "data" field of the JSON
+// request body, in the same formats as genkit.Handler: it answers
+// {"result": output}, or streams {"message": chunk} events then a result or
+// {"error": {...}} event when the request accepts text/event-stream or has
+// ?stream=true.
+//
+// The input is bound with Gin's JSON binding, so the tags of its fields are
+// checked by Gin's validator: `binding` tags, unless binding.Validator has
+// been told to use others. The flow's action context
+// (core.FromContext) has the gin.Context's Keys, such as a user set by an
+// authentication middleware, and the request ID from the X-Request-ID header
+// of the response, as set by a request ID middleware, or of the request.
+//
+// Errors are added to the gin.Context's Errors. Invalid input is answered
+// with 400 Bad Request, and flow errors with the HTTP status of their Genkit
+// status, both with {"error": message}. Errors of streamed runs end the
+// stream with an error event, since the status has been sent.
+func Handler[In, Out, Stream any](flow *core.Flow[In, Out, Stream]) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			Data In `json:"data"`
+		}
+		err := c.ShouldBindJSON(&req)
+		if errors.Is(err, io.EOF) && binding.Validator != nil {
+			// An empty body is a zero input, which must still be valid.
+			err = binding.Validator.ValidateStruct(&req)
+		}
+		if err != nil && !errors.Is(err, io.EOF) {
+			c.Error(err).SetType(gin.ErrorTypeBind)
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		stream := c.GetHeader("Accept") == "text/event-stream"
+		if s := c.Query("stream"); s != "" {
+			b, err := strconv.ParseBool(s)
+			if err != nil {
+				c.Error(err).SetType(gin.ErrorTypeBind)
+				c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			stream = stream || b
+		}
+
+		ctx := core.WithActionContext(c.Request.Context(), actionContext(c))
+		if !stream {
+			out, err := flow.Run(ctx, req.Data)
+			if err != nil {
+				c.Error(err)
+				c.AbortWithStatusJSON(core.HTTPStatusCode(transport.NewFlowError(err).Status), gin.H{"error": err.Error()})
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{"result": out})
+			return
+		}
+
+		c.Header("Content-Type", "text/event-stream")
+		c.Header("Cache-Control", "no-cache")
+		c.Header("Connection", "keep-alive")
+		c.Status(http.StatusOK)
+		for v, err := range flow.Stream(ctx, req.Data) {
+			var event gin.H
+			switch {
+			case err != nil:
+				c.Error(err)
+				event = gin.H{"error": transport.NewFlowError(err)}
+			case v.Done:
+				event = gin.H{"result": v.Output}
+			default:
+				event = gin.H{"message": v.Stream}
+			}
+			if err := writeEvent(c, event); err != nil {
+				c.Error(err)
+				break
+			}
+		}
+	}
+}
+
+// actionContext returns the action context of a flow run for c: that of the
+// request, if any, with the Keys of c and the request ID.
+func actionContext(c *gin.Context) core.ActionContext {
+	actx := core.ActionContext{}
+	maps.Copy(actx, core.FromContext(c.Request.Context()))
+	maps.Copy(actx, c.Keys)
+	id := c.Writer.Header().Get("X-Request-ID")
+	if id == "" {
+		id = c.GetHeader("X-Request-ID")
+	}
+	if id != "" {
+		actx[RequestIDKey] = id
+	}
+	return actx
+}
+
+// writeEvent writes a server-sent event with payload as its data.
+func writeEvent(c *gin.Context, payload gin.H) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(c.Writer, "data: %s\n\n", data); err != nil {
+		return err
+	}
+	c.Writer.Flush()
+	return nil
+}
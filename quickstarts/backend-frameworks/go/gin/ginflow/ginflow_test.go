@@ -0,0 +1,88 @@
+package ginflow
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/firebase/genkit/go/core"
+	"github.com/firebase/genkit/go/genkit"
+	"github.com/gin-gonic/gin"
+)
+
+type greetInput struct {
+	Name string `json:"name" binding:"required"`
+}
+
+type greeting struct {
+	Text      string `json:"text"`
+	User      any    `json:"user"`
+	RequestID any    `json:"requestId"`
+}
+
+// greetFlow greets the user, with the user and request ID of its action
+// context, and fails for the names "nobody" and "crash".
+func greetFlow() *core.Flow[greetInput, greeting, struct{}] {
+	g := genkit.Init(context.Background())
+	return genkit.DefineFlow(g, "greet", func(ctx context.Context, in greetInput) (greeting, error) {
+		switch in.Name {
+		case "nobody":
+			return greeting{}, core.NewError(core.NOT_FOUND, "no one to greet")
+		case "crash":
+			return greeting{}, errors.New("crashed")
+		}
+		actx := core.FromContext(ctx)
+		return greeting{Text: "Hello, " + in.Name, User: actx["user"], RequestID: actx[RequestIDKey]}, nil
+	})
+}
+
+func TestHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		c.Header("X-Request-ID", "req-1")
+		c.Set("user", "ada")
+	})
+	r.POST("/greet", Handler(greetFlow()))
+
+	tests := []struct {
+		name, body string
+		status     int
+		// want is the JSON of the result, or the text the error must have.
+		want string
+	}{
+		{"ok", `{"data":{"name":"Bob"}}`, http.StatusOK, `{"text":"Hello, Bob","user":"ada","requestId":"req-1"}`},
+		{"missing name", `{"data":{}}`, http.StatusBadRequest, "'required' tag"},
+		{"empty body", ``, http.StatusBadRequest, "'required' tag"},
+		{"malformed", `{"data":`, http.StatusBadRequest, "unexpected EOF"},
+		{"not found", `{"data":{"name":"nobody"}}`, http.StatusNotFound, "no one to greet"},
+		{"internal", `{"data":{"name":"crash"}}`, http.StatusInternalServerError, "crashed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/greet", strings.NewReader(tt.body)))
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			var body struct {
+				Result json.RawMessage `json:"result"`
+				Error  string          `json:"error"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatalf("body %q: %v", w.Body, err)
+			}
+			if tt.status == http.StatusOK {
+				if string(body.Result) != tt.want {
+					t.Errorf("result = %s, want %s", body.Result, tt.want)
+				}
+			} else if !strings.Contains(body.Error, tt.want) {
+				t.Errorf("error = %q, want it to contain %q", body.Error, tt.want)
+			}
+		})
+	}
+}
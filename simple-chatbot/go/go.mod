@@ -4,7 +4,10 @@ go 1.24.1
 
 toolchain go1.24.5
 
-require github.com/firebase/genkit/go v1.1.0
+require (
+	flow-transports/go v0.0.0
+	github.com/firebase/genkit/go v1.1.0
+)
 
 require (
 	cloud.google.com/go v0.120.0 // indirect
@@ -47,3 +50,5 @@ require (
 	google.golang.org/protobuf v1.36.6 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
+
+replace flow-transports/go => ../../flow-transports/go
@@ -8,6 +8,8 @@ import (
 	"simple-chatbot/go/flows"
 	"simple-chatbot/go/tools"
 
+	"flow-transports/go/transport"
+
 	"github.com/firebase/genkit/go/genkit"
 	"github.com/firebase/genkit/go/plugins/googlegenai"
 	"github.com/firebase/genkit/go/plugins/server"
@@ -29,6 +31,14 @@ func main() {
 	mux.HandleFunc("OPTIONS /flows/chat", corsMiddleware(nil))
 	mux.HandleFunc("POST /flows/chat", corsMiddleware(genkit.Handler(chatFlow)))
 
+	// The chat is also served as server-sent events and over WebSocket.
+	chatSSE := corsMiddleware(transport.SSEHandler(chatFlow))
+	mux.HandleFunc("OPTIONS /sse/chat", corsMiddleware(nil))
+	mux.HandleFunc("GET /sse/chat", chatSSE)
+	mux.HandleFunc("POST /sse/chat", chatSSE)
+	// Any origin may connect, like the CORS settings of the other routes.
+	mux.Handle("GET /ws", transport.WebSocketHandler([]transport.Flow{chatFlow}, transport.WithAllowedOrigins("*")))
+
 	mux.HandleFunc("OPTIONS /flows/getHistory", corsMiddleware(nil))
 	mux.HandleFunc("POST /flows/getHistory", corsMiddleware(genkit.Handler(historyFlow)))
 
@@ -40,7 +50,7 @@ func corsMiddleware(next http.Handler) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Last-Event-ID")
 		if r.Method == "OPTIONS" {
 			w.WriteHeader(http.StatusOK)
 			return